@@ -0,0 +1,166 @@
+// Command mockoidc is a development OpenID Connect issuer that logs everyone
+// in without asking: /authorize redirects straight back with a code for the
+// configured user. Point MediaHub at it to try single sign-on locally:
+//
+//	go run ./cmd/mockoidc -addr :9400 -sub alice -username alice -groups mediahub-admins
+//	OIDC_ISSUER=http://localhost:9400 OIDC_CLIENT_ID=mediahub \
+//	OIDC_REDIRECT_URL=http://localhost:8080/api/auth/oidc/callback ...
+//
+// The user can also be picked per login with ?login_hint=<sub> on the
+// authorization URL. Never expose it beyond localhost.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type grant struct {
+	clientID, redirectURI, nonce, challenge, sub string
+	expires                                      time.Time
+}
+
+func main() {
+	addr := flag.String("addr", ":9400", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9400", "issuer URL (as MediaHub reaches it)")
+	sub := flag.String("sub", "mock-user", "subject of the logged in user")
+	username := flag.String("username", "mockuser", "preferred_username claim")
+	email := flag.String("email", "mockuser@example.com", "email claim")
+	groups := flag.String("groups", "", "comma separated groups claim")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var mu sync.Mutex
+	grants := map[string]grant{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                *issuer,
+			"authorization_endpoint":                *issuer + "/authorize",
+			"token_endpoint":                        *issuer + "/token",
+			"jwks_uri":                              *issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "mock", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "need response_type=code and an S256 code_challenge", 400)
+			return
+		}
+		redirect, err := url.Parse(q.Get("redirect_uri"))
+		if err != nil || redirect.Host == "" {
+			http.Error(w, "bad redirect_uri", 400)
+			return
+		}
+		g := grant{
+			clientID: q.Get("client_id"), redirectURI: q.Get("redirect_uri"), nonce: q.Get("nonce"),
+			challenge: q.Get("code_challenge"), sub: *sub, expires: time.Now().Add(time.Minute),
+		}
+		if hint := q.Get("login_hint"); hint != "" {
+			g.sub = hint
+		}
+		code := randomString()
+		mu.Lock()
+		grants[code] = g
+		mu.Unlock()
+
+		rq := redirect.Query()
+		rq.Set("code", code)
+		rq.Set("state", q.Get("state"))
+		redirect.RawQuery = rq.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		code := r.PostForm.Get("code")
+		mu.Lock()
+		g, ok := grants[code]
+		delete(grants, code)
+		mu.Unlock()
+
+		clientID := r.PostForm.Get("client_id")
+		if u, _, ok := r.BasicAuth(); ok {
+			clientID, _ = url.QueryUnescape(u)
+		}
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		switch {
+		case !ok || time.Now().After(g.expires):
+			tokenError(w, "invalid_grant", "unknown or expired code")
+			return
+		case clientID != g.clientID || r.PostForm.Get("redirect_uri") != g.redirectURI:
+			tokenError(w, "invalid_grant", "client or redirect_uri mismatch")
+			return
+		case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+			tokenError(w, "invalid_grant", "PKCE verification failed")
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss": *issuer, "sub": g.sub, "aud": g.clientID, "nonce": g.nonce,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(5 * time.Minute).Unix(),
+			"preferred_username": *username, "email": *email, "name": *username,
+		}
+		if g.sub != *sub {
+			claims["preferred_username"], claims["name"], claims["email"] = g.sub, g.sub, g.sub+"@example.com"
+		}
+		if *groups != "" {
+			claims["groups"] = strings.Split(*groups, ",")
+		}
+		t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		t.Header["kid"] = "mock"
+		idToken, err := t.SignedString(key)
+		if err != nil {
+			tokenError(w, "server_error", err.Error())
+			return
+		}
+		writeJSON(w, map[string]any{"access_token": randomString(), "token_type": "Bearer", "expires_in": 300, "id_token": idToken})
+	})
+
+	log.Printf("mock OIDC issuer %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, code, desc string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": desc})
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
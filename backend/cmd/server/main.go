@@ -12,9 +12,13 @@ import (
 
 	"github.com/example/mediahub/internal/api"
 	"github.com/example/mediahub/internal/config"
+	"github.com/example/mediahub/internal/cors"
 	"github.com/example/mediahub/internal/db"
+	"github.com/example/mediahub/internal/oidc"
+	"github.com/example/mediahub/internal/realip"
 	"github.com/example/mediahub/internal/scan"
 	"github.com/example/mediahub/internal/stream"
+	"github.com/example/mediahub/internal/transcode"
 	"github.com/example/mediahub/internal/worker"
 )
 
@@ -32,10 +36,19 @@ func main() {
 		log.Fatalf("migrate: %v", err)
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
-	if err := d.EnsureDefaultAdmin(ctx, "admin", string(hash)); err != nil {
+	// Without ADMIN_PASSWORD the bootstrap admin gets "admin", to be changed at first login
+	adminPassword, mustChange := cfg.AdminPassword, cfg.AdminPassword == ""
+	if mustChange {
+		adminPassword = "admin"
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
+	created, err := d.EnsureDefaultAdmin(ctx, cfg.AdminUsername, string(hash), mustChange)
+	if err != nil {
 		log.Fatalf("ensure admin: %v", err)
 	}
+	if created {
+		log.Printf("created admin user %q", cfg.AdminUsername)
+	}
 
 	scanner := scan.New(d.Pool, cfg)
 	streamer := stream.New(d.Pool)
@@ -43,40 +56,65 @@ func main() {
 	thumbWorker := worker.NewThumbWorker(d.Pool, cfg)
 	go thumbWorker.Run(ctx)
 
+	metadataWorker := worker.NewMetadataWorker(d.Pool, cfg)
+	go metadataWorker.Run(ctx)
+
+	loudnessWorker := worker.NewLoudnessWorker(d.Pool, cfg)
+	go loudnessWorker.Run(ctx)
+
+	hlsManager := transcode.NewHLSManager(cfg)
+	go hlsManager.Run(ctx)
+
 	srv := &api.Server{
 		DB:        d.Pool,
+		Cfg:       cfg,
 		JWTSecret: cfg.JWTSecret,
 		Scanner:   scanner,
 		Streamer:  streamer,
+		HLS:       hlsManager,
+
+		AudioTranscoder: transcode.NewAudioTranscoder(cfg),
+		Images:          transcode.NewImageRenderer(cfg),
+		Logins:          api.NewLoginThrottle(cfg),
+	}
+	if cfg.OIDCIssuer != "" {
+		srv.OIDC = oidc.New(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+		})
+		log.Printf("single sign-on with %s", cfg.OIDCIssuer)
+	}
+	go srv.RunAuditRetention(ctx)
+
+	proxies, err := realip.New(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
 	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(proxies.Handler)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			origin := r.Header.Get("Origin")
-			if origin != "" {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-			} else {
-				w.Header().Set("Access-Control-Allow-Origin", "*")
-			}
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	})
-
-	r.Use(api.AuthMiddleware(cfg.JWTSecret))
+	if cfg.CORSDev {
+		log.Printf("CORS_DEV: any origin may call the API with credentials, don't use in production")
+	} else if len(cfg.CORSAllowedOrigins) == 0 {
+		log.Printf("no CORS_ALLOWED_ORIGINS: only same-origin browsers can use the API")
+	}
+	r.Use(cors.New(cors.Config{
+		AllowedOrigins: cfg.CORSAllowedOrigins,
+		AllowedMethods: cfg.CORSAllowedMethods,
+		AllowedHeaders: cfg.CORSAllowedHeaders,
+		MaxAge:         cfg.CORSMaxAge,
+		Dev:            cfg.CORSDev,
+	}).Handler)
+
+	r.Use(srv.AuthMiddleware)
 
 	r.Mount("/", srv.Routes())
 
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Library access: admins see every library; other users see unrestricted
+// libraries plus the restricted ones they were granted (user_library).
+// Items of other libraries are invisible: listings filter them out and item
+// endpoints answer 404.
+
+// libraryScope returns the ids of the libraries the current user may see, or
+// nil when the user may see all of them. A non-nil empty slice means none.
+func (s *Server) libraryScope(ctx context.Context) []int64 {
+	uid, ok := UserIDFromContext(ctx)
+	if !ok {
+		return []int64{}
+	}
+	if role, err := s.userRole(ctx, uid); err == nil && role == RoleAdmin {
+		return nil
+	}
+	rows, err := s.DB.Query(ctx, `
+		select id from library
+		where not restricted or id in (select library_id from user_library where user_id = $1)`, uid)
+	if err != nil {
+		return []int64{}
+	}
+	defer rows.Close()
+	out := []int64{}
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// inScope is the SQL condition limiting col to the scope passed as
+// argument n (NULL meaning everything)
+func inScope(col string, n int) string {
+	return fmt.Sprintf("($%d::bigint[] is null or %s = any($%d))", n, col, n)
+}
+
+func scopeAllows(scope []int64, libraryID int64) bool {
+	if scope == nil {
+		return true
+	}
+	for _, id := range scope {
+		if id == libraryID {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessLibrary reports whether the current user may see a library
+func (s *Server) canAccessLibrary(ctx context.Context, libraryID int64) bool {
+	return scopeAllows(s.libraryScope(ctx), libraryID)
+}
+
+// canAccessItem reports whether the current user may see an item
+func (s *Server) canAccessItem(ctx context.Context, itemID int64) bool {
+	var libraryID int64
+	if err := s.DB.QueryRow(ctx, "select library_id from media_item where id=$1", itemID).Scan(&libraryID); err != nil {
+		return false
+	}
+	return s.canAccessLibrary(ctx, libraryID)
+}
+
+// RequireItemAccess answers 404 on item routes ({id}) the user may not see
+func (s *Server) RequireItemAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if id > 0 && !s.canAccessItem(r.Context(), id) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleGetLibraryAccess returns whether a library is restricted and who may see it
+func (s *Server) handleGetLibraryAccess(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	out := LibraryAccess{UserIDs: []int64{}}
+	err := s.DB.QueryRow(r.Context(), `
+		select l.restricted, coalesce(array_agg(ul.user_id order by ul.user_id) filter (where ul.user_id is not null), '{}')
+		from library l
+		left join user_library ul on ul.library_id = l.id
+		where l.id = $1
+		group by l.id`, id).Scan(&out.Restricted, &out.UserIDs)
+	if err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleSetLibraryAccess replaces the restricted flag and the grants of a library
+func (s *Server) handleSetLibraryAccess(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var req LibraryAccess
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	if req.UserIDs == nil {
+		req.UserIDs = []int64{}
+	}
+
+	tx, err := s.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(r.Context(), "update library set restricted=$2 where id=$1", id, req.Restricted)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), "delete from user_library where library_id=$1", id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+		insert into user_library(user_id, library_id)
+		select u.id, $1 from app_user u where u.id = any($2)`, id, req.UserIDs); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "library.access", auditLibrary, id, map[string]any{"restricted": req.Restricted, "user_ids": req.UserIDs})
+	s.handleGetLibraryAccess(w, r)
+}
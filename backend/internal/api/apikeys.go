@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// API keys let scripts authenticate without a password, either as
+// "Authorization: Bearer mh_..." or "X-Api-Key: mh_...". A key acts as its
+// owner, narrowed by its scopes:
+//
+//	read   GET/HEAD requests only
+//	scan   read, plus starting scans and thumbnail regeneration
+//	admin  everything the owner may do
+//
+// A key without scopes is not narrowed. Scopes never grant more than the
+// owner's role: a viewer's admin key still can't reach admin routes.
+
+const apiKeyPrefix = "mh_"
+
+const (
+	ScopeRead  = "read"
+	ScopeScan  = "scan"
+	ScopeAdmin = "admin"
+)
+
+const apiKeyScopesKey ctxKey = "api_key_scopes"
+
+func validScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeScan || scope == ScopeAdmin
+}
+
+// apiKeyFromRequest returns the API key of a request, if any
+func apiKeyFromRequest(r *http.Request) string {
+	if k := r.Header.Get("X-Api-Key"); k != "" {
+		return k
+	}
+	if tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(tok, apiKeyPrefix) {
+		return tok
+	}
+	return ""
+}
+
+// authenticateAPIKey returns the owner and scopes of a live key
+func (s *Server) authenticateAPIKey(ctx context.Context, key string) (userID int64, scopes []string, ok bool) {
+	var id int64
+	err := s.DB.QueryRow(ctx, `
+		select id, user_id, scopes from api_key
+		where key_hash = $1 and (expires_at is null or expires_at > now())`, hashToken(key)).Scan(&id, &userID, &scopes)
+	if err != nil {
+		return 0, nil, false
+	}
+	// Keep last_used_at coarse to avoid a write per request
+	_, _ = s.DB.Exec(ctx, `
+		update api_key set last_used_at = now()
+		where id = $1 and (last_used_at is null or last_used_at < now() - interval '1 minute')`, id)
+	return userID, scopes, true
+}
+
+// apiKeyScopes returns the scopes of a key-authenticated request; ok is false
+// for requests authenticated otherwise
+func apiKeyScopes(ctx context.Context) (scopes []string, ok bool) {
+	scopes, ok = ctx.Value(apiKeyScopesKey).([]string)
+	return scopes, ok
+}
+
+// isScanRequest matches the admin routes the scan scope opens
+func isScanRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	if r.URL.Path == "/api/scan" {
+		return true
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/api/libraries/")
+	return ok && strings.HasSuffix(rest, "/regenerate-thumbs")
+}
+
+// scopesAllow checks a key-authenticated request against the key's scopes;
+// adminRoute is set by RequireRole(RoleAdmin)
+func scopesAllow(scopes []string, r *http.Request, adminRoute bool) bool {
+	if len(scopes) == 0 || slices.Contains(scopes, ScopeAdmin) {
+		return true
+	}
+	if slices.Contains(scopes, ScopeScan) && isScanRequest(r) {
+		return true
+	}
+	if adminRoute {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	rows, err := s.DB.Query(r.Context(), `
+		select id, name, prefix, scopes, created_at, last_used_at, expires_at
+		from api_key where user_id = $1
+		order by created_at desc`, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.LastUsedAt, &k.ExpiresAt); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out = append(out, k)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleCreateAPIKey creates a key for the current user. The key itself is
+// only ever returned here.
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name required", 400)
+		return
+	}
+	if req.Scopes == nil {
+		req.Scopes = []string{}
+	}
+	for _, sc := range req.Scopes {
+		if !validScope(sc) {
+			http.Error(w, "scopes must be read, scan or admin", 400)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at is in the past", 400)
+		return
+	}
+	// A key can't widen the key that creates it
+	if scopes, ok := apiKeyScopes(r.Context()); ok && len(scopes) > 0 {
+		for _, sc := range req.Scopes {
+			if !slices.Contains(scopes, sc) && !slices.Contains(scopes, ScopeAdmin) {
+				http.Error(w, "cannot grant scope "+sc, 403)
+				return
+			}
+		}
+		if len(req.Scopes) == 0 && !slices.Contains(scopes, ScopeAdmin) {
+			http.Error(w, "cannot create an unscoped key", 403)
+			return
+		}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		http.Error(w, "token error", 500)
+		return
+	}
+	key := apiKeyPrefix + secret
+	out := CreateAPIKeyResponse{
+		APIKey: APIKey{Name: req.Name, Prefix: key[:len(apiKeyPrefix)+6], Scopes: req.Scopes, ExpiresAt: req.ExpiresAt},
+		Key:    key,
+	}
+	err = s.DB.QueryRow(r.Context(), `
+		insert into api_key(user_id, name, prefix, key_hash, scopes, expires_at)
+		values ($1, $2, $3, $4, $5, $6)
+		returning id, created_at`,
+		uid, out.Name, out.Prefix, hashToken(key), out.Scopes, out.ExpiresAt).Scan(&out.ID, &out.CreatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "api_key.create", auditAPIKey, out.ID, map[string]any{"name": out.Name, "prefix": out.Prefix, "scopes": out.Scopes})
+	writeJSON(w, 201, out)
+}
+
+func (s *Server) handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	tag, err := s.DB.Exec(r.Context(), "delete from api_key where id=$1 and user_id=$2", id, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	s.audit(r, "api_key.delete", auditAPIKey, id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
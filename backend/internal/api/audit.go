@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Audit log of administrative and destructive actions: who (actor), did what
+// (action, e.g. "library.delete"), to what (target), from where (IP, request
+// id of the log lines). Handlers call s.audit once the action succeeded.
+
+const (
+	auditLibrary  = "library"
+	auditUser     = "user"
+	auditTag      = "tag"
+	auditAPIKey   = "api_key"
+	auditSettings = "settings"
+	auditShare    = "share"
+)
+
+// audit records an action of the current user. A failure is logged but
+// doesn't fail the request, which has already happened.
+func (s *Server) audit(r *http.Request, action, targetType string, targetID any, details map[string]any) {
+	actor, _ := UserIDFromContext(r.Context())
+	var target *string
+	if targetID != nil {
+		t := fmt.Sprint(targetID)
+		target = &t
+	}
+	var det []byte
+	if details != nil {
+		det, _ = json.Marshal(details)
+	}
+	_, err := s.DB.Exec(r.Context(), `
+		insert into audit_log(actor_id, actor_name, action, target_type, target_id, request_id, ip, details)
+		values (nullif($1::bigint, 0), (select username from app_user where id = $1), $2, nullif($3, ''), $4, nullif($5, ''), $6, $7)`,
+		actor, action, targetType, target, middleware.GetReqID(r.Context()), clientIP(r), det)
+	if err != nil {
+		log.Printf("audit %s by user %d: %v", action, actor, err)
+	}
+}
+
+// handleAuditLog pages through the audit log, newest first, optionally
+// filtered by ?action= (exact, or a prefix ending in "." like "user.") and
+// ?actor_id=
+func (s *Server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	pageSize, _ := strconv.Atoi(q.Get("pageSize"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	where := []string{"true"}
+	args := []any{}
+	if a := strings.TrimSpace(q.Get("action")); a != "" {
+		if strings.HasSuffix(a, ".") {
+			args = append(args, a+"%")
+			where = append(where, fmt.Sprintf("action like $%d", len(args)))
+		} else {
+			args = append(args, a)
+			where = append(where, fmt.Sprintf("action = $%d", len(args)))
+		}
+	}
+	if v := q.Get("actor_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "bad actor_id", 400)
+			return
+		}
+		args = append(args, id)
+		where = append(where, fmt.Sprintf("actor_id = $%d", len(args)))
+	}
+	whereSQL := strings.Join(where, " and ")
+
+	var total int64
+	if err := s.DB.QueryRow(r.Context(), "select count(*) from audit_log where "+whereSQL, args...).Scan(&total); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := s.DB.Query(r.Context(), fmt.Sprintf(`
+		select id, created_at, actor_id, coalesce(actor_name, ''), action, coalesce(target_type, ''),
+		       coalesce(target_id, ''), coalesce(request_id, ''), coalesce(ip, ''), details
+		from audit_log where %s
+		order by created_at desc, id desc
+		limit $%d offset $%d`, whereSQL, len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType,
+			&e.TargetID, &e.RequestID, &e.IP, &details); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if details != nil {
+			e.Details = json.RawMessage(details)
+		}
+		entries = append(entries, e)
+	}
+	writeJSON(w, 200, PagedAudit{Page: page, PageSize: pageSize, Total: total, Entries: entries})
+}
+
+// RunAuditRetention deletes entries past AUDIT_RETENTION_DAYS, at start and
+// then hourly, until ctx is done
+func (s *Server) RunAuditRetention(ctx context.Context) {
+	if s.Cfg.AuditRetentionDays <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		cutoff := time.Now().AddDate(0, 0, -s.Cfg.AuditRetentionDays)
+		if tag, err := s.DB.Exec(ctx, "delete from audit_log where created_at < $1", cutoff); err != nil {
+			log.Printf("audit retention: %v", err)
+		} else if n := tag.RowsAffected(); n > 0 {
+			log.Printf("audit retention: deleted %d entries", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,15 +16,25 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/example/mediahub/internal/config"
+	"github.com/example/mediahub/internal/oidc"
 	"github.com/example/mediahub/internal/scan"
 	"github.com/example/mediahub/internal/stream"
+	"github.com/example/mediahub/internal/transcode"
 )
 
 type Server struct {
 	DB        *pgxpool.Pool
+	Cfg       config.Config
 	JWTSecret string
 	Scanner   *scan.Scanner
 	Streamer  *stream.Streamer
+	HLS       *transcode.HLSManager
+
+	AudioTranscoder *transcode.AudioTranscoder
+	Images          *transcode.ImageRenderer
+	OIDC            *oidc.Provider // nil without single sign-on
+	Logins          *LoginThrottle // nil disables login throttling
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -37,48 +48,120 @@ func (s *Server) Routes() http.Handler {
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
 
 	r.Post("/api/auth/login", s.handleLogin)
+	r.Post("/api/auth/login/2fa", s.handleLoginSecondFactor)
+	r.Post("/api/auth/refresh", s.handleRefresh)
+	r.Post("/api/auth/logout", s.handleLogout)
+	r.Get("/api/auth/oidc/config", s.handleOIDCConfig)
+	r.Get("/api/auth/oidc/login", s.handleOIDCLogin)
+	r.Get("/api/auth/oidc/callback", s.handleOIDCCallback)
+	r.Post("/api/auth/oidc/link", s.handleOIDCLink)
+
+	// Public share links (no account, see shares.go)
+	r.Get("/s/{token}", s.handleShareListing)
+	r.Post("/s/{token}/unlock", s.handleShareUnlock)
+	r.Get("/s/{token}/items/{id}/thumb", s.handleSharedThumb)
+	r.Get("/s/{token}/items/{id}/stream", s.handleSharedStream)
+	r.Get("/s/{token}/items/{id}/download", s.handleSharedDownload)
+
 	r.Get("/api/libraries", s.handleLibraries)
-	r.Post("/api/libraries", s.handleCreateLibrary)
-	r.Delete("/api/libraries/{id}", s.handleDeleteLibrary)
 	r.Get("/api/libraries/{id}/stats", s.handleLibraryStats)
-	r.Post("/api/libraries/{id}/regenerate-thumbs", s.handleRegenerateThumbs)
-	r.Post("/api/scan", s.handleScan)
 
 	r.Get("/api/items", s.handleItems)
-	r.Get("/api/items/{id}", s.handleItemByID)
-	r.Get("/api/items/{id}/thumb", s.handleThumb)
-	r.Get("/api/items/{id}/stream", s.handleStream)
-
-	r.Get("/api/favorites", s.handleFavoritesList)
-	r.Post("/api/favorites/{id}", s.handleFavoriteSet)
-	r.Delete("/api/favorites/{id}", s.handleFavoriteUnset)
-
 	r.Get("/api/tags", s.handleTagsList)
 	r.Post("/api/tags", s.handleCreateTag)
 	r.Delete("/api/tags/{id}", s.handleDeleteTag)
 	r.Get("/api/tags/{id}/items", s.handleItemsByTag)
-	r.Get("/api/items/{id}/tags", s.handleItemTags)
-	r.Post("/api/items/{id}/tags/{tagId}", s.handleAddTagToItem)
-	r.Delete("/api/items/{id}/tags/{tagId}", s.handleRemoveTagFromItem)
+	r.Get("/api/favorites", s.handleFavoritesList)
+
+	// Single items: 404 unless the item's library is visible to the user
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireItemAccess)
+		r.Get("/api/items/{id}", s.handleItemByID)
+		r.Get("/api/items/{id}/thumb", s.handleThumb)
+		r.Get("/api/items/{id}/stream", s.handleStream)
+		r.Get("/api/items/{id}/image", s.handleItemImage)
+		r.Get("/api/items/{id}/playback-info", s.handlePlaybackInfo)
+		r.Get("/api/items/{id}/hls/master.m3u8", s.handleHLSMaster)
+		r.Get("/api/items/{id}/hls/{session}/{variant}/index.m3u8", s.handleHLSPlaylist)
+		r.Get("/api/items/{id}/hls/{session}/{variant}/{n}.ts", s.handleHLSSegment)
+		r.Delete("/api/items/{id}/hls/{session}", s.handleHLSStop)
+		r.Get("/api/items/{id}/lyrics", s.handleItemLyrics)
+		r.Get("/api/items/{id}/subtitles", s.handleItemSubtitles)
+		r.Get("/api/items/{id}/subtitles/{n}.vtt", s.handleSubtitleVTT)
+		r.Get("/api/items/{id}/tags", s.handleItemTags)
+		r.Post("/api/items/{id}/tags/{tagId}", s.handleAddTagToItem)
+		r.Delete("/api/items/{id}/tags/{tagId}", s.handleRemoveTagFromItem)
+
+		r.Post("/api/favorites/{id}", s.handleFavoriteSet)
+		r.Delete("/api/favorites/{id}", s.handleFavoriteUnset)
+		r.Post("/api/history/{id}", s.handleRecordView)
+	})
+
 	r.Get("/api/folders", s.handleFolders)
 
-	// User management
-	r.Get("/api/users", s.handleUsersList)
-	r.Post("/api/users", s.handleCreateUser)
-	r.Delete("/api/users/{id}", s.handleDeleteUser)
+	// Music library
+	r.Get("/api/music/artists", s.handleMusicArtists)
+	r.Get("/api/music/albums", s.handleMusicAlbums)
+	r.Get("/api/music/albums/{id}", s.handleMusicAlbum)
+	r.Get("/api/music/tracks", s.handleMusicTracks)
+
+	// Movies and TV shows
+	r.Get("/api/shows", s.handleShows)
+	r.Get("/api/shows/{id}/seasons", s.handleShowSeasons)
+	r.Get("/api/movies", s.handleMovies)
+
+	// Current user
 	r.Put("/api/users/password", s.handleChangePassword)
 	r.Get("/api/users/me", s.handleCurrentUser)
+	r.Get("/api/users/me/sessions", s.handleListSessions)
+	r.Delete("/api/users/me/sessions", s.handleRevokeSessions)
+	r.Delete("/api/users/me/sessions/{id}", s.handleRevokeSession)
+	r.Get("/api/users/me/api-keys", s.handleListAPIKeys)
+	r.Post("/api/users/me/api-keys", s.handleCreateAPIKey)
+	r.Delete("/api/users/me/api-keys/{id}", s.handleDeleteAPIKey)
+	r.Get("/api/users/me/identities", s.handleListIdentities)
+	r.Delete("/api/users/me/identities/{id}", s.handleDeleteIdentity)
+	r.Get("/api/users/me/2fa", s.handleTwoFactorStatus)
+	r.Delete("/api/users/me/2fa", s.handleTwoFactorDisable)
+	r.Post("/api/users/me/2fa/enroll", s.handleTwoFactorEnroll)
+	r.Post("/api/users/me/2fa/verify", s.handleTwoFactorVerify)
+	r.Post("/api/users/me/2fa/recovery-codes", s.handleRegenerateRecoveryCodes)
+	r.Get("/api/shares", s.handleListShares)
+	r.Post("/api/shares", s.handleCreateShare)
+	r.Delete("/api/shares/{id}", s.handleDeleteShare)
+	r.Get("/api/users/me/transcoding", s.handleGetTranscodeProfile)
+	r.Put("/api/users/me/transcoding", s.handleSetTranscodeProfile)
 
 	// Home dashboard
 	r.Get("/api/recent", s.handleRecentItems)
 	r.Get("/api/history", s.handleHistory)
-	r.Post("/api/history/{id}", s.handleRecordView)
 
 	// Search - returns items by filename regex and matching tags
 	r.Get("/api/search", s.handleSearch)
 
-	// Jellyfin import
-	r.Post("/api/libraries/{id}/import/jellyfin", s.handleJellyfinImport)
+	// Administration: libraries, scans, imports and users
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireRole(RoleAdmin))
+		r.Post("/api/libraries", s.handleCreateLibrary)
+		r.Delete("/api/libraries/{id}", s.handleDeleteLibrary)
+		r.Post("/api/libraries/{id}/regenerate-thumbs", s.handleRegenerateThumbs)
+		r.Post("/api/scan", s.handleScan)
+		r.Post("/api/libraries/{id}/import/jellyfin", s.handleJellyfinImport)
+
+		r.Get("/api/users", s.handleUsersList)
+		r.Post("/api/users", s.handleCreateUser)
+		r.Delete("/api/users/{id}", s.handleDeleteUser)
+		r.Put("/api/users/{id}/role", s.handleSetUserRole)
+		r.Delete("/api/users/{id}/2fa", s.handleResetUserTwoFactor)
+		r.Get("/api/audit", s.handleAuditLog)
+		r.Get("/api/settings/2fa", s.handleGetTwoFactorPolicy)
+		r.Put("/api/settings/2fa", s.handleSetTwoFactorPolicy)
+		r.Get("/api/libraries/{id}/access", s.handleGetLibraryAccess)
+		r.Put("/api/libraries/{id}/access", s.handleSetLibraryAccess)
+	})
+
+	// Subsonic-compatible API for music clients (own auth, see subsonicAuth)
+	r.Route("/rest", s.subsonicRoutes)
 
 	return r
 }
@@ -95,28 +178,72 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	attempt, wait := s.Logins.Begin(ip, req.Username)
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+	defer attempt.End()
+
 	var userID int64
 	var hash string
-	err := s.DB.QueryRow(r.Context(), "select id, password_hash from app_user where username=$1", req.Username).Scan(&userID, &hash)
+	var mustChange, totp bool
+	err := s.DB.QueryRow(r.Context(), "select id, password_hash, must_change_password, totp_enabled from app_user where username=$1",
+		req.Username).Scan(&userID, &hash, &mustChange, &totp)
 	if err != nil {
+		attempt.Fail()
 		http.Error(w, "invalid credentials", 401)
 		return
 	}
 	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		attempt.Fail()
 		http.Error(w, "invalid credentials", 401)
 		return
 	}
 
-	tok, err := MakeJWT(s.JWTSecret, userID)
+	// The lockout only resets once the second factor is in too. Subsonic
+	// clients of such users log in with an API key, not the password.
+	if totp {
+		challenge, err := s.makeChallenge(userID, req)
+		if err != nil {
+			http.Error(w, "token error", 500)
+			return
+		}
+		writeJSON(w, 200, LoginResponse{TwoFactorRequired: true, ChallengeToken: challenge})
+		return
+	}
+	attempt.Succeed()
+	s.storeSubsonicPassword(r.Context(), userID, req.Password)
+	s.finishLogin(w, r, userID, req, mustChange)
+}
+
+// finishLogin starts the session of an authenticated login
+func (s *Server) finishLogin(w http.ResponseWriter, r *http.Request, userID int64, req LoginRequest, mustChange bool) {
+	sess, err := s.createSession(w, r, userID, strings.TrimSpace(req.Device), req.SessionCookie)
+	if err != nil {
+		http.Error(w, "session error", 500)
+		return
+	}
+	tok, err := s.issueAccessToken(r.Context(), userID, sess.ID)
 	if err != nil {
 		http.Error(w, "token error", 500)
 		return
 	}
-	writeJSON(w, 200, LoginResponse{Token: tok})
+	writeJSON(w, 200, LoginResponse{
+		Token:        tok,
+		RefreshToken: sess.Refresh,
+		ExpiresIn:    int(s.Cfg.AccessTTL.Seconds()),
+		CSRFToken:    sess.CSRF,
+
+		MustChangePassword: mustChange,
+	})
 }
 
 func (s *Server) handleLibraries(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.DB.Query(r.Context(), "select id, name, roots from library order by id asc")
+	rows, err := s.DB.Query(r.Context(),
+		"select id, name, roots, restricted from library where "+inScope("id", 1)+" order by id asc", s.libraryScope(r.Context()))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -126,7 +253,7 @@ func (s *Server) handleLibraries(w http.ResponseWriter, r *http.Request) {
 	out := []Library{}
 	for rows.Next() {
 		var l Library
-		if err := rows.Scan(&l.ID, &l.Name, &l.Roots); err != nil {
+		if err := rows.Scan(&l.ID, &l.Name, &l.Roots, &l.Restricted); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
@@ -156,6 +283,7 @@ func (s *Server) handleCreateLibrary(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	s.audit(r, "library.create", auditLibrary, lib.ID, map[string]any{"name": lib.Name, "roots": lib.Roots})
 	writeJSON(w, 201, lib)
 }
 
@@ -165,11 +293,17 @@ func (s *Server) handleDeleteLibrary(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad id", 400)
 		return
 	}
-	_, err := s.DB.Exec(r.Context(), "DELETE FROM library WHERE id=$1", id)
+	var name string
+	err := s.DB.QueryRow(r.Context(), "DELETE FROM library WHERE id=$1 RETURNING name", id).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeJSON(w, 200, map[string]any{"ok": true})
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	s.audit(r, "library.delete", auditLibrary, id, map[string]any{"name": name})
 	writeJSON(w, 200, map[string]any{"ok": true})
 }
 
@@ -192,6 +326,7 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	s.audit(r, "library.scan", auditLibrary, lid, nil)
 	writeJSON(w, 200, map[string]any{"started": true})
 }
 
@@ -201,6 +336,10 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "library_id required", 400)
 		return
 	}
+	if !s.canAccessLibrary(r.Context(), lid) {
+		http.Error(w, "library not found", 404)
+		return
+	}
 	kind := strings.TrimSpace(r.URL.Query().Get("kind")) // video/audio/photo/other or empty
 	q := strings.TrimSpace(r.URL.Query().Get("q"))
 	sort := strings.TrimSpace(r.URL.Query().Get("sort")) // recent|name
@@ -268,9 +407,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		it.MTime = mtime
-		if thumbPath != "" {
-			it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
-		}
+		s.setItemURLs(r.Context(), &it, thumbPath != "")
 		items = append(items, it)
 	}
 
@@ -286,17 +423,25 @@ func (s *Server) handleItemByID(w http.ResponseWriter, r *http.Request) {
 	var it MediaItem
 	var mtime *time.Time
 	var thumbPath string
+	var trackGain, trackPeak, albumGain, albumPeak *float64
 	err := s.DB.QueryRow(r.Context(),
-		`select id, library_id, rel_path, path, kind, present, size_bytes, mtime, last_seen_at, coalesce(thumb_path,'')
-		 from media_item where id=$1`, id,
-	).Scan(&it.ID, &it.LibraryID, &it.RelPath, &it.Path, &it.Kind, &it.Present, &it.SizeBytes, &mtime, &it.LastSeenAt, &thumbPath)
+		`select mi.id, mi.library_id, mi.rel_path, mi.path, mi.kind, mi.present, mi.size_bytes, mi.mtime, mi.last_seen_at, coalesce(mi.thumb_path,''),
+		        t.gain_db, t.peak, al.gain_db, al.peak
+		 from media_item mi
+		 left join track t on t.item_id = mi.id
+		 left join album al on al.id = t.album_id
+		 where mi.id=$1`, id,
+	).Scan(&it.ID, &it.LibraryID, &it.RelPath, &it.Path, &it.Kind, &it.Present, &it.SizeBytes, &mtime, &it.LastSeenAt, &thumbPath,
+		&trackGain, &trackPeak, &albumGain, &albumPeak)
 	if err != nil {
 		http.Error(w, "not found", 404)
 		return
 	}
 	it.MTime = mtime
-	if thumbPath != "" {
-		it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
+	it.ReplayGain = newReplayGain(trackGain, trackPeak, albumGain, albumPeak)
+	s.setItemURLs(r.Context(), &it, thumbPath != "")
+	if it.Kind == "video" {
+		it.VideoMeta = s.loadVideoMeta(r.Context(), it.ID)
 	}
 	writeJSON(w, 200, it)
 }
@@ -320,7 +465,25 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad id", 400)
 		return
 	}
-	s.Streamer.StreamByID(w, r, id)
+	q := r.URL.Query()
+	if format := q.Get("format"); format != "" && format != "raw" {
+		kbps, _ := strconv.Atoi(q.Get("bitrate"))
+		profile, err := transcode.ParseAudioProfile(format, kbps)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		s.handleAudioTranscode(w, r, id, profile)
+		return
+	}
+	switch q.Get("remux") {
+	case "":
+		s.Streamer.StreamByID(w, r, id)
+	case "mp4":
+		s.handleRemux(w, r, id)
+	default:
+		http.Error(w, "unsupported remux container", 400)
+	}
 }
 
 func (s *Server) handleFavoritesList(w http.ResponseWriter, r *http.Request) {
@@ -334,9 +497,9 @@ func (s *Server) handleFavoritesList(w http.ResponseWriter, r *http.Request) {
 		select mi.id, mi.library_id, mi.rel_path, mi.path, mi.kind, mi.present, mi.size_bytes, mi.mtime, mi.last_seen_at, coalesce(mi.thumb_path,'')
 		from user_favorite uf
 		join media_item mi on mi.id=uf.item_id
-		where uf.user_id=$1
+		where uf.user_id=$1 and `+inScope("mi.library_id", 2)+`
 		order by uf.created_at desc
-		limit 500`, uid)
+		limit 500`, uid, s.libraryScope(r.Context()))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -353,9 +516,7 @@ func (s *Server) handleFavoritesList(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		it.MTime = mtime
-		if thumb != "" {
-			it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
-		}
+		s.setItemURLs(r.Context(), &it, thumb != "")
 		out = append(out, it)
 	}
 	writeJSON(w, 200, out)
@@ -405,13 +566,17 @@ func (s *Server) handleTagsList(w http.ResponseWriter, r *http.Request) {
 		Name  string `json:"name"`
 		Count int64  `json:"count"`
 	}
+	// Tags are global: only those on visible items (or on none yet) are listed,
+	// so that names used in restricted libraries don't leak
 	rows, err := s.DB.Query(r.Context(), `
-		select t.id, t.name, count(it.item_id) as c
+		select t.id, t.name, count(mi.id) as c
 		from tag t
 		left join item_tag it on it.tag_id=t.id
+		left join media_item mi on mi.id=it.item_id and `+inScope("mi.library_id", 1)+`
 		group by t.id, t.name
+		having count(mi.id) > 0 or count(it.item_id) = 0
 		order by c desc, t.name asc
-		limit 5000`)
+		limit 5000`, s.libraryScope(r.Context()))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -462,11 +627,32 @@ func (s *Server) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad id", 400)
 		return
 	}
-	_, err := s.DB.Exec(r.Context(), "DELETE FROM tag WHERE id=$1", id)
+	// Deleting a tag untags every item: not allowed while it is on items the
+	// user can't see
+	var hidden bool
+	err := s.DB.QueryRow(r.Context(), `
+		select exists (
+			select 1 from item_tag it join media_item mi on mi.id = it.item_id
+			where it.tag_id = $1 and not `+inScope("mi.library_id", 2)+`)`, id, s.libraryScope(r.Context())).Scan(&hidden)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	if hidden {
+		http.Error(w, "forbidden: tag is used in libraries you can't access", 403)
+		return
+	}
+	var name string
+	err = s.DB.QueryRow(r.Context(), "DELETE FROM tag WHERE id=$1 RETURNING name", id).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeJSON(w, 200, map[string]any{"ok": true})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "tag.delete", auditTag, id, map[string]any{"name": name})
 	writeJSON(w, 200, map[string]any{"ok": true})
 }
 
@@ -562,9 +748,9 @@ func (s *Server) handleItemsByTag(w http.ResponseWriter, r *http.Request) {
 		select mi.id, mi.library_id, mi.rel_path, mi.path, mi.kind, mi.present, mi.size_bytes, mi.mtime, mi.last_seen_at, coalesce(mi.thumb_path,'')
 		from item_tag it
 		join media_item mi on mi.id=it.item_id
-		where it.tag_id=$1 and mi.present=true
+		where it.tag_id=$1 and mi.present=true and `+inScope("mi.library_id", 2)+`
 		order by mi.rel_path asc
-		limit 5000`, tagID)
+		limit 5000`, tagID, s.libraryScope(r.Context()))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -581,9 +767,7 @@ func (s *Server) handleItemsByTag(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		it.MTime = mtime
-		if thumb != "" {
-			it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
-		}
+		s.setItemURLs(r.Context(), &it, thumb != "")
 		out = append(out, it)
 	}
 	writeJSON(w, 200, out)
@@ -595,6 +779,10 @@ func (s *Server) handleFolders(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "library_id required", 400)
 		return
 	}
+	if !s.canAccessLibrary(r.Context(), lid) {
+		http.Error(w, "library not found", 404)
+		return
+	}
 	path := strings.TrimSpace(r.URL.Query().Get("path"))
 	path = strings.Trim(path, "/")
 
@@ -649,9 +837,7 @@ func (s *Server) handleFolders(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 			it.MTime = mtime
-			if thumb != "" {
-				it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
-			}
+			s.setItemURLs(r.Context(), &it, thumb != "")
 			items = append(items, it)
 		}
 	} else {
@@ -722,9 +908,7 @@ func (s *Server) handleFolders(w http.ResponseWriter, r *http.Request) {
 				continue // Skip - this is in a subfolder
 			}
 			it.MTime = mtime
-			if thumb != "" {
-				it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
-			}
+			s.setItemURLs(r.Context(), &it, thumb != "")
 			items = append(items, it)
 			if len(items) >= 500 {
 				break
@@ -749,9 +933,10 @@ func (s *Server) handleUsersList(w http.ResponseWriter, r *http.Request) {
 	type User struct {
 		ID        int64  `json:"id"`
 		Username  string `json:"username"`
+		Role      string `json:"role"`
 		CreatedAt string `json:"created_at"`
 	}
-	rows, err := s.DB.Query(r.Context(), "SELECT id, username, created_at FROM app_user ORDER BY id")
+	rows, err := s.DB.Query(r.Context(), "SELECT id, username, role, created_at FROM app_user ORDER BY id")
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -762,7 +947,7 @@ func (s *Server) handleUsersList(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var u User
 		var createdAt time.Time
-		if err := rows.Scan(&u.ID, &u.Username, &createdAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &createdAt); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
@@ -776,6 +961,9 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Role     string `json:"role"` // defaults to viewer
+
+		MustChangePassword bool `json:"must_change_password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad json", 400)
@@ -786,8 +974,15 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "username and password required", 400)
 		return
 	}
-	if len(req.Password) < 4 {
-		http.Error(w, "password too short (min 4)", 400)
+	if req.Role == "" {
+		req.Role = RoleViewer
+	}
+	if !validRole(req.Role) {
+		http.Error(w, "role must be admin or viewer", 400)
+		return
+	}
+	if err := s.checkPasswordPolicy(req.Username, req.Password); err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
@@ -799,8 +994,8 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 	var id int64
 	err = s.DB.QueryRow(r.Context(),
-		"INSERT INTO app_user (username, password_hash, created_at) VALUES ($1, $2, $3) RETURNING id",
-		req.Username, string(hash), time.Now().UTC(),
+		"INSERT INTO app_user (username, password_hash, role, must_change_password, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		req.Username, string(hash), req.Role, req.MustChangePassword, time.Now().UTC(),
 	).Scan(&id)
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
@@ -810,8 +1005,12 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	s.storeSubsonicPassword(r.Context(), id, req.Password)
 
-	writeJSON(w, 201, map[string]any{"id": id, "username": req.Username})
+	s.audit(r, "user.create", auditUser, id, map[string]any{
+		"username": req.Username, "role": req.Role, "must_change_password": req.MustChangePassword,
+	})
+	writeJSON(w, 201, map[string]any{"id": id, "username": req.Username, "role": req.Role})
 }
 
 func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
@@ -828,12 +1027,21 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "cannot delete last user", 400)
 		return
 	}
+	if role, err := s.userRole(r.Context(), userID); err == nil && role == RoleAdmin && s.isLastAdmin(r.Context(), userID) {
+		http.Error(w, "cannot delete the last admin", 400)
+		return
+	}
 
-	_, err := s.DB.Exec(r.Context(), "DELETE FROM app_user WHERE id = $1", userID)
-	if err != nil {
+	// Sessions go with the user (on delete cascade), which revokes its tokens
+	var username string
+	err := s.DB.QueryRow(r.Context(), "DELETE FROM app_user WHERE id = $1 RETURNING username", userID).Scan(&username)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	if err == nil {
+		s.audit(r, "user.delete", auditUser, userID, map[string]any{"username": username})
+	}
 
 	// Check if user deleted themselves
 	currentUserID, _ := UserIDFromContext(r.Context())
@@ -861,18 +1069,22 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "new password required", 400)
 		return
 	}
-	if len(req.NewPassword) < 4 {
-		http.Error(w, "password too short (min 4)", 400)
-		return
-	}
 
 	// Verify old password
-	var currentHash string
-	err := s.DB.QueryRow(r.Context(), "SELECT password_hash FROM app_user WHERE id = $1", userID).Scan(&currentHash)
+	var username, currentHash string
+	err := s.DB.QueryRow(r.Context(), "SELECT username, password_hash FROM app_user WHERE id = $1", userID).Scan(&username, &currentHash)
 	if err != nil {
 		http.Error(w, "user not found", 404)
 		return
 	}
+	if err := s.checkPasswordPolicy(username, req.NewPassword); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if req.NewPassword == req.OldPassword {
+		http.Error(w, "new password must differ from the old one", 400)
+		return
+	}
 
 	if bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(req.OldPassword)) != nil {
 		http.Error(w, "old password incorrect", 401)
@@ -886,12 +1098,20 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = s.DB.Exec(r.Context(), "UPDATE app_user SET password_hash = $2 WHERE id = $1", userID, string(newHash))
+	_, err = s.DB.Exec(r.Context(), "UPDATE app_user SET password_hash = $2, must_change_password = false WHERE id = $1", userID, string(newHash))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	s.storeSubsonicPassword(r.Context(), userID, req.NewPassword)
+
+	// Sign out everywhere else
+	current, _ := SessionIDFromContext(r.Context())
+	if err := s.revokeUserSessions(r.Context(), userID, current); err != nil {
+		log.Printf("revoke sessions of user %d: %v", userID, err)
+	}
 
+	s.audit(r, "user.password", auditUser, userID, nil)
 	writeJSON(w, 200, map[string]any{"ok": true})
 }
 
@@ -902,14 +1122,20 @@ func (s *Server) handleCurrentUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var username string
-	err := s.DB.QueryRow(r.Context(), "SELECT username FROM app_user WHERE id = $1", userID).Scan(&username)
+	var username, role string
+	var mustChange, twoFactor bool
+	err := s.DB.QueryRow(r.Context(), "SELECT username, role, must_change_password, totp_enabled FROM app_user WHERE id = $1",
+		userID).Scan(&username, &role, &mustChange, &twoFactor)
 	if err != nil {
 		http.Error(w, "user not found", 404)
 		return
 	}
 
-	writeJSON(w, 200, map[string]any{"id": userID, "username": username})
+	writeJSON(w, 200, map[string]any{
+		"id": userID, "username": username, "role": role, "must_change_password": mustChange,
+		"two_factor_enabled":  twoFactor,
+		"two_factor_required": !twoFactor && role == RoleAdmin && s.requireAdmin2FA(r.Context()),
+	})
 }
 
 // handleRecentItems returns recently added media items
@@ -938,17 +1164,17 @@ func (s *Server) handleRecentItems(w http.ResponseWriter, r *http.Request) {
 			SELECT id, library_id, path, rel_path, kind, size_bytes, duration_ms, width, height,
 			       thumb_path IS NOT NULL as has_thumb, created_at
 			FROM media_item
-			WHERE present = true AND library_id = $1
+			WHERE present = true AND library_id = $1 AND `+inScope("library_id", 3)+`
 			ORDER BY created_at DESC
-			LIMIT $2`, *libraryID, limit)
+			LIMIT $2`, *libraryID, limit, s.libraryScope(r.Context()))
 	} else {
 		rows, err = s.DB.Query(r.Context(), `
 			SELECT id, library_id, path, rel_path, kind, size_bytes, duration_ms, width, height,
 			       thumb_path IS NOT NULL as has_thumb, created_at
 			FROM media_item
-			WHERE present = true
+			WHERE present = true AND `+inScope("library_id", 2)+`
 			ORDER BY created_at DESC
-			LIMIT $1`, limit)
+			LIMIT $1`, limit, s.libraryScope(r.Context()))
 	}
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -969,10 +1195,13 @@ func (s *Server) handleRecentItems(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 500)
 			return
 		}
+		urls := MediaItem{ID: id}
+		s.setItemURLs(r.Context(), &urls, hasThumb)
 		items = append(items, map[string]any{
 			"id": id, "library_id": libID, "path": path, "rel_path": relPath,
 			"kind": kind, "size_bytes": size, "duration_ms": durationMs,
-			"width": width, "height": height, "thumb_url": hasThumb, "created_at": createdAt,
+			"width": width, "height": height, "thumb_url": urls.ThumbURL, "stream_url": urls.StreamURL,
+			"created_at": createdAt,
 		})
 	}
 	writeJSON(w, 200, items)
@@ -1012,9 +1241,9 @@ func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
 			       m.thumb_path IS NOT NULL as has_thumb, up.last_played_at
 			FROM user_playback up
 			JOIN media_item m ON m.id = up.item_id
-			WHERE up.user_id = $1 AND m.present = true AND m.library_id = $2
+			WHERE up.user_id = $1 AND m.present = true AND m.library_id = $2 AND `+inScope("m.library_id", 4)+`
 			ORDER BY up.last_played_at DESC
-			LIMIT $3`, userID, *libraryID, limit)
+			LIMIT $3`, userID, *libraryID, limit, s.libraryScope(r.Context()))
 	} else {
 		rows, err = s.DB.Query(r.Context(), `
 			SELECT m.id, m.library_id, m.path, m.rel_path, m.kind, m.size_bytes,
@@ -1022,9 +1251,9 @@ func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
 			       m.thumb_path IS NOT NULL as has_thumb, up.last_played_at
 			FROM user_playback up
 			JOIN media_item m ON m.id = up.item_id
-			WHERE up.user_id = $1 AND m.present = true
+			WHERE up.user_id = $1 AND m.present = true AND `+inScope("m.library_id", 3)+`
 			ORDER BY up.last_played_at DESC
-			LIMIT $2`, userID, limit)
+			LIMIT $2`, userID, limit, s.libraryScope(r.Context()))
 	}
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -1045,10 +1274,13 @@ func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 500)
 			return
 		}
+		urls := MediaItem{ID: id}
+		s.setItemURLs(r.Context(), &urls, hasThumb)
 		items = append(items, map[string]any{
 			"id": id, "library_id": libID, "path": path, "rel_path": relPath,
 			"kind": kind, "size_bytes": size, "duration_ms": durationMs,
-			"width": width, "height": height, "thumb_url": hasThumb, "last_played_at": lastPlayed,
+			"width": width, "height": height, "thumb_url": urls.ThumbURL, "stream_url": urls.StreamURL,
+			"last_played_at": lastPlayed,
 		})
 	}
 	writeJSON(w, 200, items)
@@ -1097,6 +1329,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	// Optional library filter
 	lid, _ := strconv.ParseInt(r.URL.Query().Get("library_id"), 10, 64)
 	limit := 100
+	scope := s.libraryScope(r.Context())
 
 	// Convert search pattern to ILIKE pattern
 	// User can use * as wildcard, we convert to %
@@ -1123,18 +1356,18 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		filenameQuery = `
 			SELECT id, library_id, rel_path, path, kind, present, size_bytes, mtime, last_seen_at, coalesce(thumb_path,'')
 			FROM media_item
-			WHERE present = true AND library_id = $1 AND rel_path ILIKE $2
+			WHERE present = true AND library_id = $1 AND rel_path ILIKE $2 AND ` + inScope("library_id", 4) + `
 			ORDER BY rel_path ASC
 			LIMIT $3`
-		filenameArgs = []any{lid, pattern, limit}
+		filenameArgs = []any{lid, pattern, limit, scope}
 	} else {
 		filenameQuery = `
 			SELECT id, library_id, rel_path, path, kind, present, size_bytes, mtime, last_seen_at, coalesce(thumb_path,'')
 			FROM media_item
-			WHERE present = true AND rel_path ILIKE $1
+			WHERE present = true AND rel_path ILIKE $1 AND ` + inScope("library_id", 3) + `
 			ORDER BY rel_path ASC
 			LIMIT $2`
-		filenameArgs = []any{pattern, limit}
+		filenameArgs = []any{pattern, limit, scope}
 	}
 
 	rows, err := s.DB.Query(r.Context(), filenameQuery, filenameArgs...)
@@ -1150,22 +1383,21 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		it.MTime = mtime
-		if thumb != "" {
-			it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
-		}
+		s.setItemURLs(r.Context(), &it, thumb != "")
 		result.ByFilename = append(result.ByFilename, it)
 	}
 	rows.Close()
 
 	// 2. Search tags by name pattern
 	tagRows, err := s.DB.Query(r.Context(), `
-		SELECT t.id, t.name, count(it.item_id) as c
+		SELECT t.id, t.name, count(mi.id) as c
 		FROM tag t
 		LEFT JOIN item_tag it ON it.tag_id = t.id
+		LEFT JOIN media_item mi ON mi.id = it.item_id AND `+inScope("mi.library_id", 2)+`
 		WHERE t.name ILIKE $1
 		GROUP BY t.id, t.name
 		ORDER BY c DESC, t.name ASC
-		LIMIT 50`, pattern)
+		LIMIT 50`, pattern, scope)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -1187,20 +1419,21 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	if len(matchingTagIDs) > 0 {
 		// Build IN clause
 		placeholders := make([]string, len(matchingTagIDs))
-		tagArgs := make([]any, len(matchingTagIDs)+1)
+		tagArgs := make([]any, len(matchingTagIDs)+2)
 		for i, tid := range matchingTagIDs {
 			placeholders[i] = fmt.Sprintf("$%d", i+1)
 			tagArgs[i] = tid
 		}
 		tagArgs[len(matchingTagIDs)] = limit
+		tagArgs[len(matchingTagIDs)+1] = scope
 
 		itemsByTagQuery := fmt.Sprintf(`
 			SELECT DISTINCT mi.id, mi.library_id, mi.rel_path, mi.path, mi.kind, mi.present, mi.size_bytes, mi.mtime, mi.last_seen_at, coalesce(mi.thumb_path,'')
 			FROM item_tag it
 			JOIN media_item mi ON mi.id = it.item_id
-			WHERE it.tag_id IN (%s) AND mi.present = true
+			WHERE it.tag_id IN (%s) AND mi.present = true AND %s
 			ORDER BY mi.rel_path ASC
-			LIMIT $%d`, strings.Join(placeholders, ","), len(matchingTagIDs)+1)
+			LIMIT $%d`, strings.Join(placeholders, ","), inScope("mi.library_id", len(matchingTagIDs)+2), len(matchingTagIDs)+1)
 
 		itemRows, err := s.DB.Query(r.Context(), itemsByTagQuery, tagArgs...)
 		if err == nil {
@@ -1212,9 +1445,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 					continue
 				}
 				it.MTime = mtime
-				if thumb != "" {
-					it.ThumbURL = fmt.Sprintf("/api/items/%d/thumb", it.ID)
-				}
+				s.setItemURLs(r.Context(), &it, thumb != "")
 				result.ByTag = append(result.ByTag, it)
 			}
 			itemRows.Close()
@@ -1231,6 +1462,10 @@ func (s *Server) handleLibraryStats(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid library id", 400)
 		return
 	}
+	if !s.canAccessLibrary(r.Context(), lid) {
+		http.Error(w, "library not found", 404)
+		return
+	}
 
 	type LibraryStats struct {
 		ID            int64  `json:"id"`
@@ -1300,7 +1535,7 @@ func (s *Server) handleLibraryStats(w http.ResponseWriter, r *http.Request) {
 			COUNT(*) FILTER (WHERE thumb_path IS NOT NULL AND thumb_path != ''),
 			COUNT(*) FILTER (WHERE thumb_path IS NULL OR thumb_path = '')
 		FROM media_item
-		WHERE library_id = $1 AND present = true AND kind IN ('video', 'photo')
+		WHERE library_id = $1 AND present = true AND kind IN ('video', 'photo', 'audio')
 	`, lid).Scan(&stats.ThumbCount, &stats.MissingThumbs)
 	if err != nil {
 		stats.ThumbCount = 0
@@ -1326,7 +1561,17 @@ func (s *Server) handleRegenerateThumbs(w http.ResponseWriter, r *http.Request)
 	if videoOnly {
 		kindFilter = "AND kind = 'video'"
 	} else {
-		kindFilter = "AND kind IN ('video', 'photo')"
+		kindFilter = "AND kind IN ('video', 'photo', 'audio')"
+
+		// Album covers are shared across tracks; drop them so the first
+		// track job of each album extracts the art again
+		_, _ = s.DB.Exec(r.Context(), `
+			UPDATE album SET cover_path = NULL
+			WHERE id IN (
+				SELECT t.album_id FROM track t
+				JOIN media_item mi ON mi.id = t.item_id
+				WHERE mi.library_id = $1
+			)`, lid)
 	}
 
 	// Update media items to clear thumb_path
@@ -1360,6 +1605,7 @@ func (s *Server) handleRegenerateThumbs(w http.ResponseWriter, r *http.Request)
 		WHERE kind = 'thumb' AND locked_at IS NULL
 	`).Scan(&jobCount)
 
+	s.audit(r, "library.regenerate_thumbs", auditLibrary, lid, map[string]any{"video_only": videoOnly})
 	writeJSON(w, 200, map[string]any{
 		"success":     true,
 		"jobs_queued": jobCount,
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/example/mediahub/internal/media"
+	"github.com/example/mediahub/internal/transcode"
+)
+
+const hlsPlaylistType = "application/vnd.apple.mpegurl"
+
+// handleHLSMaster starts an HLS playback session for a video and returns its
+// master playlist. Variant and segment URIs are relative to this URL, carry
+// the session id and repeat the signature of a signed request.
+func (s *Server) handleHLSMaster(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+
+	src := transcode.Source{ItemID: id}
+	var kind string
+	var present bool
+	var durationMs, width, height *int
+	var container, audioCodec *string
+	err := s.DB.QueryRow(r.Context(),
+		"select path, kind, present, duration_ms, width, height, container, audio_codec from media_item where id=$1", id,
+	).Scan(&src.Path, &kind, &present, &durationMs, &width, &height, &container, &audioCodec)
+	if err != nil || !present {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if kind != "video" {
+		http.Error(w, "not a video", 400)
+		return
+	}
+	if durationMs != nil {
+		src.DurationMs = *durationMs
+	}
+	if width != nil && height != nil {
+		src.Width, src.Height = *width, *height
+	}
+	src.HasAudio = audioCodec != nil
+
+	// Not probed by the metadata worker yet
+	if src.DurationMs <= 0 || container == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+		probe, err := media.Probe(ctx, src.Path)
+		cancel()
+		if err != nil {
+			http.Error(w, "cannot read video", 500)
+			return
+		}
+		src.DurationMs = probe.DurationMs()
+		if v := probe.FirstStream("video"); v != nil {
+			src.Width, src.Height = v.Width, v.Height
+		}
+		src.HasAudio = probe.FirstStream("audio") != nil
+	}
+	if src.DurationMs <= 0 {
+		http.Error(w, "unknown duration", 500)
+		return
+	}
+
+	uid, _ := UserIDFromContext(r.Context())
+	sessionID, err := s.HLS.NewSession(src, uid)
+	if errors.Is(err, transcode.ErrTooManySessions) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", hlsPlaylistType)
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(transcode.MasterPlaylist(sessionID, src, signatureQuery(r))))
+}
+
+// handleHLSPlaylist returns the media playlist of one variant
+func (s *Server) handleHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	playlist, err := s.HLS.MediaPlaylist(chi.URLParam(r, "session"), id, chi.URLParam(r, "variant"), signatureQuery(r))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", hlsPlaylistType)
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(playlist))
+}
+
+// handleHLSSegment serves one MPEG-TS segment, encoding it first if needed
+func (s *Server) handleHLSSegment(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	path, err := s.HLS.Segment(r.Context(), chi.URLParam(r, "session"), id, chi.URLParam(r, "variant"), n)
+	if errors.Is(err, transcode.ErrSessionNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("hls item %d: %v", id, err)
+			http.Error(w, "transcode failed", 500)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, path)
+}
+
+// handleHLSStop ends a playback session of the caller and its ffmpeg processes
+func (s *Server) handleHLSStop(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	uid, _ := UserIDFromContext(r.Context())
+	if err := s.HLS.Stop(chi.URLParam(r, "session"), id, uid); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/example/mediahub/internal/transcode"
+)
+
+// handleItemImage serves a resized rendition of a photo:
+//
+//	/api/items/{id}/image?w=1920&h=1080&fit=inside|cover|fill&format=jpeg|webp|png&q=85
+//
+// Without format, WebP is picked when the client accepts it, else JPEG.
+func (s *Server) handleItemImage(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	q := r.URL.Query()
+	width, _ := strconv.Atoi(q.Get("w"))
+	height, _ := strconv.Atoi(q.Get("h"))
+	quality, _ := strconv.Atoi(q.Get("q"))
+	format := q.Get("format")
+	if format == "" {
+		format = "jpeg"
+		if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+			format = "webp"
+		}
+		w.Header().Set("Vary", "Accept")
+	}
+	opts, err := transcode.ParseImageOptions(width, height, q.Get("fit"), format, quality)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var path, kind string
+	var present bool
+	err = s.DB.QueryRow(r.Context(), "select path, kind, present from media_item where id=$1", id).Scan(&path, &kind, &present)
+	if err != nil || !present {
+		http.NotFound(w, r)
+		return
+	}
+	if kind != "photo" {
+		http.Error(w, "only photos can be resized", 400)
+		return
+	}
+	s.Images.Serve(w, r, id, path, opts)
+}
@@ -118,6 +118,12 @@ func (s *Server) handleJellyfinImport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "import failed: "+err.Error(), 500)
 		return
 	}
+	s.audit(r, "library.import_jellyfin", auditLibrary, libraryID, map[string]any{
+		"collections_imported": result.CollectionsImported,
+		"favorites_imported":   result.FavoritesImported,
+		"items_matched":        result.ItemsMatched,
+		"items_not_found":      result.ItemsNotFound,
+	})
 
 	writeJSON(w, 200, result)
 }
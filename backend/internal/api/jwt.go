@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -11,7 +12,10 @@ import (
 
 type ctxKey string
 
-const userIDKey ctxKey = "user_id"
+const (
+	userIDKey    ctxKey = "user_id"
+	sessionIDKey ctxKey = "session_id"
+)
 
 func UserIDFromContext(ctx context.Context) (int64, bool) {
 	v := ctx.Value(userIDKey)
@@ -19,68 +23,143 @@ func UserIDFromContext(ctx context.Context) (int64, bool) {
 	return id, ok
 }
 
-func MakeJWT(secret string, userID int64) (string, error) {
+// MakeJWT issues an access token for a session. It is only honoured while
+// the session exists, so revoking the session revokes the token.
+func MakeJWT(secret string, userID, sessionID int64, role string, ttl time.Duration) (string, error) {
 	claims := jwt.MapClaims{
-		"sub": userID,
-		"exp": time.Now().Add(7 * 24 * time.Hour).Unix(),
-		"iat": time.Now().Unix(),
+		"sub":  userID,
+		"sid":  sessionID,
+		"role": role,
+		"exp":  time.Now().Add(ttl).Unix(),
+		"iat":  time.Now().Unix(),
 	}
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return t.SignedString([]byte(secret))
 }
 
-func AuthMiddleware(secret string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Allow login + health without auth
-			if r.URL.Path == "/api/auth/login" || r.URL.Path == "/healthz" {
-				next.ServeHTTP(w, r)
-				return
-			}
+// parseAccessToken checks the signature and expiry of an access token and
+// returns its user and session
+func parseAccessToken(secret, tokenStr string) (userID, sessionID int64, err error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, 0, fmt.Errorf("invalid token")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid claims")
+	}
+	// Tokens from before sessions have no sid: they can't be revoked, so refuse them
+	sub, ok1 := claims["sub"].(float64)
+	sid, ok2 := claims["sid"].(float64)
+	if !ok1 || !ok2 {
+		return 0, 0, fmt.Errorf("invalid claims")
+	}
+	return int64(sub), int64(sid), nil
+}
+
+func withSession(ctx context.Context, userID, sessionID int64) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, userID)
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+// SessionIDFromContext returns the session of a request authenticated by
+// access token or session cookie
+func SessionIDFromContext(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(sessionIDKey).(int64)
+	return id, ok
+}
 
-			// Allow stream and thumb endpoints without auth (browsers can't send Authorization header in img/video src)
-			if strings.HasSuffix(r.URL.Path, "/stream") || strings.HasSuffix(r.URL.Path, "/thumb") {
-				next.ServeHTTP(w, r)
+// AuthMiddleware authenticates API requests by bearer JWT, API key, signed
+// media URL or session cookie, and puts the user id in the request context
+func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
+	secret := s.JWTSecret
+	// serve runs the request of an authenticated user, unless they must
+	// change their password or enroll in 2FA first
+	serve := func(w http.ResponseWriter, r *http.Request, uid int64) {
+		if !accountSetupAllowed(r) {
+			if msg := s.pendingAccountSetup(r.Context(), uid); msg != "" {
+				http.Error(w, msg, http.StatusForbidden)
 				return
 			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Allow login (local and single sign-on), refresh, logout + health without auth
+		switch r.URL.Path {
+		case "/api/auth/login", "/api/auth/login/2fa", "/api/auth/refresh", "/api/auth/logout", "/healthz",
+			"/api/auth/oidc/config", "/api/auth/oidc/login", "/api/auth/oidc/callback":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Subsonic API authenticates with its own u/t/s query parameters, and
+		// share links are public: the token is the permission
+		if strings.HasPrefix(r.URL.Path, "/rest/") || strings.HasPrefix(r.URL.Path, "/s/") {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			auth := r.Header.Get("Authorization")
-			if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
-				http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		// API keys, as X-Api-Key or a "Bearer mh_..." token
+		if key := apiKeyFromRequest(r); key != "" {
+			uid, scopes, ok := s.authenticateAPIKey(r.Context(), key)
+			if !ok {
+				http.Error(w, "invalid or expired API key", http.StatusUnauthorized)
 				return
 			}
-			tokenStr := strings.TrimPrefix(auth, "Bearer ")
-
-			token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
-				return []byte(secret), nil
-			})
-			if err != nil || !token.Valid {
-				http.Error(w, "invalid token", http.StatusUnauthorized)
+			if !scopesAllow(scopes, r, false) {
+				http.Error(w, "forbidden: API key scope", http.StatusForbidden)
 				return
 			}
-			claims, ok := token.Claims.(jwt.MapClaims)
+			ctx := context.WithValue(r.Context(), userIDKey, uid)
+			serve(w, r.WithContext(context.WithValue(ctx, apiKeyScopesKey, scopes)), uid)
+			return
+		}
+
+		// Media elements (img/video/track src) can't send an Authorization header:
+		// stream, thumb, image, subtitle and HLS URLs are signed instead
+		if r.Header.Get("Authorization") == "" && r.URL.Query().Get("sig") != "" {
+			uid, sid, ok := verifyMediaSignature(secret, r)
 			if !ok {
-				http.Error(w, "invalid claims", http.StatusUnauthorized)
+				http.Error(w, "invalid or expired signature", http.StatusUnauthorized)
 				return
 			}
-			sub, ok := claims["sub"]
-			if !ok {
-				http.Error(w, "missing sub", http.StatusUnauthorized)
+			// URLs die with the session they were issued in (and with deleted users)
+			if !s.touchSession(r, sid, uid) {
+				http.Error(w, "session revoked", http.StatusUnauthorized)
 				return
 			}
-			var uid int64
-			switch v := sub.(type) {
-			case float64:
-				uid = int64(v)
-			case int64:
-				uid = v
-			default:
-				http.Error(w, "bad sub type", http.StatusUnauthorized)
+			serve(w, r.WithContext(withSession(r.Context(), uid, sid)), uid)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			if sid, uid, csrf, ok := s.sessionFromCookie(r.Context(), r); ok {
+				if !csrfSafe(r, csrf) {
+					http.Error(w, "missing or invalid CSRF token", http.StatusForbidden)
+					return
+				}
+				serve(w, r.WithContext(withSession(r.Context(), uid, sid)), uid)
 				return
 			}
+		}
+		if !strings.HasPrefix(auth, "Bearer ") {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
 
-			ctx := context.WithValue(r.Context(), userIDKey, uid)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
-	}
+		uid, sid, err := parseAccessToken(secret, strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !s.touchSession(r, sid, uid) {
+			http.Error(w, "session revoked", http.StatusUnauthorized)
+			return
+		}
+		serve(w, r.WithContext(withSession(r.Context(), uid, sid)), uid)
+	})
 }
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/example/mediahub/internal/media"
+)
+
+// loadLyrics returns the best lyrics for an item: synced before unsynced,
+// then sidecar files before embedded tags. Returns nil when there are none.
+func (s *Server) loadLyrics(ctx context.Context, itemID int64) (*Lyrics, error) {
+	var l Lyrics
+	var lang *string
+	var content string
+	err := s.DB.QueryRow(ctx, `
+		SELECT item_id, source, synced, lang, content
+		FROM lyrics
+		WHERE item_id = $1
+		ORDER BY synced DESC, (source = 'sidecar') DESC
+		LIMIT 1`, itemID).Scan(&l.ItemID, &l.Source, &l.Synced, &lang, &content)
+	if err != nil {
+		return nil, err
+	}
+	if lang != nil {
+		l.Lang = *lang
+	}
+	if l.Synced {
+		l.Lines = media.ParseLRC(content)
+	}
+	l.Text = media.PlainLyrics(content)
+	return &l, nil
+}
+
+// handleItemLyrics returns timed lines (LRC) and plain text for an audio item
+func (s *Server) handleItemLyrics(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	l, err := s.loadLyrics(r.Context(), id)
+	if err != nil {
+		http.Error(w, "no lyrics", 404)
+		return
+	}
+	writeJSON(w, 200, l)
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// Music browsing: artist -> album -> track hierarchy, built by the metadata
+// worker from audio tags. Everything here reads from the DB only.
+
+const trackSelect = `
+	select t.item_id, t.album_id, al.title, t.artist_id, ar.name, t.title,
+	       t.track_no, t.disc_no, t.year, t.genre, t.duration_ms,
+	       t.gain_db, t.peak, al.gain_db, al.peak
+	from track t
+	join media_item mi on mi.id = t.item_id
+	join album al on al.id = t.album_id
+	join artist ar on ar.id = t.artist_id`
+
+func (s *Server) scanTracks(ctx context.Context, rows pgx.Rows) ([]Track, error) {
+	defer rows.Close()
+	out := []Track{}
+	for rows.Next() {
+		var t Track
+		var trackGain, trackPeak, albumGain, albumPeak *float64
+		if err := rows.Scan(&t.ItemID, &t.AlbumID, &t.AlbumTitle, &t.ArtistID, &t.ArtistName, &t.Title,
+			&t.TrackNo, &t.DiscNo, &t.Year, &t.Genre, &t.DurationMs,
+			&trackGain, &trackPeak, &albumGain, &albumPeak); err != nil {
+			return nil, err
+		}
+		t.ReplayGain = newReplayGain(trackGain, trackPeak, albumGain, albumPeak)
+		t.StreamURL = s.mediaURL(ctx, t.ItemID, "stream")
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// handleMusicArtists lists album artists that have at least one present track
+func (s *Server) handleMusicArtists(w http.ResponseWriter, r *http.Request) {
+	lid, _ := strconv.ParseInt(r.URL.Query().Get("library_id"), 10, 64)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	where := []string{"mi.present = true"}
+	args := []any{}
+	if scope := s.libraryScope(r.Context()); scope != nil {
+		args = append(args, scope)
+		where = append(where, fmt.Sprintf("mi.library_id = any($%d)", len(args)))
+	}
+	if lid > 0 {
+		args = append(args, lid)
+		where = append(where, fmt.Sprintf("mi.library_id = $%d", len(args)))
+	}
+	if q != "" {
+		args = append(args, "%"+q+"%")
+		where = append(where, fmt.Sprintf("ar.name ILIKE $%d", len(args)))
+	}
+
+	rows, err := s.DB.Query(r.Context(), fmt.Sprintf(`
+		select ar.id, ar.name, count(distinct al.id), count(t.item_id)
+		from artist ar
+		join album al on al.artist_id = ar.id
+		join track t on t.album_id = al.id
+		join media_item mi on mi.id = t.item_id
+		where %s
+		group by ar.id, ar.name, ar.sort_name
+		order by ar.sort_name asc
+		limit 5000`, strings.Join(where, " and ")), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []Artist{}
+	for rows.Next() {
+		var a Artist
+		if err := rows.Scan(&a.ID, &a.Name, &a.AlbumCount, &a.TrackCount); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out = append(out, a)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleMusicAlbums lists albums, optionally filtered by artist, library or title
+func (s *Server) handleMusicAlbums(w http.ResponseWriter, r *http.Request) {
+	lid, _ := strconv.ParseInt(r.URL.Query().Get("library_id"), 10, 64)
+	artistID, _ := strconv.ParseInt(r.URL.Query().Get("artist_id"), 10, 64)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	sort := strings.TrimSpace(r.URL.Query().Get("sort")) // name|year|recent
+
+	where := []string{"mi.present = true"}
+	args := []any{}
+	if scope := s.libraryScope(r.Context()); scope != nil {
+		args = append(args, scope)
+		where = append(where, fmt.Sprintf("mi.library_id = any($%d)", len(args)))
+	}
+	if lid > 0 {
+		args = append(args, lid)
+		where = append(where, fmt.Sprintf("mi.library_id = $%d", len(args)))
+	}
+	if artistID > 0 {
+		args = append(args, artistID)
+		where = append(where, fmt.Sprintf("al.artist_id = $%d", len(args)))
+	}
+	if q != "" {
+		args = append(args, "%"+q+"%")
+		where = append(where, fmt.Sprintf("al.title ILIKE $%d", len(args)))
+	}
+
+	orderBy := "ar.sort_name asc, al.year asc nulls last, al.title asc"
+	switch sort {
+	case "name":
+		orderBy = "al.title asc"
+	case "year":
+		orderBy = "al.year desc nulls last, al.title asc"
+	case "recent":
+		orderBy = "al.created_at desc"
+	}
+
+	rows, err := s.DB.Query(r.Context(), fmt.Sprintf(`
+		select al.id, al.artist_id, ar.name, al.title, al.year, al.genre,
+		       count(t.item_id), coalesce(sum(t.duration_ms), 0)
+		from album al
+		join artist ar on ar.id = al.artist_id
+		join track t on t.album_id = al.id
+		join media_item mi on mi.id = t.item_id
+		where %s
+		group by al.id, ar.name, ar.sort_name
+		order by %s
+		limit 5000`, strings.Join(where, " and "), orderBy), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []Album{}
+	for rows.Next() {
+		var a Album
+		if err := rows.Scan(&a.ID, &a.ArtistID, &a.ArtistName, &a.Title, &a.Year, &a.Genre, &a.TrackCount, &a.DurationMs); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out = append(out, a)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleMusicAlbum returns one album with its present tracks in disc/track order
+func (s *Server) handleMusicAlbum(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+
+	var out AlbumDetail
+	err := s.DB.QueryRow(r.Context(), `
+		select al.id, al.artist_id, ar.name, al.title, al.year, al.genre
+		from album al
+		join artist ar on ar.id = al.artist_id
+		where al.id = $1`, id,
+	).Scan(&out.ID, &out.ArtistID, &out.ArtistName, &out.Title, &out.Year, &out.Genre)
+	if err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+
+	rows, err := s.DB.Query(r.Context(), trackSelect+`
+		where t.album_id = $1 and mi.present = true and `+inScope("mi.library_id", 2)+`
+		order by t.disc_no asc nulls first, t.track_no asc nulls last, t.title asc`, id, s.libraryScope(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out.Tracks, err = s.scanTracks(r.Context(), rows)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(out.Tracks) == 0 {
+		// Nothing present in a library the user can see
+		http.Error(w, "not found", 404)
+		return
+	}
+	out.TrackCount = int64(len(out.Tracks))
+	for _, t := range out.Tracks {
+		if t.DurationMs != nil {
+			out.DurationMs += int64(*t.DurationMs)
+		}
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleMusicTracks returns a paged track list filtered by library, artist, album or title
+func (s *Server) handleMusicTracks(w http.ResponseWriter, r *http.Request) {
+	lid, _ := strconv.ParseInt(r.URL.Query().Get("library_id"), 10, 64)
+	artistID, _ := strconv.ParseInt(r.URL.Query().Get("artist_id"), 10, 64)
+	albumID, _ := strconv.ParseInt(r.URL.Query().Get("album_id"), 10, 64)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 500 {
+		pageSize = 100
+	}
+
+	where := []string{"mi.present = true"}
+	args := []any{}
+	if scope := s.libraryScope(r.Context()); scope != nil {
+		args = append(args, scope)
+		where = append(where, fmt.Sprintf("mi.library_id = any($%d)", len(args)))
+	}
+	if lid > 0 {
+		args = append(args, lid)
+		where = append(where, fmt.Sprintf("mi.library_id = $%d", len(args)))
+	}
+	if artistID > 0 {
+		// Match both the track artist and the album artist
+		args = append(args, artistID)
+		where = append(where, fmt.Sprintf("(t.artist_id = $%d or al.artist_id = $%d)", len(args), len(args)))
+	}
+	if albumID > 0 {
+		args = append(args, albumID)
+		where = append(where, fmt.Sprintf("t.album_id = $%d", len(args)))
+	}
+	if q != "" {
+		args = append(args, "%"+q+"%")
+		where = append(where, fmt.Sprintf("(t.title ILIKE $%d or ar.name ILIKE $%d or al.title ILIKE $%d)", len(args), len(args), len(args)))
+	}
+	whereSQL := strings.Join(where, " and ")
+
+	var total int64
+	err := s.DB.QueryRow(r.Context(), `
+		select count(*)
+		from track t
+		join media_item mi on mi.id = t.item_id
+		join album al on al.id = t.album_id
+		join artist ar on ar.id = t.artist_id
+		where `+whereSQL, args...).Scan(&total)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := s.DB.Query(r.Context(), fmt.Sprintf(`%s
+		where %s
+		order by ar.sort_name asc, al.title asc, t.disc_no asc nulls first, t.track_no asc nulls last, t.title asc
+		limit $%d offset $%d`, trackSelect, whereSQL, len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	tracks, err := s.scanTracks(r.Context(), rows)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	writeJSON(w, 200, PagedTracks{Page: page, PageSize: pageSize, Total: total, Tracks: tracks})
+}
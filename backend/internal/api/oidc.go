@@ -0,0 +1,344 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/example/mediahub/internal/oidc"
+)
+
+// Single sign-on with an OpenID Connect issuer (Authelia, Keycloak, ...).
+//
+// GET /api/auth/oidc/login sends the browser to the issuer; the issuer sends
+// it back to /api/auth/oidc/callback, which finds the local user of the
+// subject (creating one on first login when OIDC_AUTO_CREATE allows), starts a
+// session and redirects to OIDC_POST_LOGIN_URL with the tokens in the
+// fragment: #token=..&refresh_token=..&expires_in=.. (or #error=..).
+//
+// A logged in user links their account with POST /api/auth/oidc/link, then
+// opens the returned URL in the same browser: the link request sets the state
+// cookie, so a link URL sent to someone else's browser leads nowhere.
+
+const (
+	oidcStateCookie = "mh_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+func (s *Server) handleOIDCConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]any{"enabled": s.OIDC != nil})
+}
+
+// handleOIDCLogin starts an authorization request, or continues the one a
+// link request prepared (?link=<state>)
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.OIDC == nil {
+		http.Error(w, "single sign-on is not configured", 404)
+		return
+	}
+	ctx := r.Context()
+	_, _ = s.DB.Exec(ctx, "delete from oidc_login where created_at < $1", time.Now().Add(-oidcStateTTL))
+
+	var state, nonce, verifier string
+	link := r.URL.Query().Get("link")
+	if link != "" {
+		// The link request set the cookie in the browser of the linking user
+		if c, err := r.Cookie(oidcStateCookie); err != nil || c.Value != link {
+			s.oidcFail(w, r, "link request expired, try again")
+			return
+		}
+		err := s.DB.QueryRow(ctx, `
+			select nonce, verifier from oidc_login
+			where state_hash = $1 and link_user_id is not null`, hashToken(link)).Scan(&nonce, &verifier)
+		if err != nil {
+			s.oidcFail(w, r, "link request expired, try again")
+			return
+		}
+		state = link
+	} else {
+		var err error
+		if state, nonce, verifier, err = s.newOIDCLogin(ctx, 0); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	u, err := s.OIDC.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	if link == "" {
+		setOIDCStateCookie(w, r, state)
+	}
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// setOIDCStateCookie binds the callback of an authorization request to this
+// browser
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name: oidcStateCookie, Value: state, Path: "/api/auth/oidc/", MaxAge: int(oidcStateTTL.Seconds()),
+		HttpOnly: true, Secure: requestIsHTTPS(r), SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) newOIDCLogin(ctx context.Context, linkUserID int64) (state, nonce, verifier string, err error) {
+	state, nonce, verifier = oidc.NewVerifier(), oidc.NewVerifier(), oidc.NewVerifier()
+	var link *int64
+	if linkUserID > 0 {
+		link = &linkUserID
+	}
+	_, err = s.DB.Exec(ctx, `
+		insert into oidc_login(state_hash, nonce, verifier, link_user_id) values ($1, $2, $3, $4)`,
+		hashToken(state), nonce, verifier, link)
+	return state, nonce, verifier, err
+}
+
+// handleOIDCLink prepares linking the current user to an issuer identity
+func (s *Server) handleOIDCLink(w http.ResponseWriter, r *http.Request) {
+	if s.OIDC == nil {
+		http.Error(w, "single sign-on is not configured", 404)
+		return
+	}
+	uid, _ := UserIDFromContext(r.Context())
+	state, _, _, err := s.newOIDCLogin(r.Context(), uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	setOIDCStateCookie(w, r, state)
+	writeJSON(w, 200, map[string]string{"url": "/api/auth/oidc/login?link=" + url.QueryEscape(state)})
+}
+
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if s.OIDC == nil {
+		http.Error(w, "single sign-on is not configured", 404)
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/api/auth/oidc/", MaxAge: -1})
+
+	if e := q.Get("error"); e != "" {
+		s.oidcFail(w, r, "identity provider: "+strings.TrimSpace(e+" "+q.Get("error_description")))
+		return
+	}
+	state := q.Get("state")
+	if c, err := r.Cookie(oidcStateCookie); err != nil || state == "" || c.Value != state {
+		s.oidcFail(w, r, "login request not started from this browser")
+		return
+	}
+
+	var nonce, verifier string
+	var linkUserID *int64
+	err := s.DB.QueryRow(ctx, `
+		delete from oidc_login where state_hash = $1 and created_at > $2
+		returning nonce, verifier, link_user_id`,
+		hashToken(state), time.Now().Add(-oidcStateTTL)).Scan(&nonce, &verifier, &linkUserID)
+	if err != nil {
+		s.oidcFail(w, r, "login request expired, try again")
+		return
+	}
+
+	claims, err := s.OIDC.Exchange(ctx, q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		s.oidcFail(w, r, "could not verify the login")
+		return
+	}
+
+	if linkUserID != nil {
+		if err := s.linkIdentity(ctx, *linkUserID, claims); err != nil {
+			s.oidcFail(w, r, err.Error())
+			return
+		}
+		s.oidcRedirect(w, r, url.Values{"oidc_linked": {"1"}})
+		return
+	}
+
+	uid, err := s.oidcUser(ctx, claims)
+	if err != nil {
+		s.oidcFail(w, r, err.Error())
+		return
+	}
+	s.syncOIDCRole(ctx, uid, claims.Groups)
+
+	sess, err := s.createSession(w, r, uid, "SSO", false)
+	if err != nil {
+		s.oidcFail(w, r, "session error")
+		return
+	}
+	tok, err := s.issueAccessToken(ctx, uid, sess.ID)
+	if err != nil {
+		s.oidcFail(w, r, "token error")
+		return
+	}
+	s.oidcRedirect(w, r, url.Values{
+		"token":         {tok},
+		"refresh_token": {sess.Refresh},
+		"expires_in":    {strconv.Itoa(int(s.Cfg.AccessTTL.Seconds()))},
+	})
+}
+
+// oidcUser finds the local user of an identity, provisioning it when allowed
+func (s *Server) oidcUser(ctx context.Context, c *oidc.Claims) (int64, error) {
+	var uid int64
+	err := s.DB.QueryRow(ctx, "select user_id from user_identity where issuer=$1 and subject=$2",
+		s.OIDC.Issuer(), c.Subject).Scan(&uid)
+	if err == nil {
+		return uid, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+	if !s.Cfg.OIDCAutoCreate {
+		return 0, errors.New("no account is linked to this login")
+	}
+
+	username := c.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(c.Email, "@")
+	}
+	if username == "" {
+		return 0, errors.New("the identity provider sent no username")
+	}
+	// Nobody can log in with the password: it is random and thrown away
+	hash, err := bcrypt.GenerateFromPassword([]byte(oidc.NewVerifier()), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	// An existing local user with that name is not taken over: they link explicitly
+	err = tx.QueryRow(ctx, `
+		insert into app_user(username, password_hash, role) values ($1, $2, $3)
+		on conflict (username) do nothing
+		returning id`, username, string(hash), RoleViewer).Scan(&uid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errors.New("user " + username + " already exists: log in with its password and link single sign-on in settings")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, "insert into user_identity(user_id, issuer, subject, email) values ($1, $2, $3, nullif($4, ''))",
+		uid, s.OIDC.Issuer(), c.Subject, c.Email); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	log.Printf("oidc: created user %s (%d) for subject %s", username, uid, c.Subject)
+	return uid, nil
+}
+
+func (s *Server) linkIdentity(ctx context.Context, uid int64, c *oidc.Claims) error {
+	var owner int64
+	err := s.DB.QueryRow(ctx, `
+		insert into user_identity(user_id, issuer, subject, email) values ($1, $2, $3, nullif($4, ''))
+		on conflict (issuer, subject) do update set email = excluded.email
+		returning user_id`, uid, s.OIDC.Issuer(), c.Subject, c.Email).Scan(&owner)
+	if err != nil {
+		return err
+	}
+	if owner != uid {
+		return errors.New("this login is already linked to another account")
+	}
+	return nil
+}
+
+// syncOIDCRole applies OIDC_ADMIN_GROUPS at each login. The last admin is
+// never demoted, so a misconfigured mapping can't lock everyone out.
+func (s *Server) syncOIDCRole(ctx context.Context, uid int64, groups []string) {
+	if len(s.Cfg.OIDCAdminGroups) == 0 {
+		return
+	}
+	want := RoleViewer
+	for _, g := range groups {
+		if slices.Contains(s.Cfg.OIDCAdminGroups, g) {
+			want = RoleAdmin
+			break
+		}
+	}
+	got, err := s.userRole(ctx, uid)
+	if err != nil || got == want {
+		return
+	}
+	if got == RoleAdmin && s.isLastAdmin(ctx, uid) {
+		log.Printf("oidc: user %d left the admin groups but is the last admin, keeping the role", uid)
+		return
+	}
+	if _, err := s.DB.Exec(ctx, "update app_user set role=$2 where id=$1", uid, want); err != nil {
+		log.Printf("oidc: set role of user %d: %v", uid, err)
+	}
+}
+
+// oidcRedirect returns to the frontend with values in the URL fragment,
+// which browsers don't send to servers or in Referer
+func (s *Server) oidcRedirect(w http.ResponseWriter, r *http.Request, v url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.Redirect(w, r, s.Cfg.OIDCPostLoginURL+"#"+v.Encode(), http.StatusFound)
+}
+
+func (s *Server) oidcFail(w http.ResponseWriter, r *http.Request, msg string) {
+	s.oidcRedirect(w, r, url.Values{"error": {msg}})
+}
+
+// handleListIdentities lists the single sign-on identities of the current user
+func (s *Server) handleListIdentities(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	rows, err := s.DB.Query(r.Context(), `
+		select id, issuer, subject, coalesce(email, ''), created_at
+		from user_identity where user_id = $1 order by created_at asc`, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []UserIdentity{}
+	for rows.Next() {
+		var id UserIdentity
+		if err := rows.Scan(&id.ID, &id.Issuer, &id.Subject, &id.Email, &id.CreatedAt); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out = append(out, id)
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) handleDeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	tag, err := s.DB.Exec(r.Context(), "delete from user_identity where id=$1 and user_id=$2", id, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
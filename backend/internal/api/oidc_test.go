@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/mediahub/internal/config"
+	"github.com/example/mediahub/internal/db"
+	"github.com/example/mediahub/internal/oidc"
+)
+
+func TestOIDCCallbackStateCookie(t *testing.T) {
+	s := &Server{
+		Cfg:  config.Config{OIDCPostLoginURL: "http://app/"},
+		OIDC: oidc.New(oidc.Config{Issuer: "http://127.0.0.1:1", ClientID: "mediahub"}),
+	}
+	for _, tc := range []struct {
+		name, state, cookie string
+	}{
+		{"no cookie", "state-1", ""},
+		{"other browser", "state-1", "state-2"},
+		{"no state", "", "state-1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?code=c&state="+url.QueryEscape(tc.state), nil)
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tc.cookie})
+		}
+		w := httptest.NewRecorder()
+		s.handleOIDCCallback(w, r)
+
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || err != nil {
+			t.Fatalf("%s: status %d, location %q", tc.name, w.Code, w.Header().Get("Location"))
+		}
+		v, _ := url.ParseQuery(loc.Fragment)
+		if v.Get("error") != "login request not started from this browser" || v.Get("token") != "" {
+			t.Errorf("%s: fragment %q", tc.name, loc.Fragment)
+		}
+	}
+}
+
+// TestOIDCUserExistingUsername needs a disposable database:
+// TEST_DATABASE_URL=postgres://... go test ./internal/api
+func TestOIDCUserExistingUsername(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	d, err := db.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Migrate(ctx, "../../migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		DB:   d.Pool,
+		Cfg:  config.Config{OIDCAutoCreate: true},
+		OIDC: oidc.New(oidc.Config{Issuer: "https://idp.test", ClientID: "mediahub"}),
+	}
+	suffix := time.Now().Format("150405.000000")
+	local, fresh := "local-"+suffix, "fresh-"+suffix
+	t.Cleanup(func() {
+		_, _ = d.Pool.Exec(ctx, "delete from app_user where username = any($1)", []string{local, fresh})
+	})
+	var localID int64
+	if err := d.Pool.QueryRow(ctx, "insert into app_user(username, password_hash, role) values ($1, 'x', $2) returning id",
+		local, RoleAdmin).Scan(&localID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same name at the issuer: the local account is not taken over
+	_, err = s.oidcUser(ctx, &oidc.Claims{Subject: "sub-" + local, PreferredUsername: local})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("existing username: err = %v", err)
+	}
+	var linked bool
+	if err := d.Pool.QueryRow(ctx, "select exists(select 1 from user_identity where user_id = $1)", localID).Scan(&linked); err != nil || linked {
+		t.Errorf("identity linked to the local user (err %v)", err)
+	}
+
+	// A new name is provisioned as a viewer, and found again on the next login
+	uid, err := s.oidcUser(ctx, &oidc.Claims{Subject: "sub-" + fresh, Email: fresh + "@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := s.userRole(ctx, uid); role != RoleViewer {
+		t.Errorf("provisioned role %q", role)
+	}
+	again, err := s.oidcUser(ctx, &oidc.Claims{Subject: "sub-" + fresh, PreferredUsername: local})
+	if err != nil || again != uid {
+		t.Errorf("second login: uid %d, err %v", again, err)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// checkPasswordPolicy enforces PASSWORD_MIN_LENGTH (in characters) and
+// PASSWORD_MIN_CLASSES (lowercase, uppercase, digits, other) on a new
+// password, and refuses the username itself
+func (s *Server) checkPasswordPolicy(username, password string) error {
+	if n := s.Cfg.PasswordMinLength; utf8.RuneCountInString(password) < n {
+		return fmt.Errorf("password too short (min %d characters)", n)
+	}
+	// bcrypt ignores anything longer
+	if len(password) > 72 {
+		return fmt.Errorf("password too long (max 72 bytes)")
+	}
+	if strings.EqualFold(password, username) {
+		return fmt.Errorf("password must differ from the username")
+	}
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if n := s.Cfg.PasswordMinClasses; lower+upper+digit+other < n {
+		return fmt.Errorf("password needs %d of: lowercase, uppercase, digits, symbols", n)
+	}
+	return nil
+}
+
+// pendingAccountSetup says what a user must do before using the API: change
+// their password, or enroll in 2FA when it is required for their role
+func (s *Server) pendingAccountSetup(ctx context.Context, uid int64) string {
+	var mustChange, needs2FA bool
+	_ = s.DB.QueryRow(ctx, `
+		select must_change_password,
+		       role = $2 and not totp_enabled
+		       and exists (select 1 from app_setting where key = $3 and value = 'true')
+		from app_user where id = $1`, uid, RoleAdmin, settingRequireAdmin2FA).Scan(&mustChange, &needs2FA)
+	switch {
+	case mustChange:
+		return "password change required"
+	case needs2FA:
+		return "two-factor enrollment required"
+	}
+	return ""
+}
+
+// accountSetupAllowed lists what a user with pending account setup may
+// still do
+func accountSetupAllowed(r *http.Request) bool {
+	p := r.URL.Path
+	return p == "/api/users/password" || p == "/api/users/me" || strings.HasPrefix(p, "/api/users/me/2fa")
+}
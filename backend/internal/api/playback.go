@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/example/mediahub/internal/media"
+	"github.com/example/mediahub/internal/transcode"
+)
+
+// clientProfileFromQuery reads the client capability profile:
+//
+//	?containers=mp4,webm&video_codecs=h264,vp9&audio_codecs=aac,opus
+//	&image_formats=jpeg,webp&max_height=1080&max_bitrate=8000000&max_audio_channels=2
+//
+// Anything left out falls back to the default browser profile.
+func clientProfileFromQuery(q url.Values) media.ClientProfile {
+	p := media.ClientProfile{
+		Containers:   media.ParseCodecList(q.Get("containers")),
+		VideoCodecs:  media.ParseCodecList(q.Get("video_codecs")),
+		AudioCodecs:  media.ParseCodecList(q.Get("audio_codecs")),
+		ImageFormats: media.ParseCodecList(q.Get("image_formats")),
+	}
+	p.MaxHeight, _ = strconv.Atoi(q.Get("max_height"))
+	p.MaxBitrate, _ = strconv.ParseInt(q.Get("max_bitrate"), 10, 64)
+	p.MaxAudioChannels, _ = strconv.Atoi(q.Get("max_audio_channels"))
+	return p
+}
+
+// handlePlaybackInfo reports the container and codecs of an item and whether
+// the client can direct-play it, needs a remux or needs a transcode
+func (s *Server) handlePlaybackInfo(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+
+	out := PlaybackInfo{ItemID: id}
+	si := media.StreamInfo{}
+	var present bool
+	err := s.DB.QueryRow(r.Context(), `
+		select path, kind, present, coalesce(container,''), coalesce(codec,''), coalesce(video_profile,''),
+		       coalesce(pix_fmt,''), width, height, coalesce(audio_codec,''), coalesce(audio_channels,0),
+		       coalesce(bit_rate,0), duration_ms
+		from media_item where id=$1`, id,
+	).Scan(&si.Path, &si.Kind, &present, &si.Container, &si.VideoCodec, &si.VideoProfile,
+		&si.PixFmt, &out.Width, &out.Height, &si.AudioCodec, &si.AudioChannels,
+		&si.BitRate, &out.DurationMs)
+	if err != nil || !present {
+		http.Error(w, "not found", 404)
+		return
+	}
+
+	switch si.Kind {
+	case "audio":
+		// codec holds the audio codec for audio items
+		if si.AudioCodec == "" {
+			si.AudioCodec = si.VideoCodec
+		}
+		si.VideoCodec = ""
+	case "video":
+	default:
+		si.VideoCodec = ""
+	}
+
+	// Not probed by the metadata worker yet: probe now
+	if si.Container == "" && (si.Kind == "video" || si.Kind == "audio") {
+		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+		probe, err := media.Probe(ctx, si.Path)
+		cancel()
+		if err == nil {
+			si = probe.StreamInfo(si.Kind, si.Path)
+			if v := probe.FirstStream("video"); v != nil && si.Kind == "video" && v.Width > 0 {
+				out.Width, out.Height = &v.Width, &v.Height
+			}
+		}
+	}
+	if out.Height != nil {
+		si.Height = *out.Height
+	}
+
+	out.Kind = si.Kind
+	out.MimeType = media.ContentType(si.Kind, si.Path, si.Container)
+	out.Container = si.Container
+	out.VideoCodec = si.VideoCodec
+	out.VideoProfile = si.VideoProfile
+	out.PixFmt = si.PixFmt
+	out.AudioCodec = si.AudioCodec
+	out.AudioChannels = si.AudioChannels
+	out.BitRate = si.BitRate
+	out.PlaybackDecision = media.DecidePlayback(si, clientProfileFromQuery(r.URL.Query()))
+	out.StreamURL = s.mediaURL(r.Context(), id, "stream")
+	if si.Kind == "video" && out.Method != media.DirectPlay {
+		out.HLSURL = s.mediaURL(r.Context(), id, "hls/master.m3u8")
+	}
+	if out.Method == media.Remux {
+		out.RemuxURL = s.mediaURL(r.Context(), id, "stream?remux=mp4")
+	}
+	if si.Kind == "audio" {
+		out.TranscodeURL = s.audioTranscodeFor(r.Context(), id, si, out.Method)
+	}
+
+	// The URL the client should play, cheapest method first; a user transcoding
+	// profile wins over direct play for heavy audio files
+	switch {
+	case out.TranscodeURL != "":
+		out.PlayURL = out.TranscodeURL
+	case si.Kind == "photo" && out.Method == media.Transcode:
+		out.PlayURL = s.mediaURL(r.Context(), id, "image?format=jpeg")
+	case out.Method == media.DirectPlay || si.Kind == "photo":
+		out.PlayURL = out.StreamURL
+	case out.RemuxURL != "":
+		out.PlayURL = out.RemuxURL
+	case out.HLSURL != "":
+		out.PlayURL = out.HLSURL
+	default:
+		out.PlayURL = out.StreamURL
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleRemux streams an item as fragmented MP4 without re-encoding
+// (?remux=mp4). Fragmented output has no byte ranges; clients seek by
+// requesting again with ?t=<seconds>.
+func (s *Server) handleRemux(w http.ResponseWriter, r *http.Request, id int64) {
+	var path, kind, codec string
+	var present bool
+	err := s.DB.QueryRow(r.Context(),
+		"select path, kind, present, coalesce(codec,'') from media_item where id=$1", id,
+	).Scan(&path, &kind, &present, &codec)
+	if err != nil || !present {
+		http.NotFound(w, r)
+		return
+	}
+	if kind != "video" && kind != "audio" {
+		http.Error(w, "cannot remux this item", 400)
+		return
+	}
+	startSec, _ := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+
+	contentType := "video/mp4"
+	if kind == "audio" {
+		contentType = "audio/mp4"
+		codec = ""
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := transcode.RemuxMP4(r.Context(), w, path, startSec, codec); err != nil {
+		// Headers are gone already; the client sees a truncated stream
+		log.Printf("remux item %d: %v", id, err)
+	}
+}
+
+// lossless audio codecs, always worth transcoding when the user asks for a profile
+var losslessAudioCodecs = map[string]bool{
+	"flac": true, "alac": true, "ape": true, "wavpack": true, "tta": true, "dsd_lsbf": true, "dsd_msbf": true,
+}
+
+// audioTranscodeFor returns the transcode URL for an audio item: the user's
+// profile when the source is heavier than it, else mp3 when the client cannot
+// play the source at all
+func (s *Server) audioTranscodeFor(ctx context.Context, id int64, si media.StreamInfo, method string) string {
+	if uid, ok := UserIDFromContext(ctx); ok {
+		if p, ok := s.userAudioProfile(ctx, uid); ok {
+			codec := media.NormalizeCodec(si.AudioCodec)
+			heavy := losslessAudioCodecs[codec] || strings.HasPrefix(codec, "pcm_") ||
+				si.BitRate <= 0 || si.BitRate > int64(p.Kbps)*1000*6/5
+			if heavy || method == media.Transcode {
+				return s.mediaURL(ctx, id, audioTranscodeQuery(p))
+			}
+			return ""
+		}
+	}
+	if method == media.Transcode {
+		p, _ := transcode.ParseAudioProfile("mp3", 0)
+		return s.mediaURL(ctx, id, audioTranscodeQuery(p))
+	}
+	return ""
+}
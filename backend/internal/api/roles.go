@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// User roles. Admins manage libraries, users, scans and imports; viewers
+// browse and play.
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+)
+
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleViewer
+}
+
+// userRole reads the current role of a user. The role is also in the JWT
+// claims for clients, but authorization uses the database so that a demotion
+// applies to tokens already issued.
+func (s *Server) userRole(ctx context.Context, uid int64) (string, error) {
+	var role string
+	err := s.DB.QueryRow(ctx, "select role from app_user where id=$1", uid).Scan(&role)
+	return role, err
+}
+
+// RequireRole only lets users with the given role through
+func (s *Server) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, ok := UserIDFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", 401)
+				return
+			}
+			got, err := s.userRole(r.Context(), uid)
+			if err != nil {
+				http.Error(w, "unauthorized", 401)
+				return
+			}
+			if got != role {
+				http.Error(w, "forbidden: "+role+" role required", 403)
+				return
+			}
+			if scopes, ok := apiKeyScopes(r.Context()); ok && role == RoleAdmin && !scopesAllow(scopes, r, true) {
+				http.Error(w, "forbidden: API key scope", 403)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isLastAdmin reports whether uid is the only admin left
+func (s *Server) isLastAdmin(ctx context.Context, uid int64) bool {
+	var others int64
+	err := s.DB.QueryRow(ctx, "select count(*) from app_user where role=$1 and id<>$2", RoleAdmin, uid).Scan(&others)
+	return err == nil && others == 0
+}
+
+// handleSetUserRole changes the role of a user; the last admin cannot be demoted
+func (s *Server) handleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	if !validRole(req.Role) {
+		http.Error(w, "role must be admin or viewer", 400)
+		return
+	}
+	if req.Role != RoleAdmin && s.isLastAdmin(r.Context(), id) {
+		http.Error(w, "cannot demote the last admin", 400)
+		return
+	}
+	tag, err := s.DB.Exec(r.Context(), "update app_user set role=$2 where id=$1", id, req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "user not found", 404)
+		return
+	}
+	s.audit(r, "user.role", auditUser, id, map[string]any{"role": req.Role})
+	writeJSON(w, 200, map[string]any{"id": id, "role": req.Role})
+}
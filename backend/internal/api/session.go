@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Sessions: every login creates a user_session row. API clients get a
+// short-lived access JWT naming the session (sid) plus a refresh token; POST
+// /api/auth/refresh trades the refresh token for a new pair, rotating it.
+// Presenting a refresh token that was already rotated out means it was
+// copied, so the whole session is revoked.
+//
+// With "session_cookie": true the login also sets an HttpOnly, SameSite=Lax
+// mh_session cookie that AuthMiddleware accepts like a bearer token, so
+// <img>/<video> requests are authenticated without signed URLs. Requests
+// authenticated by the cookie that change state must echo the session's CSRF
+// token (also readable by scripts from the mh_csrf cookie) in X-CSRF-Token.
+// Bearer tokens need none of this.
+//
+// Deleting a session (logout, DELETE /api/users/me/sessions, password change,
+// user deletion) invalidates its cookie, refresh token and access tokens.
+
+const (
+	sessionCookie = "mh_session"
+	csrfCookie    = "mh_csrf"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestIsHTTPS also trusts the reverse proxy header
+func requestIsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// clientIP is the request's remote address without the port (the realip
+// middleware has already applied the headers of trusted proxies)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// refreshReuseGrace tolerates a rotated-out refresh token for a moment, as
+// two tabs of the same browser may refresh concurrently
+const refreshReuseGrace = time.Minute
+
+type sessionTokens struct {
+	ID      int64
+	Refresh string
+	CSRF    string // cookie sessions only
+}
+
+// createSession stores a new session for the user, setting its cookies when
+// cookie is true
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request, userID int64, device string, cookie bool) (sessionTokens, error) {
+	var out sessionTokens
+	refresh, err := randomToken()
+	if err != nil {
+		return out, err
+	}
+	out.Refresh = refresh
+	var tokHash *string
+	var tok string
+	if cookie {
+		if tok, err = randomToken(); err != nil {
+			return out, err
+		}
+		if out.CSRF, err = randomToken(); err != nil {
+			return out, err
+		}
+		h := hashToken(tok)
+		tokHash = &h
+	}
+	expires := time.Now().Add(s.Cfg.SessionTTL)
+
+	// Opportunistic cleanup, logins are rare enough
+	_, _ = s.DB.Exec(r.Context(), "delete from user_session where expires_at < now()")
+	err = s.DB.QueryRow(r.Context(), `
+		insert into user_session(user_id, token_hash, csrf_token, refresh_hash, device, user_agent, ip, expires_at)
+		values ($1, $2, nullif($3, ''), $4, nullif($5, ''), $6, $7, $8)
+		returning id`,
+		userID, tokHash, out.CSRF, hashToken(refresh), device, r.UserAgent(), clientIP(r), expires).Scan(&out.ID)
+	if err != nil {
+		return out, err
+	}
+
+	if cookie {
+		secure := requestIsHTTPS(r)
+		http.SetCookie(w, &http.Cookie{
+			Name: sessionCookie, Value: tok, Path: "/", Expires: expires,
+			HttpOnly: true, Secure: secure, SameSite: http.SameSiteLaxMode,
+		})
+		http.SetCookie(w, &http.Cookie{
+			Name: csrfCookie, Value: out.CSRF, Path: "/", Expires: expires,
+			Secure: secure, SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return out, nil
+}
+
+// sessionFromCookie returns the session, user and CSRF token of a live
+// cookie session
+func (s *Server) sessionFromCookie(ctx context.Context, r *http.Request) (sessionID, userID int64, csrf string, ok bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return 0, 0, "", false
+	}
+	err = s.DB.QueryRow(ctx, `
+		select id, user_id, csrf_token from user_session
+		where token_hash = $1 and expires_at > now()`, hashToken(c.Value)).Scan(&sessionID, &userID, &csrf)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	s.markSessionSeen(ctx, sessionID, r)
+	return sessionID, userID, csrf, true
+}
+
+// touchSession reports whether the session of an access token is still live
+func (s *Server) touchSession(r *http.Request, sessionID, userID int64) bool {
+	var n int
+	err := s.DB.QueryRow(r.Context(), `
+		select count(*) from user_session
+		where id = $1 and user_id = $2 and expires_at > now()`, sessionID, userID).Scan(&n)
+	if err != nil || n == 0 {
+		return false
+	}
+	s.markSessionSeen(r.Context(), sessionID, r)
+	return true
+}
+
+// markSessionSeen keeps last_seen_at coarse to avoid a write per request
+func (s *Server) markSessionSeen(ctx context.Context, sessionID int64, r *http.Request) {
+	_, _ = s.DB.Exec(ctx, `
+		update user_session set last_seen_at = now(), ip = $2
+		where id = $1 and last_seen_at < now() - interval '5 minutes'`, sessionID, clientIP(r))
+}
+
+// revokeUserSessions ends all sessions of a user but keep (0 for none)
+func (s *Server) revokeUserSessions(ctx context.Context, userID, keep int64) error {
+	_, err := s.DB.Exec(ctx, "delete from user_session where user_id = $1 and id <> $2", userID, keep)
+	return err
+}
+
+// issueAccessToken signs an access JWT for a session of userID
+func (s *Server) issueAccessToken(ctx context.Context, userID, sessionID int64) (string, error) {
+	role, err := s.userRole(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return MakeJWT(s.JWTSecret, userID, sessionID, role, s.Cfg.AccessTTL)
+}
+
+// handleRefresh trades a refresh token for a new access token and a new
+// refresh token, extending the session
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token required", 400)
+		return
+	}
+	next, err := randomToken()
+	if err != nil {
+		http.Error(w, "token error", 500)
+		return
+	}
+
+	old := hashToken(req.RefreshToken)
+	var sid, uid int64
+	err = s.DB.QueryRow(r.Context(), `
+		update user_session
+		set prev_refresh_hash = refresh_hash, refresh_hash = $2, rotated_at = now(),
+		    last_seen_at = now(), ip = $3, user_agent = $4, expires_at = $5
+		where refresh_hash = $1 and expires_at > now()
+		returning id, user_id`,
+		old, hashToken(next), clientIP(r), r.UserAgent(), time.Now().Add(s.Cfg.SessionTTL)).Scan(&sid, &uid)
+	if err != nil {
+		tag, err := s.DB.Exec(r.Context(), `
+			delete from user_session
+			where prev_refresh_hash = $1 and rotated_at < $2`,
+			old, time.Now().Add(-refreshReuseGrace))
+		if err == nil && tag.RowsAffected() > 0 {
+			log.Printf("refresh token reused from %s, session revoked", clientIP(r))
+		}
+		http.Error(w, "invalid refresh token", 401)
+		return
+	}
+
+	tok, err := s.issueAccessToken(r.Context(), uid, sid)
+	if err != nil {
+		http.Error(w, "token error", 500)
+		return
+	}
+	writeJSON(w, 200, LoginResponse{Token: tok, RefreshToken: next, ExpiresIn: int(s.Cfg.AccessTTL.Seconds())})
+}
+
+// csrfSafe reports whether a cookie-authenticated request may proceed
+func csrfSafe(r *http.Request, csrf string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	got := r.Header.Get(csrfHeader)
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(csrf)) == 1
+}
+
+func clearSessionCookies(w http.ResponseWriter, r *http.Request) {
+	for _, name := range []string{sessionCookie, csrfCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name: name, Value: "", Path: "/", MaxAge: -1,
+			HttpOnly: name == sessionCookie, Secure: requestIsHTTPS(r), SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// handleLogout ends the session of the bearer token or of the mh_session
+// cookie and clears the cookies. It runs without AuthMiddleware so that an
+// expired session can still be cleared, and checks the CSRF token itself.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if uid, sid, err := parseAccessToken(s.JWTSecret, strings.TrimPrefix(auth, "Bearer ")); err == nil {
+			if _, err := s.DB.Exec(r.Context(), "delete from user_session where id=$1 and user_id=$2", sid, uid); err != nil {
+				log.Printf("logout: %v", err)
+				http.Error(w, "logout failed", 500)
+				return
+			}
+		}
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err == nil && c.Value != "" {
+		var csrf string
+		err := s.DB.QueryRow(r.Context(), "select csrf_token from user_session where token_hash=$1", hashToken(c.Value)).Scan(&csrf)
+		if err == nil {
+			if !csrfSafe(r, csrf) {
+				http.Error(w, "missing or invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			if _, err := s.DB.Exec(r.Context(), "delete from user_session where token_hash=$1", hashToken(c.Value)); err != nil {
+				log.Printf("logout: %v", err)
+				http.Error(w, "logout failed", 500)
+				return
+			}
+		}
+	}
+	clearSessionCookies(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListSessions lists the live sessions of the current user
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	current, _ := SessionIDFromContext(r.Context())
+	rows, err := s.DB.Query(r.Context(), `
+		select id, coalesce(device, ''), coalesce(user_agent, ''), coalesce(ip, ''), token_hash is not null,
+		       created_at, last_seen_at, expires_at
+		from user_session
+		where user_id = $1 and expires_at > now()
+		order by last_seen_at desc`, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []UserSession{}
+	for rows.Next() {
+		var us UserSession
+		if err := rows.Scan(&us.ID, &us.Device, &us.UserAgent, &us.IP, &us.Cookie, &us.CreatedAt, &us.LastSeenAt, &us.ExpiresAt); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		us.Current = us.ID == current
+		out = append(out, us)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleRevokeSession ends one session of the current user
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	tag, err := s.DB.Exec(r.Context(), "delete from user_session where id=$1 and user_id=$2", id, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if current, _ := SessionIDFromContext(r.Context()); current == id {
+		clearSessionCookies(w, r)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevokeSessions ends all sessions of the current user, or all but the
+// current one with ?keep_current=true
+func (s *Server) handleRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var keep int64
+	if r.URL.Query().Get("keep_current") == "true" {
+		keep, _ = SessionIDFromContext(r.Context())
+	}
+	if err := s.revokeUserSessions(r.Context(), uid, keep); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if keep == 0 {
+		clearSessionCookies(w, r)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,524 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/example/mediahub/internal/transcode"
+)
+
+// Share links give people without an account one item, a folder of a
+// library or a tag, through the unauthenticated /s/{token} endpoints:
+//
+//	GET  /s/{token}?path=                  listing (folders of a folder share, items)
+//	POST /s/{token}/unlock                 {"password"} -> {"access"} for ?access=
+//	GET  /s/{token}/items/{id}/thumb       thumbnail
+//	GET  /s/{token}/items/{id}/stream      the file, through the Streamer
+//	GET  /s/{token}/items/{id}/download    the file as an attachment, if allowed
+//
+// Without downloads, /stream only serves what a player needs: videos and
+// audio, and photos as a screen-sized rendition instead of the original.
+//
+// Nothing outside the share is reachable, and items stay limited to the
+// libraries the owner can see. Items are named by their path inside the share,
+// never by their location on disk.
+
+const (
+	shareTokenPrefix = 8 // characters of the token kept to tell shares apart
+	shareAccessTTL   = 12 * time.Hour
+)
+
+// sharedPhotoOptions is the rendition of photos in shares without downloads
+var sharedPhotoOptions = transcode.ImageOptions{Width: 1920, Height: 1920, Fit: transcode.FitInside, Format: transcode.ImageFormats["jpeg"], Quality: 85}
+
+type shareLink struct {
+	ID            int64
+	UserID        int64
+	Kind          string
+	ItemID        *int64
+	LibraryID     *int64
+	FolderPath    *string
+	TagID         *int64
+	Name          string
+	PasswordHash  *string
+	AllowDownload bool
+	ExpiresAt     *time.Time
+}
+
+// shareAccess signs the proof that a share's password was given
+func (s *Server) shareAccess(sh *shareLink, exp int64) string {
+	k := sha256.Sum256([]byte("share-access:" + s.JWTSecret))
+	mac := hmac.New(sha256.New, k[:])
+	fmt.Fprintf(mac, "%d\n%s\n%d", sh.ID, *sh.PasswordHash, exp)
+	return strconv.FormatInt(exp, 10) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) validShareAccess(sh *shareLink, access string) bool {
+	expStr, _, ok := strings.Cut(access, ".")
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if !ok || err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(access), []byte(s.shareAccess(sh, exp)))
+}
+
+// findShare looks up a share by the {token} of the URL, answering 404 or 410
+// itself when there is none
+func (s *Server) findShare(w http.ResponseWriter, r *http.Request) (*shareLink, bool) {
+	var sh shareLink
+	err := s.DB.QueryRow(r.Context(), `
+		select id, user_id, kind, item_id, library_id, folder_path, tag_id, name, password_hash, allow_download, expires_at
+		from share_link where token_hash = $1`, hashToken(chi.URLParam(r, "token"))).Scan(
+		&sh.ID, &sh.UserID, &sh.Kind, &sh.ItemID, &sh.LibraryID, &sh.FolderPath, &sh.TagID,
+		&sh.Name, &sh.PasswordHash, &sh.AllowDownload, &sh.ExpiresAt)
+	if err != nil {
+		http.Error(w, "share not found", 404)
+		return nil, false
+	}
+	if sh.ExpiresAt != nil && time.Now().After(*sh.ExpiresAt) {
+		http.Error(w, "share expired", http.StatusGone)
+		return nil, false
+	}
+	return &sh, true
+}
+
+// openShare is findShare for the content endpoints, which also need the
+// ?access= of a password protected share
+func (s *Server) openShare(w http.ResponseWriter, r *http.Request) (*shareLink, bool) {
+	sh, ok := s.findShare(w, r)
+	if !ok {
+		return nil, false
+	}
+	if sh.PasswordHash != nil && !s.validShareAccess(sh, r.URL.Query().Get("access")) {
+		http.Error(w, "password required", 401)
+		return nil, false
+	}
+	return sh, true
+}
+
+// sharedItems is the SQL condition on media_item mi selecting what a share
+// exposes, with its arguments starting at $1
+func (s *Server) sharedItems(ctx context.Context, sh *shareLink) (string, []any) {
+	owner := s.libraryScope(context.WithValue(ctx, userIDKey, sh.UserID))
+	switch sh.Kind {
+	case "item":
+		return "mi.present and mi.id = $1 and " + inScope("mi.library_id", 2), []any{*sh.ItemID, owner}
+	case "folder":
+		return `mi.present and mi.library_id = $1
+			and ($2::text = '' or left(mi.rel_path, length($2::text) + 1) = $2::text || '/') and ` + inScope("mi.library_id", 3),
+			[]any{*sh.LibraryID, *sh.FolderPath, owner}
+	default:
+		return `mi.present and mi.id in (select item_id from item_tag where tag_id = $1) and ` + inScope("mi.library_id", 2),
+			[]any{*sh.TagID, owner}
+	}
+}
+
+// cleanSharePath normalizes a folder path relative to a library or share,
+// refusing anything that climbs out of it
+func cleanSharePath(p string) (string, bool) {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return "", true
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", false
+		}
+	}
+	return p, true
+}
+
+func (s *Server) handleShareListing(w http.ResponseWriter, r *http.Request) {
+	sh, ok := s.findShare(w, r)
+	if !ok {
+		return
+	}
+	out := SharedListing{
+		Name: sh.Name, Kind: sh.Kind, AllowDownload: sh.AllowDownload, ExpiresAt: sh.ExpiresAt,
+		PasswordRequired: sh.PasswordHash != nil, Folders: []string{}, Items: []SharedItem{},
+	}
+	access := r.URL.Query().Get("access")
+	if sh.PasswordHash != nil && !s.validShareAccess(sh, access) {
+		// Enough for the page to ask for the password
+		writeJSON(w, 401, out)
+		return
+	}
+	sub, ok := cleanSharePath(r.URL.Query().Get("path"))
+	if !ok || (sub != "" && sh.Kind != "folder") {
+		http.Error(w, "bad path", 400)
+		return
+	}
+	out.Path = sub
+
+	// Items are named relative to the shared folder, or by file name
+	base := ""
+	if sh.Kind == "folder" {
+		base = *sh.FolderPath
+	}
+	dir := strings.Trim(base+"/"+sub, "/")
+
+	where, args := s.sharedItems(r.Context(), sh)
+	args = append(args, dir)
+	n := len(args)
+	where += fmt.Sprintf(" and ($%d::text = '' or left(mi.rel_path, length($%d::text) + 1) = $%d::text || '/')", n, n, n)
+	// Path of items below dir; a folder share lists its direct children only
+	rest := fmt.Sprintf("(case when $%d::text = '' then mi.rel_path else substr(mi.rel_path, length($%d::text) + 2) end)", n, n)
+	itemsWhere := where
+	if sh.Kind == "folder" {
+		subdirs, err := s.DB.Query(r.Context(), fmt.Sprintf(`
+			select distinct split_part(%s, '/', 1)
+			from media_item mi
+			where %s and strpos(%s, '/') > 0
+			order by 1`, rest, where, rest), args...)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		for subdirs.Next() {
+			var f string
+			if err := subdirs.Scan(&f); err != nil {
+				subdirs.Close()
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			out.Folders = append(out.Folders, f)
+		}
+		subdirs.Close()
+		itemsWhere += fmt.Sprintf(" and strpos(%s, '/') = 0", rest)
+	}
+	rows, err := s.DB.Query(r.Context(), fmt.Sprintf(`
+		select mi.id, mi.rel_path, mi.kind, mi.size_bytes, mi.mtime, coalesce(mi.thumb_path, '')
+		from media_item mi
+		where %s
+		order by mi.rel_path asc
+		limit 5000`, itemsWhere), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	token := chi.URLParam(r, "token")
+	query := ""
+	if access != "" {
+		query = "?access=" + access
+	}
+	for rows.Next() {
+		var it SharedItem
+		var relPath, thumb string
+		if err := rows.Scan(&it.ID, &relPath, &it.Kind, &it.SizeBytes, &it.MTime, &thumb); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		it.Name = path.Base(relPath)
+		if sh.Kind == "folder" {
+			it.Path = strings.TrimPrefix(strings.TrimPrefix(relPath, base), "/")
+		} else {
+			it.Path = it.Name
+		}
+		itemURL := fmt.Sprintf("/s/%s/items/%d/", token, it.ID)
+		if thumb != "" {
+			it.ThumbURL = itemURL + "thumb" + query
+		}
+		if sh.AllowDownload {
+			it.DownloadURL = itemURL + "download" + query
+		}
+		if sh.AllowDownload || sharedPlayable(it.Kind) {
+			it.StreamURL = itemURL + "stream" + query
+		}
+		out.Items = append(out.Items, it)
+	}
+
+	// A view is an opening of the share, not each folder visited
+	if sub == "" {
+		_, _ = s.DB.Exec(r.Context(), "update share_link set views = views + 1, last_viewed_at = now() where id = $1", sh.ID)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleShareUnlock trades a share's password for an access token, throttled
+// like logins
+func (s *Server) handleShareUnlock(w http.ResponseWriter, r *http.Request) {
+	sh, ok := s.findShare(w, r)
+	if !ok {
+		return
+	}
+	if sh.PasswordHash == nil {
+		writeJSON(w, 200, map[string]string{"access": ""})
+		return
+	}
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	ip, key := clientIP(r), "share:"+strconv.FormatInt(sh.ID, 10)
+	attempt, wait := s.Logins.Begin(ip, key)
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "too many attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(*sh.PasswordHash), []byte(req.Password)) != nil {
+		attempt.Fail()
+		http.Error(w, "wrong password", 401)
+		return
+	}
+	attempt.Succeed()
+	writeJSON(w, 200, map[string]string{"access": s.shareAccess(sh, time.Now().Add(shareAccessTTL).Unix())})
+}
+
+// sharedItem returns the item of a content endpoint when the share exposes it
+func (s *Server) sharedItem(w http.ResponseWriter, r *http.Request) (*shareLink, int64, bool) {
+	sh, ok := s.openShare(w, r)
+	if !ok {
+		return nil, 0, false
+	}
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	where, args := s.sharedItems(r.Context(), sh)
+	args = append(args, id)
+	var shared bool
+	err := s.DB.QueryRow(r.Context(), fmt.Sprintf(
+		"select exists (select 1 from media_item mi where %s and mi.id = $%d)", where, len(args)), args...).Scan(&shared)
+	if err != nil || !shared {
+		http.Error(w, "not found", 404)
+		return nil, 0, false
+	}
+	return sh, id, true
+}
+
+func (s *Server) handleSharedThumb(w http.ResponseWriter, r *http.Request) {
+	_, id, ok := s.sharedItem(w, r)
+	if !ok {
+		return
+	}
+	var thumbPath string
+	err := s.DB.QueryRow(r.Context(), "select coalesce(thumb_path,'') from media_item where id=$1", id).Scan(&thumbPath)
+	if err != nil || thumbPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, thumbPath)
+}
+
+func (s *Server) handleSharedStream(w http.ResponseWriter, r *http.Request) {
+	sh, id, ok := s.sharedItem(w, r)
+	if !ok {
+		return
+	}
+	if !sh.AllowDownload {
+		var kind, filePath string
+		if err := s.DB.QueryRow(r.Context(), "select kind, path from media_item where id=$1", id).Scan(&kind, &filePath); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !sharedPlayable(kind) {
+			http.Error(w, "downloads are not allowed for this share", 403)
+			return
+		}
+		if kind == "photo" {
+			s.Images.Serve(w, r, id, filePath, sharedPhotoOptions)
+			return
+		}
+	}
+	s.Streamer.StreamByID(w, r, id)
+}
+
+// sharedPlayable reports whether items of a kind are viewed in the share page
+// even when downloads are off
+func sharedPlayable(kind string) bool {
+	return kind == "video" || kind == "audio" || kind == "photo"
+}
+
+func (s *Server) handleSharedDownload(w http.ResponseWriter, r *http.Request) {
+	sh, id, ok := s.sharedItem(w, r)
+	if !ok {
+		return
+	}
+	if !sh.AllowDownload {
+		http.Error(w, "downloads are not allowed for this share", 403)
+		return
+	}
+	var relPath string
+	_ = s.DB.QueryRow(r.Context(), "select rel_path from media_item where id=$1", id).Scan(&relPath)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(relPath)}))
+	s.Streamer.StreamByID(w, r, id)
+}
+
+// handleCreateShare shares one of item_id, library_id (+ path) or tag_id.
+// The token is only ever returned here.
+func (s *Server) handleCreateShare(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var req CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	targets := 0
+	for _, t := range []*int64{req.ItemID, req.LibraryID, req.TagID} {
+		if t != nil {
+			targets++
+		}
+	}
+	if targets != 1 {
+		http.Error(w, "share one of item_id, library_id or tag_id", 400)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at is in the past", 400)
+		return
+	}
+	if len(req.Password) > 72 {
+		http.Error(w, "password too long (max 72 bytes)", 400)
+		return
+	}
+
+	sh := Share{ItemID: req.ItemID, LibraryID: req.LibraryID, TagID: req.TagID, AllowDownload: req.AllowDownload, ExpiresAt: req.ExpiresAt}
+	var defaultName string
+	var err error
+	switch {
+	case req.ItemID != nil:
+		sh.Kind = "item"
+		var relPath string
+		err = s.DB.QueryRow(r.Context(), "select rel_path from media_item where id=$1 and present", *req.ItemID).Scan(&relPath)
+		if err != nil || !s.canAccessItem(r.Context(), *req.ItemID) {
+			http.Error(w, "item not found", 404)
+			return
+		}
+		defaultName = path.Base(relPath)
+	case req.LibraryID != nil:
+		sh.Kind = "folder"
+		folder, ok := cleanSharePath(req.Path)
+		if !ok {
+			http.Error(w, "bad path", 400)
+			return
+		}
+		sh.FolderPath = &folder
+		var libName string
+		var found bool
+		err = s.DB.QueryRow(r.Context(), `
+			select l.name, exists (
+				select 1 from media_item mi where mi.library_id = l.id and mi.present
+				and ($2::text = '' or left(mi.rel_path, length($2::text) + 1) = $2::text || '/'))
+			from library l where l.id = $1`, *req.LibraryID, folder).Scan(&libName, &found)
+		if err != nil || !found || !s.canAccessLibrary(r.Context(), *req.LibraryID) {
+			http.Error(w, "folder not found", 404)
+			return
+		}
+		defaultName = libName
+		if folder != "" {
+			defaultName = path.Base(folder)
+		}
+	default:
+		sh.Kind = "tag"
+		err = s.DB.QueryRow(r.Context(), "select name from tag where id=$1", *req.TagID).Scan(&defaultName)
+		if err != nil {
+			http.Error(w, "tag not found", 404)
+			return
+		}
+	}
+	sh.Name = strings.TrimSpace(req.Name)
+	if sh.Name == "" {
+		sh.Name = defaultName
+	}
+
+	var passwordHash *string
+	if req.Password != "" {
+		h, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "hash error", 500)
+			return
+		}
+		hs := string(h)
+		passwordHash = &hs
+		sh.HasPassword = true
+	}
+	token, err := randomToken()
+	if err != nil {
+		http.Error(w, "token error", 500)
+		return
+	}
+	sh.Prefix = token[:shareTokenPrefix]
+
+	err = s.DB.QueryRow(r.Context(), `
+		insert into share_link(user_id, kind, item_id, library_id, folder_path, tag_id, name, prefix, token_hash,
+		                       password_hash, allow_download, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		returning id, created_at`,
+		uid, sh.Kind, sh.ItemID, sh.LibraryID, sh.FolderPath, sh.TagID, sh.Name, sh.Prefix, hashToken(token),
+		passwordHash, sh.AllowDownload, sh.ExpiresAt).Scan(&sh.ID, &sh.CreatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "share.create", auditShare, sh.ID, map[string]any{
+		"kind": sh.Kind, "name": sh.Name, "password": sh.HasPassword, "allow_download": sh.AllowDownload, "expires_at": sh.ExpiresAt,
+	})
+	writeJSON(w, 201, CreateShareResponse{Share: sh, Token: token, Path: "/s/" + token})
+}
+
+// handleListShares lists the shares of the current user
+func (s *Server) handleListShares(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	rows, err := s.DB.Query(r.Context(), `
+		select id, kind, item_id, library_id, folder_path, tag_id, name, prefix, password_hash is not null,
+		       allow_download, views, created_at, last_viewed_at, expires_at
+		from share_link where user_id = $1
+		order by created_at desc`, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []Share{}
+	for rows.Next() {
+		var sh Share
+		if err := rows.Scan(&sh.ID, &sh.Kind, &sh.ItemID, &sh.LibraryID, &sh.FolderPath, &sh.TagID, &sh.Name, &sh.Prefix,
+			&sh.HasPassword, &sh.AllowDownload, &sh.Views, &sh.CreatedAt, &sh.LastViewedAt, &sh.ExpiresAt); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out = append(out, sh)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleDeleteShare revokes a share of the current user
+func (s *Server) handleDeleteShare(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var name string
+	err := s.DB.QueryRow(r.Context(), "delete from share_link where id=$1 and user_id=$2 returning name", id, uid).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "share.delete", auditShare, id, map[string]any{"name": name})
+	w.WriteHeader(http.StatusNoContent)
+}
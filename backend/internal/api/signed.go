@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media URLs are loaded by <img>, <video> and <track> elements, which cannot
+// send an Authorization header. Instead they carry an HMAC signature over the
+// item id, the user id, the session, the purpose (the first path segment after
+// the item: stream, thumb, image, subtitles or hls) and an expiry:
+//
+//	/api/items/42/stream?uid=7&sid=12&exp=1735689600&sig=...
+//
+// The signature does not cover the rest of the query, so one signed stream URL
+// also serves ?remux=mp4 or ?format=opus for the same item. A URL only works
+// while its session does: logging out, "log out everywhere" and a password
+// change end it. Requests authenticated by API key have no session and get
+// unsigned URLs, which they fetch with their key.
+
+// signedPurposes are the item endpoints reachable with a signed URL
+var signedPurposes = map[string]bool{"stream": true, "thumb": true, "image": true, "subtitles": true, "hls": true}
+
+func mediaSignature(secret string, itemID, uid, sid int64, purpose string, exp int64) string {
+	k := sha256.Sum256([]byte("media-url:" + secret))
+	mac := hmac.New(sha256.New, k[:])
+	fmt.Fprintf(mac, "%d\n%d\n%d\n%s\n%d", itemID, uid, sid, purpose, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURLExpiry rounds the expiry up to the hour so that listing the same
+// items twice yields the same URLs and browsers can reuse cached thumbnails
+func signedURLExpiry(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).Truncate(time.Hour).Add(time.Hour).Unix()
+}
+
+// mediaURL returns the signed URL of an item endpoint for the current user
+// and session; rest is the part after /api/items/{id}/, e.g. "thumb" or
+// "stream?remux=mp4"
+func (s *Server) mediaURL(ctx context.Context, itemID int64, rest string) string {
+	u := fmt.Sprintf("/api/items/%d/%s", itemID, rest)
+	uid, ok := UserIDFromContext(ctx)
+	if !ok {
+		return u
+	}
+	sid, ok := SessionIDFromContext(ctx)
+	if !ok {
+		return u
+	}
+	purpose, _, _ := strings.Cut(rest, "/")
+	purpose, _, _ = strings.Cut(purpose, "?")
+	exp := signedURLExpiry(s.Cfg.SignedURLTTL)
+
+	sep := "?"
+	if strings.Contains(rest, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%suid=%d&sid=%d&exp=%d&sig=%s", u, sep, uid, sid, exp, mediaSignature(s.JWTSecret, itemID, uid, sid, purpose, exp))
+}
+
+// setItemURLs fills the signed thumb and stream URLs of an item response
+func (s *Server) setItemURLs(ctx context.Context, it *MediaItem, hasThumb bool) {
+	if hasThumb {
+		it.ThumbURL = s.mediaURL(ctx, it.ID, "thumb")
+	}
+	it.StreamURL = s.mediaURL(ctx, it.ID, "stream")
+}
+
+// verifyMediaSignature checks a signed media URL and returns the user and
+// session it was issued to; the caller checks that the session is still live
+func verifyMediaSignature(secret string, r *http.Request) (uid, sid int64, ok bool) {
+	q := r.URL.Query()
+	sig := q.Get("sig")
+	if sig == "" {
+		return 0, 0, false
+	}
+	rest, found := strings.CutPrefix(r.URL.Path, "/api/items/")
+	if !found {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) < 2 || !signedPurposes[parts[1]] {
+		return 0, 0, false
+	}
+	itemID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	uid, err1 := strconv.ParseInt(q.Get("uid"), 10, 64)
+	sid, err2 := strconv.ParseInt(q.Get("sid"), 10, 64)
+	exp, err3 := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || time.Now().Unix() > exp {
+		return 0, 0, false
+	}
+	want := mediaSignature(secret, itemID, uid, sid, parts[1], exp)
+	return uid, sid, hmac.Equal([]byte(sig), []byte(want))
+}
+
+// signatureQuery returns the signing parameters of a signed request as a
+// query string ("?uid=..&sid=..&exp=..&sig=..") so that URIs in HLS playlists can
+// carry them on; empty for header-authenticated requests
+func signatureQuery(r *http.Request) string {
+	q := r.URL.Query()
+	if q.Get("sig") == "" {
+		return ""
+	}
+	v := url.Values{}
+	for _, k := range []string{"uid", "sid", "exp", "sig"} {
+		v.Set(k, q.Get(k))
+	}
+	return "?" + v.Encode()
+}
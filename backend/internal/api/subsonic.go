@@ -0,0 +1,942 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/example/mediahub/internal/media"
+	"github.com/example/mediahub/internal/transcode"
+)
+
+// Subsonic / OpenSubsonic API (http://www.subsonic.org/pages/api.jsp) on top of
+// the music tables. Libraries are exposed as music folders, user_favorite as
+// stars and user_playback as scrobbles. IDs are opaque strings: "ar-<id>" for
+// artists, "al-<id>" for albums and the media_item id for songs.
+
+const (
+	subsonicAPIVersion = "1.16.1"
+	subsonicXMLNS      = "http://subsonic.org/restapi"
+	ignoredArticles    = "The El La Los Las Le Les"
+)
+
+// Subsonic error codes
+const (
+	subsonicErrGeneric          = 0
+	subsonicErrMissingParam     = 10
+	subsonicErrWrongCredentials = 40
+	subsonicErrNotFound         = 70
+)
+
+type subsonicResponse struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns         string   `xml:"xmlns,attr" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error         *subsonicError         `xml:"error,omitempty" json:"error,omitempty"`
+	License       *subsonicLicense       `xml:"license,omitempty" json:"license,omitempty"`
+	MusicFolders  *subsonicMusicFolders  `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *subsonicIndexes       `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Artists       *subsonicIndexes       `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist        *subsonicArtist        `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *subsonicAlbum         `xml:"album,omitempty" json:"album,omitempty"`
+	Song          *subsonicSong          `xml:"song,omitempty" json:"song,omitempty"`
+	SearchResult3 *subsonicSearchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Starred2      *subsonicStarred2      `xml:"starred2,omitempty" json:"starred2,omitempty"`
+	Lyrics        *subsonicLyrics        `xml:"lyrics,omitempty" json:"lyrics,omitempty"`
+	LyricsList    *subsonicLyricsList    `xml:"lyricsList,omitempty" json:"lyricsList,omitempty"`
+}
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicMusicFolders struct {
+	MusicFolder []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int64  `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicIndexes struct {
+	LastModified    int64           `xml:"lastModified,attr,omitempty" json:"lastModified,omitempty"`
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []subsonicIndex `xml:"index" json:"index"`
+}
+
+type subsonicIndex struct {
+	Name   string           `xml:"name,attr" json:"name"`
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID         string          `xml:"id,attr" json:"id"`
+	Name       string          `xml:"name,attr" json:"name"`
+	CoverArt   string          `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int             `xml:"albumCount,attr" json:"albumCount"`
+	Album      []subsonicAlbum `xml:"album,omitempty" json:"album,omitempty"`
+}
+
+type subsonicAlbum struct {
+	ID        string         `xml:"id,attr" json:"id"`
+	Name      string         `xml:"name,attr" json:"name"`
+	Artist    string         `xml:"artist,attr" json:"artist"`
+	ArtistID  string         `xml:"artistId,attr" json:"artistId"`
+	CoverArt  string         `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int            `xml:"songCount,attr" json:"songCount"`
+	Duration  int            `xml:"duration,attr" json:"duration"`
+	Created   string         `xml:"created,attr" json:"created"`
+	Year      int            `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre     string         `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	Song      []subsonicSong `xml:"song,omitempty" json:"song,omitempty"`
+}
+
+type subsonicSong struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr" json:"parent"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr" json:"album"`
+	Artist      string `xml:"artist,attr" json:"artist"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber  int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64  `xml:"size,attr" json:"size"`
+	ContentType string `xml:"contentType,attr" json:"contentType"`
+	Suffix      string `xml:"suffix,attr" json:"suffix"`
+	Duration    int    `xml:"duration,attr" json:"duration"`
+	Path        string `xml:"path,attr" json:"path"`
+	Created     string `xml:"created,attr" json:"created"`
+	Starred     string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	AlbumID     string `xml:"albumId,attr" json:"albumId"`
+	ArtistID    string `xml:"artistId,attr" json:"artistId"`
+	Type        string `xml:"type,attr" json:"type"`
+
+	ReplayGain *subsonicReplayGain `xml:"replayGain,omitempty" json:"replayGain,omitempty"`
+}
+
+// OpenSubsonic replayGain on songs
+type subsonicReplayGain struct {
+	TrackGain *float64 `xml:"trackGain,attr,omitempty" json:"trackGain,omitempty"`
+	AlbumGain *float64 `xml:"albumGain,attr,omitempty" json:"albumGain,omitempty"`
+	TrackPeak *float64 `xml:"trackPeak,attr,omitempty" json:"trackPeak,omitempty"`
+	AlbumPeak *float64 `xml:"albumPeak,attr,omitempty" json:"albumPeak,omitempty"`
+}
+
+type subsonicSearchResult3 struct {
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+	Album  []subsonicAlbum  `xml:"album" json:"album"`
+	Song   []subsonicSong   `xml:"song" json:"song"`
+}
+
+type subsonicStarred2 struct {
+	Song []subsonicSong `xml:"song" json:"song"`
+}
+
+type subsonicLyrics struct {
+	Artist string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Title  string `xml:"title,attr,omitempty" json:"title,omitempty"`
+	Value  string `xml:",chardata" json:"value"`
+}
+
+// OpenSubsonic songLyrics extension
+type subsonicLyricsList struct {
+	StructuredLyrics []subsonicStructuredLyrics `xml:"structuredLyrics" json:"structuredLyrics"`
+}
+
+type subsonicStructuredLyrics struct {
+	DisplayArtist string               `xml:"displayArtist,attr,omitempty" json:"displayArtist,omitempty"`
+	DisplayTitle  string               `xml:"displayTitle,attr,omitempty" json:"displayTitle,omitempty"`
+	Lang          string               `xml:"lang,attr" json:"lang"`
+	Offset        int                  `xml:"offset,attr" json:"offset"`
+	Synced        bool                 `xml:"synced,attr" json:"synced"`
+	Line          []subsonicLyricsLine `xml:"line" json:"line"`
+}
+
+type subsonicLyricsLine struct {
+	Start *int   `xml:"start,attr,omitempty" json:"start,omitempty"`
+	Value string `xml:",chardata" json:"value"`
+}
+
+func (s *Server) subsonicRoutes(r chi.Router) {
+	r.Use(s.subsonicAuth)
+
+	// Clients call both "/rest/ping" and "/rest/ping.view", with GET or POST
+	handle := func(name string, h http.HandlerFunc) {
+		r.HandleFunc("/"+name, h)
+		r.HandleFunc("/"+name+".view", h)
+	}
+	handle("ping", s.handleSubsonicPing)
+	handle("getLicense", s.handleSubsonicLicense)
+	handle("getMusicFolders", s.handleSubsonicMusicFolders)
+	handle("getIndexes", s.handleSubsonicIndexes)
+	handle("getArtists", s.handleSubsonicArtists)
+	handle("getArtist", s.handleSubsonicArtist)
+	handle("getAlbum", s.handleSubsonicAlbum)
+	handle("getSong", s.handleSubsonicSong)
+	handle("search3", s.handleSubsonicSearch3)
+	handle("stream", s.handleSubsonicStream)
+	handle("download", s.handleSubsonicStream)
+	handle("getCoverArt", s.handleSubsonicCoverArt)
+	handle("star", s.handleSubsonicStar)
+	handle("unstar", s.handleSubsonicUnstar)
+	handle("getStarred2", s.handleSubsonicStarred2)
+	handle("scrobble", s.handleSubsonicScrobble)
+	handle("getLyrics", s.handleSubsonicLyrics)
+	handle("getLyricsBySongId", s.handleSubsonicLyricsBySongID)
+}
+
+func newSubsonicResponse() *subsonicResponse {
+	return &subsonicResponse{
+		Xmlns:         subsonicXMLNS,
+		Status:        "ok",
+		Version:       subsonicAPIVersion,
+		Type:          "mediahub",
+		ServerVersion: "1.0",
+		OpenSubsonic:  true,
+	}
+}
+
+// writeSubsonic encodes the response as XML (default), JSON when f=json, or
+// JSON passed to the function named by callback when f=jsonp. Subsonic always
+// answers 200, errors are reported in the body.
+func writeSubsonic(w http.ResponseWriter, r *http.Request, resp *subsonicResponse) {
+	switch r.FormValue("f") {
+	case "json":
+		writeJSON(w, 200, map[string]any{"subsonic-response": resp})
+		return
+	case "jsonp":
+		callback := r.FormValue("callback")
+		if !validJSONPCallback(callback) {
+			resp = newSubsonicResponse()
+			resp.Status = "failed"
+			resp.Error = &subsonicError{Code: subsonicErrMissingParam, Message: "f=jsonp needs a callback function name"}
+			writeJSON(w, 200, map[string]any{"subsonic-response": resp})
+			return
+		}
+		body, err := json.Marshal(map[string]any{"subsonic-response": resp})
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(200)
+		_, _ = fmt.Fprintf(w, "/**/%s(%s);", callback, body)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(200)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(resp)
+}
+
+// validJSONPCallback accepts a JavaScript function name, optionally dotted
+// (jQuery.cb_1), and nothing that could smuggle other code into the response
+func validJSONPCallback(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '.' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func writeSubsonicError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	resp := newSubsonicResponse()
+	resp.Status = "failed"
+	resp.Error = &subsonicError{Code: code, Message: message}
+	writeSubsonic(w, r, resp)
+}
+
+// parseSubsonicID parses "<prefix>-<n>" ids; an empty prefix means a bare number
+func parseSubsonicID(v, prefix string) int64 {
+	if prefix != "" {
+		if !strings.HasPrefix(v, prefix+"-") {
+			return 0
+		}
+		v = strings.TrimPrefix(v, prefix+"-")
+	}
+	id, _ := strconv.ParseInt(v, 10, 64)
+	return id
+}
+
+func formIntDefault(r *http.Request, key string, def int) int {
+	if n, err := strconv.Atoi(r.FormValue(key)); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
+
+// indexName returns the index letter for an artist sort name ("#" for non-letters)
+func indexName(sortName string) string {
+	for _, c := range sortName {
+		if unicode.IsLetter(c) {
+			return strings.ToUpper(string(c))
+		}
+		break
+	}
+	return "#"
+}
+
+const subsonicSongSelect = `
+	select t.item_id, t.album_id, al.title, t.artist_id, ar.name, t.title,
+	       coalesce(t.track_no, 0), coalesce(t.disc_no, 0), coalesce(t.year, 0), coalesce(t.genre, ''),
+	       coalesce(t.duration_ms, 0), mi.size_bytes, mi.rel_path, mi.created_at,
+	       mi.thumb_path is not null, uf.created_at,
+	       t.gain_db, t.peak, al.gain_db, al.peak
+	from track t
+	join media_item mi on mi.id = t.item_id
+	join album al on al.id = t.album_id
+	join artist ar on ar.id = t.artist_id
+	left join user_favorite uf on uf.item_id = t.item_id and uf.user_id = $1`
+
+func scanSubsonicSongs(rows pgx.Rows) ([]subsonicSong, error) {
+	defer rows.Close()
+	out := []subsonicSong{}
+	for rows.Next() {
+		var (
+			itemID, albumID, artistID int64
+			durationMs                int
+			created                   time.Time
+			hasThumb                  bool
+			starred                   *time.Time
+			rg                        subsonicReplayGain
+			song                      subsonicSong
+		)
+		if err := rows.Scan(&itemID, &albumID, &song.Album, &artistID, &song.Artist, &song.Title,
+			&song.Track, &song.DiscNumber, &song.Year, &song.Genre,
+			&durationMs, &song.Size, &song.Path, &created, &hasThumb, &starred,
+			&rg.TrackGain, &rg.TrackPeak, &rg.AlbumGain, &rg.AlbumPeak); err != nil {
+			return nil, err
+		}
+		if rg.TrackGain != nil || rg.AlbumGain != nil {
+			song.ReplayGain = &rg
+		}
+		song.ID = strconv.FormatInt(itemID, 10)
+		song.Parent = fmt.Sprintf("al-%d", albumID)
+		song.AlbumID = song.Parent
+		song.ArtistID = fmt.Sprintf("ar-%d", artistID)
+		song.Duration = durationMs / 1000
+		song.Created = created.UTC().Format(time.RFC3339)
+		song.Suffix = strings.TrimPrefix(strings.ToLower(filepath.Ext(song.Path)), ".")
+		song.ContentType = media.ContentType("audio", song.Path, "")
+		song.Type = "music"
+		if hasThumb {
+			song.CoverArt = song.ID
+		}
+		if starred != nil {
+			song.Starred = starred.UTC().Format(time.RFC3339)
+		}
+		out = append(out, song)
+	}
+	return out, rows.Err()
+}
+
+const subsonicAlbumSelect = `
+	select al.id, al.title, ar.id, ar.name, coalesce(al.year, 0), coalesce(al.genre, ''),
+	       al.cover_path is not null, count(t.item_id), coalesce(sum(t.duration_ms), 0), min(mi.created_at)
+	from album al
+	join artist ar on ar.id = al.artist_id
+	join track t on t.album_id = al.id
+	join media_item mi on mi.id = t.item_id`
+
+func scanSubsonicAlbums(rows pgx.Rows) ([]subsonicAlbum, error) {
+	defer rows.Close()
+	out := []subsonicAlbum{}
+	for rows.Next() {
+		var (
+			albumID, artistID int64
+			hasCover          bool
+			durationMs        int64
+			created           time.Time
+			a                 subsonicAlbum
+		)
+		if err := rows.Scan(&albumID, &a.Name, &artistID, &a.Artist, &a.Year, &a.Genre,
+			&hasCover, &a.SongCount, &durationMs, &created); err != nil {
+			return nil, err
+		}
+		a.ID = fmt.Sprintf("al-%d", albumID)
+		a.ArtistID = fmt.Sprintf("ar-%d", artistID)
+		a.Duration = int(durationMs / 1000)
+		a.Created = created.UTC().Format(time.RFC3339)
+		if hasCover {
+			a.CoverArt = a.ID
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handleSubsonicPing(w http.ResponseWriter, r *http.Request) {
+	writeSubsonic(w, r, newSubsonicResponse())
+}
+
+func (s *Server) handleSubsonicLicense(w http.ResponseWriter, r *http.Request) {
+	resp := newSubsonicResponse()
+	resp.License = &subsonicLicense{Valid: true}
+	writeSubsonic(w, r, resp)
+}
+
+func (s *Server) handleSubsonicMusicFolders(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query(r.Context(), "select id, name from library where "+inScope("id", 1)+" order by id asc",
+		s.libraryScope(r.Context()))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	folders := &subsonicMusicFolders{MusicFolder: []subsonicMusicFolder{}}
+	for rows.Next() {
+		var f subsonicMusicFolder
+		if err := rows.Scan(&f.ID, &f.Name); err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+		folders.MusicFolder = append(folders.MusicFolder, f)
+	}
+	resp := newSubsonicResponse()
+	resp.MusicFolders = folders
+	writeSubsonic(w, r, resp)
+}
+
+// loadArtistIndexes groups album artists by first letter of their sort name
+func (s *Server) loadArtistIndexes(r *http.Request) (*subsonicIndexes, error) {
+	args := []any{s.libraryScope(r.Context())}
+	where := "mi.present = true and " + inScope("mi.library_id", 1)
+	if folderID := parseSubsonicID(r.FormValue("musicFolderId"), ""); folderID > 0 {
+		args = append(args, folderID)
+		where += " and mi.library_id = $2"
+	}
+
+	rows, err := s.DB.Query(r.Context(), `
+		select ar.id, ar.name, ar.sort_name, count(distinct al.id),
+		       (array_agg(al.id order by al.year nulls last) filter (where al.cover_path is not null))[1],
+		       max(mi.updated_at)
+		from artist ar
+		join album al on al.artist_id = ar.id
+		join track t on t.album_id = al.id
+		join media_item mi on mi.id = t.item_id
+		where `+where+`
+		group by ar.id, ar.name, ar.sort_name
+		order by ar.sort_name asc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := &subsonicIndexes{IgnoredArticles: ignoredArticles, Index: []subsonicIndex{}}
+	for rows.Next() {
+		var (
+			id           int64
+			name, sort   string
+			albumCount   int
+			coverAlbumID *int64
+			modified     time.Time
+		)
+		if err := rows.Scan(&id, &name, &sort, &albumCount, &coverAlbumID, &modified); err != nil {
+			return nil, err
+		}
+		a := subsonicArtist{ID: fmt.Sprintf("ar-%d", id), Name: name, AlbumCount: albumCount}
+		if coverAlbumID != nil {
+			a.CoverArt = fmt.Sprintf("al-%d", *coverAlbumID)
+		}
+		if ms := modified.UnixMilli(); ms > out.LastModified {
+			out.LastModified = ms
+		}
+
+		letter := indexName(sort)
+		if n := len(out.Index); n == 0 || out.Index[n-1].Name != letter {
+			out.Index = append(out.Index, subsonicIndex{Name: letter})
+		}
+		last := &out.Index[len(out.Index)-1]
+		last.Artist = append(last.Artist, a)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handleSubsonicIndexes(w http.ResponseWriter, r *http.Request) {
+	idx, err := s.loadArtistIndexes(r)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	resp := newSubsonicResponse()
+	resp.Indexes = idx
+	writeSubsonic(w, r, resp)
+}
+
+func (s *Server) handleSubsonicArtists(w http.ResponseWriter, r *http.Request) {
+	idx, err := s.loadArtistIndexes(r)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	idx.LastModified = 0
+	resp := newSubsonicResponse()
+	resp.Artists = idx
+	writeSubsonic(w, r, resp)
+}
+
+func (s *Server) handleSubsonicArtist(w http.ResponseWriter, r *http.Request) {
+	id := parseSubsonicID(r.FormValue("id"), "ar")
+	if id <= 0 {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "id required")
+		return
+	}
+
+	var artist subsonicArtist
+	if err := s.DB.QueryRow(r.Context(), "select name from artist where id = $1", id).Scan(&artist.Name); err != nil {
+		writeSubsonicError(w, r, subsonicErrNotFound, "artist not found")
+		return
+	}
+	artist.ID = fmt.Sprintf("ar-%d", id)
+
+	rows, err := s.DB.Query(r.Context(), subsonicAlbumSelect+`
+		where al.artist_id = $1 and mi.present = true and `+inScope("mi.library_id", 2)+`
+		group by al.id, ar.id
+		order by al.year asc nulls last, al.title asc`, id, s.libraryScope(r.Context()))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	albums, err := scanSubsonicAlbums(rows)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	if len(albums) == 0 {
+		writeSubsonicError(w, r, subsonicErrNotFound, "artist not found")
+		return
+	}
+	artist.Album = albums
+	artist.AlbumCount = len(albums)
+	for _, a := range albums {
+		if a.CoverArt != "" {
+			artist.CoverArt = a.CoverArt
+			break
+		}
+	}
+
+	resp := newSubsonicResponse()
+	resp.Artist = &artist
+	writeSubsonic(w, r, resp)
+}
+
+func (s *Server) handleSubsonicAlbum(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	scope := s.libraryScope(r.Context())
+	id := parseSubsonicID(r.FormValue("id"), "al")
+	if id <= 0 {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "id required")
+		return
+	}
+
+	rows, err := s.DB.Query(r.Context(), subsonicAlbumSelect+`
+		where al.id = $1 and mi.present = true and `+inScope("mi.library_id", 2)+`
+		group by al.id, ar.id`, id, scope)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	albums, err := scanSubsonicAlbums(rows)
+	if err != nil || len(albums) == 0 {
+		writeSubsonicError(w, r, subsonicErrNotFound, "album not found")
+		return
+	}
+	album := albums[0]
+
+	rows, err = s.DB.Query(r.Context(), subsonicSongSelect+`
+		where t.album_id = $2 and mi.present = true and `+inScope("mi.library_id", 3)+`
+		order by t.disc_no asc nulls first, t.track_no asc nulls last, t.title asc`, uid, id, scope)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	album.Song, err = scanSubsonicSongs(rows)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+
+	resp := newSubsonicResponse()
+	resp.Album = &album
+	writeSubsonic(w, r, resp)
+}
+
+func (s *Server) handleSubsonicSong(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	id := parseSubsonicID(r.FormValue("id"), "")
+	if id <= 0 {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "id required")
+		return
+	}
+	rows, err := s.DB.Query(r.Context(), subsonicSongSelect+" where t.item_id = $2 and mi.present = true and "+inScope("mi.library_id", 3),
+		uid, id, s.libraryScope(r.Context()))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	songs, err := scanSubsonicSongs(rows)
+	if err != nil || len(songs) == 0 {
+		writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+		return
+	}
+	resp := newSubsonicResponse()
+	resp.Song = &songs[0]
+	writeSubsonic(w, r, resp)
+}
+
+// handleSubsonicSearch3 searches artists, albums and songs. An empty query
+// matches everything, which clients such as Symfonium use to sync the library.
+func (s *Server) handleSubsonicSearch3(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	q := strings.Trim(strings.TrimSpace(r.FormValue("query")), `"`)
+	pattern := "%" + strings.ReplaceAll(q, "*", "%") + "%"
+	folderID := parseSubsonicID(r.FormValue("musicFolderId"), "")
+	scope := s.libraryScope(r.Context())
+
+	artistCount := formIntDefault(r, "artistCount", 20)
+	artistOffset := formIntDefault(r, "artistOffset", 0)
+	albumCount := formIntDefault(r, "albumCount", 20)
+	albumOffset := formIntDefault(r, "albumOffset", 0)
+	songCount := formIntDefault(r, "songCount", 20)
+	songOffset := formIntDefault(r, "songOffset", 0)
+
+	result := &subsonicSearchResult3{
+		Artist: []subsonicArtist{},
+		Album:  []subsonicAlbum{},
+		Song:   []subsonicSong{},
+	}
+
+	if artistCount > 0 {
+		rows, err := s.DB.Query(r.Context(), `
+			select ar.id, ar.name, count(distinct al.id)
+			from artist ar
+			join album al on al.artist_id = ar.id
+			join track t on t.album_id = al.id
+			join media_item mi on mi.id = t.item_id
+			where mi.present = true and ar.name ilike $1 and ($2 = 0 or mi.library_id = $2) and `+inScope("mi.library_id", 5)+`
+			group by ar.id, ar.name, ar.sort_name
+			order by ar.sort_name asc
+			limit $3 offset $4`, pattern, folderID, artistCount, artistOffset, scope)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+		for rows.Next() {
+			var id int64
+			var a subsonicArtist
+			if err := rows.Scan(&id, &a.Name, &a.AlbumCount); err != nil {
+				continue
+			}
+			a.ID = fmt.Sprintf("ar-%d", id)
+			result.Artist = append(result.Artist, a)
+		}
+		rows.Close()
+	}
+
+	if albumCount > 0 {
+		rows, err := s.DB.Query(r.Context(), subsonicAlbumSelect+`
+			where mi.present = true and (al.title ilike $1 or ar.name ilike $1) and ($2 = 0 or mi.library_id = $2) and `+inScope("mi.library_id", 5)+`
+			group by al.id, ar.id
+			order by ar.sort_name asc, al.title asc
+			limit $3 offset $4`, pattern, folderID, albumCount, albumOffset, scope)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+		if result.Album, err = scanSubsonicAlbums(rows); err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+	}
+
+	if songCount > 0 {
+		rows, err := s.DB.Query(r.Context(), subsonicSongSelect+`
+			where mi.present = true and (t.title ilike $2 or ar.name ilike $2 or al.title ilike $2) and ($3 = 0 or mi.library_id = $3) and `+inScope("mi.library_id", 6)+`
+			order by ar.sort_name asc, al.title asc, t.disc_no asc nulls first, t.track_no asc nulls last
+			limit $4 offset $5`, uid, pattern, folderID, songCount, songOffset, scope)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+		if result.Song, err = scanSubsonicSongs(rows); err != nil {
+			writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+			return
+		}
+	}
+
+	resp := newSubsonicResponse()
+	resp.SearchResult3 = result
+	writeSubsonic(w, r, resp)
+}
+
+func (s *Server) handleSubsonicStream(w http.ResponseWriter, r *http.Request) {
+	id := parseSubsonicID(r.FormValue("id"), "")
+	if id <= 0 {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "id required")
+		return
+	}
+	if !s.canAccessItem(r.Context(), id) {
+		writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+		return
+	}
+	if profile, ok := s.subsonicStreamProfile(r, id); ok {
+		s.handleAudioTranscode(w, r, id, profile)
+		return
+	}
+	s.Streamer.StreamByID(w, r, id)
+}
+
+// subsonicStreamProfile picks the transcoding of a stream call: the requested
+// format (capped by maxBitRate), else the user's default profile, else mp3
+// when maxBitRate is below the source bitrate. format=raw always streams the file.
+func (s *Server) subsonicStreamProfile(r *http.Request, id int64) (transcode.AudioProfile, bool) {
+	format := strings.ToLower(r.FormValue("format"))
+	maxKbps, _ := strconv.Atoi(r.FormValue("maxBitRate"))
+	if format == "raw" {
+		return transcode.AudioProfile{}, false
+	}
+
+	var kind string
+	var bitRate int64
+	err := s.DB.QueryRow(r.Context(), "select kind, coalesce(bit_rate, 0) from media_item where id=$1", id).Scan(&kind, &bitRate)
+	if err != nil || kind != "audio" {
+		return transcode.AudioProfile{}, false
+	}
+
+	if format != "" {
+		p, err := transcode.ParseAudioProfile(format, maxKbps)
+		return p, err == nil
+	}
+	uid, _ := UserIDFromContext(r.Context())
+	p, ok := s.userAudioProfile(r.Context(), uid)
+	if !ok {
+		if maxKbps <= 0 {
+			return transcode.AudioProfile{}, false
+		}
+		p, _ = transcode.ParseAudioProfile("mp3", maxKbps)
+	}
+	if maxKbps > 0 && maxKbps < p.Kbps {
+		p.Kbps = maxKbps
+	}
+	// Already lighter than the target: no point re-encoding
+	if bitRate > 0 && bitRate <= int64(p.Kbps)*1000 {
+		return transcode.AudioProfile{}, false
+	}
+	return p, true
+}
+
+// handleSubsonicCoverArt serves album art ("al-<id>"), an artist's first album
+// art ("ar-<id>") or an item thumbnail (bare id)
+func (s *Server) handleSubsonicCoverArt(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	scope := s.libraryScope(r.Context())
+	// an album is visible when one of its tracks is
+	visible := `exists (select 1 from track t join media_item mi on mi.id = t.item_id
+		where t.album_id = album.id and ` + inScope("mi.library_id", 2) + `)`
+	var path string
+	var err error
+	switch {
+	case strings.HasPrefix(id, "al-"):
+		err = s.DB.QueryRow(r.Context(), "select coalesce(cover_path, '') from album where id = $1 and "+visible,
+			parseSubsonicID(id, "al"), scope).Scan(&path)
+	case strings.HasPrefix(id, "ar-"):
+		err = s.DB.QueryRow(r.Context(), `
+			select coalesce(cover_path, '') from album
+			where artist_id = $1 and cover_path is not null and `+visible+`
+			order by year asc nulls last limit 1`, parseSubsonicID(id, "ar"), scope).Scan(&path)
+	default:
+		err = s.DB.QueryRow(r.Context(), "select coalesce(thumb_path, '') from media_item where id = $1 and "+inScope("library_id", 2),
+			parseSubsonicID(id, ""), scope).Scan(&path)
+	}
+	if err != nil || path == "" {
+		writeSubsonicError(w, r, subsonicErrNotFound, "cover art not found")
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// subsonicSongIDs collects the song ids of a star/unstar/scrobble call.
+// Album and artist stars (albumId/artistId) have no backing table and are ignored.
+func subsonicSongIDs(r *http.Request) []int64 {
+	_ = r.ParseForm()
+	var ids []int64
+	for _, v := range r.Form["id"] {
+		if id := parseSubsonicID(v, ""); id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *Server) handleSubsonicStar(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	for _, id := range subsonicSongIDs(r) {
+		if !s.canAccessItem(r.Context(), id) {
+			writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+			return
+		}
+		_, err := s.DB.Exec(r.Context(), "insert into user_favorite(user_id,item_id) values ($1,$2) on conflict do nothing", uid, id)
+		if err != nil {
+			writeSubsonicError(w, r, subsonicErrNotFound, "song not found")
+			return
+		}
+	}
+	writeSubsonic(w, r, newSubsonicResponse())
+}
+
+func (s *Server) handleSubsonicUnstar(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	for _, id := range subsonicSongIDs(r) {
+		_, _ = s.DB.Exec(r.Context(), "delete from user_favorite where user_id=$1 and item_id=$2", uid, id)
+	}
+	writeSubsonic(w, r, newSubsonicResponse())
+}
+
+func (s *Server) handleSubsonicStarred2(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	rows, err := s.DB.Query(r.Context(), subsonicSongSelect+`
+		where uf.user_id is not null and mi.present = true and `+inScope("mi.library_id", 2)+`
+		order by uf.created_at desc`, uid, s.libraryScope(r.Context()))
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	songs, err := scanSubsonicSongs(rows)
+	if err != nil {
+		writeSubsonicError(w, r, subsonicErrGeneric, err.Error())
+		return
+	}
+	resp := newSubsonicResponse()
+	resp.Starred2 = &subsonicStarred2{Song: songs}
+	writeSubsonic(w, r, resp)
+}
+
+// handleSubsonicScrobble records plays in user_playback. "Now playing"
+// notifications (submission=false) are accepted but not stored.
+func (s *Server) handleSubsonicScrobble(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	if r.FormValue("submission") == "false" {
+		writeSubsonic(w, r, newSubsonicResponse())
+		return
+	}
+
+	ids := subsonicSongIDs(r)
+	times := r.Form["time"]
+	for i, id := range ids {
+		if !s.canAccessItem(r.Context(), id) {
+			continue
+		}
+		playedAt := time.Now().UTC()
+		if i < len(times) {
+			if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil && ms > 0 {
+				playedAt = time.UnixMilli(ms).UTC()
+			}
+		}
+		_, _ = s.DB.Exec(r.Context(), `
+			INSERT INTO user_playback (user_id, item_id, position_ms, last_played_at)
+			VALUES ($1, $2, 0, $3)
+			ON CONFLICT (user_id, item_id) DO UPDATE SET last_played_at = GREATEST(user_playback.last_played_at, EXCLUDED.last_played_at)`,
+			uid, id, playedAt)
+	}
+	writeSubsonic(w, r, newSubsonicResponse())
+}
+
+// handleSubsonicLyrics finds lyrics by artist and title (classic getLyrics)
+func (s *Server) handleSubsonicLyrics(w http.ResponseWriter, r *http.Request) {
+	artist := strings.TrimSpace(r.FormValue("artist"))
+	title := strings.TrimSpace(r.FormValue("title"))
+
+	resp := newSubsonicResponse()
+	resp.Lyrics = &subsonicLyrics{}
+
+	var itemID int64
+	err := s.DB.QueryRow(r.Context(), `
+		select t.item_id, ar.name, t.title
+		from track t
+		join artist ar on ar.id = t.artist_id
+		join media_item mi on mi.id = t.item_id
+		where mi.present = true
+		  and ($1 = '' or ar.name ilike $1)
+		  and t.title ilike $2
+		  and exists (select 1 from lyrics l where l.item_id = t.item_id)
+		  and `+inScope("mi.library_id", 3)+`
+		limit 1`, artist, title, s.libraryScope(r.Context())).Scan(&itemID, &resp.Lyrics.Artist, &resp.Lyrics.Title)
+	if err == nil {
+		if l, err := s.loadLyrics(r.Context(), itemID); err == nil {
+			resp.Lyrics.Value = l.Text
+		}
+	}
+	// No match is an empty <lyrics/> element, not an error
+	writeSubsonic(w, r, resp)
+}
+
+// handleSubsonicLyricsBySongID returns structured (timed when available) lyrics
+func (s *Server) handleSubsonicLyricsBySongID(w http.ResponseWriter, r *http.Request) {
+	id := parseSubsonicID(r.FormValue("id"), "")
+	if id <= 0 {
+		writeSubsonicError(w, r, subsonicErrMissingParam, "id required")
+		return
+	}
+
+	resp := newSubsonicResponse()
+	resp.LyricsList = &subsonicLyricsList{StructuredLyrics: []subsonicStructuredLyrics{}}
+
+	if !s.canAccessItem(r.Context(), id) {
+		writeSubsonic(w, r, resp)
+		return
+	}
+	l, err := s.loadLyrics(r.Context(), id)
+	if err == nil {
+		sl := subsonicStructuredLyrics{Lang: l.Lang, Synced: l.Synced, Line: []subsonicLyricsLine{}}
+		if sl.Lang == "" {
+			sl.Lang = "xxx"
+		}
+		_ = s.DB.QueryRow(r.Context(), `
+			select ar.name, t.title from track t join artist ar on ar.id = t.artist_id where t.item_id = $1`,
+			id).Scan(&sl.DisplayArtist, &sl.DisplayTitle)
+		if l.Synced {
+			for _, line := range l.Lines {
+				start := line.StartMs
+				sl.Line = append(sl.Line, subsonicLyricsLine{Start: &start, Value: line.Text})
+			}
+		} else {
+			for _, text := range strings.Split(l.Text, "\n") {
+				sl.Line = append(sl.Line, subsonicLyricsLine{Value: text})
+			}
+		}
+		resp.LyricsList.StructuredLyrics = append(resp.LyricsList.StructuredLyrics, sl)
+	}
+	writeSubsonic(w, r, resp)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Subsonic token auth sends md5(password + salt), so the server needs the
+// plaintext password. It is kept AES-GCM encrypted (key derived from the JWT
+// secret) in app_user.subsonic_password and captured whenever the user
+// presents it: login, account creation and password change.
+
+func (s *Server) secretKey() []byte {
+	k := sha256.Sum256([]byte("subsonic:" + s.JWTSecret))
+	return k[:]
+}
+
+func (s *Server) encryptSecret(plain string) (string, error) {
+	block, err := aes.NewCipher(s.secretKey())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Server) decryptSecret(enc string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(s.secretKey())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("secret too short")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// storeSubsonicPassword records the password for Subsonic token auth (best-effort)
+func (s *Server) storeSubsonicPassword(ctx context.Context, userID int64, password string) {
+	enc, err := s.encryptSecret(password)
+	if err != nil {
+		return
+	}
+	_, _ = s.DB.Exec(ctx, "UPDATE app_user SET subsonic_password = $2 WHERE id = $1", userID, enc)
+}
+
+// subsonicAuth authenticates /rest/* requests with the Subsonic u+t+s
+// (token/salt) or u+p (legacy, optionally "enc:"-hex) parameters. p may also
+// be an API key of the user, which is the only way in for users with
+// two-factor authentication (or admins required to have it): a password alone
+// must not open their account.
+func (s *Server) subsonicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.FormValue("u")
+		token := strings.ToLower(r.FormValue("t"))
+		salt := r.FormValue("s")
+		password := r.FormValue("p")
+		if username == "" || (password == "" && (token == "" || salt == "")) {
+			writeSubsonicError(w, r, subsonicErrMissingParam, "required parameter is missing")
+			return
+		}
+		if strings.HasPrefix(password, "enc:") {
+			if b, err := hex.DecodeString(strings.TrimPrefix(password, "enc:")); err == nil {
+				password = string(b)
+			}
+		}
+
+		ip := clientIP(r)
+		attempt, wait := s.Logins.Begin(ip, username)
+		if wait > 0 {
+			writeSubsonicError(w, r, subsonicErrWrongCredentials, "too many failed logins, try again later")
+			return
+		}
+		defer attempt.End()
+
+		var userID int64
+		var hash, encSecret, role string
+		var twoFactor bool
+		err := s.DB.QueryRow(r.Context(),
+			"SELECT id, password_hash, coalesce(subsonic_password, ''), role, totp_enabled FROM app_user WHERE username = $1",
+			username,
+		).Scan(&userID, &hash, &encSecret, &role, &twoFactor)
+		if err != nil {
+			attempt.Fail()
+			writeSubsonicError(w, r, subsonicErrWrongCredentials, "wrong username or password")
+			return
+		}
+		passwordAllowed := !twoFactor && !(role == RoleAdmin && s.requireAdmin2FA(r.Context()))
+
+		ok := false
+		switch {
+		case strings.HasPrefix(password, apiKeyPrefix):
+			owner, _, valid := s.authenticateAPIKey(r.Context(), password)
+			ok = valid && owner == userID
+		case !passwordAllowed:
+			writeSubsonicError(w, r, subsonicErrWrongCredentials, "two-factor authentication is on: use an API key as the password")
+			return
+		case password != "":
+			ok = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+			if ok && encSecret == "" {
+				s.storeSubsonicPassword(r.Context(), userID, password)
+			}
+		case encSecret != "":
+			if plain, err := s.decryptSecret(encSecret); err == nil {
+				sum := md5.Sum([]byte(plain + salt))
+				expected := hex.EncodeToString(sum[:])
+				ok = subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+			}
+		}
+		if !ok {
+			attempt.Fail()
+			writeSubsonicError(w, r, subsonicErrWrongCredentials, "wrong username or password")
+			return
+		}
+		attempt.Succeed()
+		if msg := s.pendingAccountSetup(r.Context(), userID); msg != "" {
+			writeSubsonicError(w, r, subsonicErrWrongCredentials, msg+", log in to the web interface")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
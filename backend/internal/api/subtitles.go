@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/example/mediahub/internal/media"
+)
+
+// Subtitles of a video: sidecar files linked by the scanner and embedded text
+// tracks found by the metadata worker. Everything is delivered as WebVTT so
+// the HTML5 <track> element can use it; {n} is the position in the list.
+
+const maxSubtitleFileSize = 5 << 20
+
+// Sidecars first (by file name), then embedded tracks in stream order
+const subtitleOrder = "order by s.source = 'embedded', s.path, s.stream_index"
+
+// handleItemSubtitles lists the subtitle tracks of an item
+func (s *Server) handleItemSubtitles(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+
+	rows, err := s.DB.Query(r.Context(), `
+		select s.source, s.format, s.lang, s.title, s.forced, s.sdh, s.is_default
+		from subtitle s
+		where s.item_id = $1 `+subtitleOrder, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []Subtitle{}
+	for rows.Next() {
+		sub := Subtitle{Index: len(out)}
+		if err := rows.Scan(&sub.Source, &sub.Format, &sub.Lang, &sub.Title, &sub.Forced, &sub.SDH, &sub.Default); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		sub.URL = s.mediaURL(r.Context(), id, fmt.Sprintf("subtitles/%d.vtt", sub.Index))
+		out = append(out, sub)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleSubtitleVTT converts one subtitle track to WebVTT
+func (s *Server) handleSubtitleVTT(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if id <= 0 || err != nil || n < 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+
+	var source, format, itemPath string
+	var subPath *string
+	var streamIndex *int
+	err = s.DB.QueryRow(r.Context(), `
+		select s.source, s.format, s.path, s.stream_index, mi.path
+		from subtitle s
+		join media_item mi on mi.id = s.item_id
+		where s.item_id = $1 and mi.present = true `+subtitleOrder+`
+		offset $2 limit 1`, id, n,
+	).Scan(&source, &format, &subPath, &streamIndex, &itemPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var vtt []byte
+	switch {
+	case source == "sidecar" && subPath != nil:
+		vtt, err = s.sidecarToVTT(r.Context(), *subPath, format)
+	case source == "embedded" && streamIndex != nil:
+		vtt, err = s.embeddedToVTT(r.Context(), id, itemPath, *streamIndex)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("subtitle %d/%d: %v", id, n, err)
+		http.Error(w, "subtitle conversion failed", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	_, _ = w.Write(vtt)
+}
+
+// sidecarToVTT converts SRT and VTT in-process; ASS/SSA go through ffmpeg
+func (s *Server) sidecarToVTT(ctx context.Context, path, format string) ([]byte, error) {
+	switch format {
+	case "srt", "vtt":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		b, err := io.ReadAll(io.LimitReader(f, maxSubtitleFileSize))
+		if err != nil {
+			return nil, err
+		}
+		text := media.DecodeLyricsFile(b) // same BOM/UTF-16/Latin-1 handling as lyrics sidecars
+		if format == "vtt" {
+			return []byte(media.NormalizeVTT(text)), nil
+		}
+		return []byte(media.SRTToVTT(text)), nil
+	default:
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		convCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if err := media.ConvertToVTT(convCtx, path, -1, &buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// embeddedToVTT extracts an embedded track. Extraction reads the whole
+// container, so the result is cached under THUMB_DIR/subtitles until the
+// video changes.
+func (s *Server) embeddedToVTT(ctx context.Context, itemID int64, videoPath string, streamIndex int) ([]byte, error) {
+	src, err := os.Stat(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	cacheDir := filepath.Join(s.Cfg.ThumbDir, "subtitles")
+	cached := filepath.Join(cacheDir, fmt.Sprintf("%d-%d.vtt", itemID, streamIndex))
+	if fi, err := os.Stat(cached); err == nil && !fi.ModTime().Before(src.ModTime()) {
+		return os.ReadFile(cached)
+	}
+
+	var buf bytes.Buffer
+	convCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	if err := media.ConvertToVTT(convCtx, videoPath, streamIndex, &buf); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err == nil {
+		if err := os.WriteFile(cached, buf.Bytes(), 0644); err != nil {
+			log.Printf("subtitle cache: %v", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
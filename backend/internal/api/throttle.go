@@ -0,0 +1,174 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/example/mediahub/internal/config"
+)
+
+// LoginThrottle counts failed password logins per client IP and per
+// username. Once either reaches its limit, attempts are refused (even with
+// the right password) until the lockout has passed since the last failure.
+// Attempts still being checked count against the limits too, so a burst of
+// parallel guesses can't slip past them. Counts live in memory: a restart
+// forgets them.
+type LoginThrottle struct {
+	maxUser int
+	maxIP   int
+	lockout time.Duration
+
+	mu        sync.Mutex
+	failures  map[string]*loginFailures // "ip:<addr>" or "user:<name>"
+	lastPrune time.Time
+}
+
+type loginFailures struct {
+	count    int
+	last     time.Time
+	inflight int // attempts begun and not ended yet
+}
+
+func NewLoginThrottle(cfg config.Config) *LoginThrottle {
+	return &LoginThrottle{
+		maxUser:  cfg.LoginMaxFailures,
+		maxIP:    cfg.LoginMaxFailuresIP,
+		lockout:  cfg.LoginLockout,
+		failures: map[string]*loginFailures{},
+	}
+}
+
+// LoginAttempt is a login being checked. It ends with Fail or Succeed, or
+// with End (deferred by callers) when it has no verdict, e.g. on a server
+// error or while the second factor is pending.
+type LoginAttempt struct {
+	t     *LoginThrottle
+	keys  []string
+	ended bool
+}
+
+// Begin reserves an attempt to log in from ip as username. It returns how
+// long logins from ip or for username remain locked instead, and a nil
+// attempt (whose methods do nothing).
+func (t *LoginThrottle) Begin(ip, username string) (*LoginAttempt, time.Duration) {
+	if t == nil {
+		return nil, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.prune(now)
+	ipKey, userKey := "ip:"+ip, "user:"+username
+	if wait := max(t.wait(now, ipKey, t.maxIP), t.wait(now, userKey, t.maxUser)); wait > 0 {
+		return nil, wait
+	}
+	for _, key := range []string{ipKey, userKey} {
+		t.entry(key).inflight++
+	}
+	return &LoginAttempt{t: t, keys: []string{ipKey, userKey}}, 0
+}
+
+// wait is the remaining lockout of a key. Failures older than the lockout
+// are forgotten; when attempts in flight would reach the limit, the caller
+// retries once they are done.
+func (t *LoginThrottle) wait(now time.Time, key string, limit int) time.Duration {
+	f := t.failures[key]
+	if limit <= 0 || f == nil {
+		return 0
+	}
+	count := f.count
+	if now.Sub(f.last) > t.lockout {
+		count = 0
+	}
+	if count >= limit {
+		return max(0, f.last.Add(t.lockout).Sub(now))
+	}
+	if count+f.inflight >= limit {
+		return time.Second
+	}
+	return 0
+}
+
+func (t *LoginThrottle) entry(key string) *loginFailures {
+	f := t.failures[key]
+	if f == nil {
+		f = &loginFailures{}
+		t.failures[key] = f
+	}
+	return f
+}
+
+// endLocked releases the reservation of an attempt, once
+func (a *LoginAttempt) endLocked() bool {
+	if a.ended {
+		return false
+	}
+	a.ended = true
+	for _, key := range a.keys {
+		if f := a.t.failures[key]; f != nil && f.inflight > 0 {
+			f.inflight--
+		}
+	}
+	return true
+}
+
+// Fail records a failed login
+func (a *LoginAttempt) Fail() {
+	if a == nil {
+		return
+	}
+	a.t.mu.Lock()
+	defer a.t.mu.Unlock()
+	if !a.endLocked() {
+		return
+	}
+	now := time.Now()
+	for _, key := range a.keys {
+		f := a.t.entry(key)
+		if now.Sub(f.last) > a.t.lockout {
+			f.count = 0
+		}
+		f.count++
+		f.last = now
+	}
+}
+
+// Succeed clears the failures of the username. Those of the IP stay, so that
+// one valid account doesn't reset guessing at others.
+func (a *LoginAttempt) Succeed() {
+	if a == nil {
+		return
+	}
+	a.t.mu.Lock()
+	defer a.t.mu.Unlock()
+	if !a.endLocked() {
+		return
+	}
+	if f := a.t.failures[a.keys[1]]; f != nil {
+		f.count = 0
+	}
+}
+
+// End releases an attempt that got no verdict; it does nothing after Fail
+// or Succeed
+func (a *LoginAttempt) End() {
+	if a == nil {
+		return
+	}
+	a.t.mu.Lock()
+	defer a.t.mu.Unlock()
+	a.endLocked()
+}
+
+// prune drops failures older than the lockout, at most once a minute
+func (t *LoginThrottle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+	for k, f := range t.failures {
+		if f.inflight == 0 && now.Sub(f.last) > t.lockout {
+			delete(t.failures, k)
+		}
+	}
+}
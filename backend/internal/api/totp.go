@@ -0,0 +1,417 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Two-factor authentication with TOTP (RFC 6238: HMAC-SHA1, 30 s steps, 6
+// digits), as spoken by authenticator apps. A user enrolls (secret and
+// otpauth:// URI), proves it with a first code, and gets single-use recovery
+// codes. From then on POST /api/auth/login answers with a short-lived
+// challenge token instead of a session; POST /api/auth/login/2fa trades it
+// and a code for the session.
+//
+// Single sign-on logins leave the second factor to the identity provider.
+// The Subsonic API, which clients call with a password on every request,
+// can't ask for a code: it only accepts an API key of these users instead.
+
+const (
+	totpPeriod        = 30
+	totpSkew          = 1 // steps accepted on either side of now, for clock drift
+	recoveryCodeCount = 10
+	challengeTTL      = 5 * time.Minute
+
+	settingRequireAdmin2FA = "require_admin_2fa"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+// totpStep returns the time step a code is valid for around now, or -1
+func totpStep(secret []byte, code string, now time.Time) int64 {
+	step := now.Unix() / totpPeriod
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step+d)), []byte(code)) == 1 {
+			return step + d
+		}
+	}
+	return -1
+}
+
+func otpauthURI(account, secret string) string {
+	v := url.Values{"secret": {secret}, "issuer": {"MediaHub"}, "algorithm": {"SHA1"}, "digits": {"6"}, "period": {"30"}}
+	return "otpauth://totp/" + url.PathEscape("MediaHub:"+account) + "?" + v.Encode()
+}
+
+// normalizeCode drops the spaces and dashes people type in codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// totpSecret returns the decrypted secret of a user, enrolled or pending
+func (s *Server) totpSecret(ctx context.Context, uid int64) ([]byte, bool, error) {
+	var enc *string
+	var enabled bool
+	if err := s.DB.QueryRow(ctx, "select totp_secret, totp_enabled from app_user where id=$1", uid).Scan(&enc, &enabled); err != nil {
+		return nil, false, err
+	}
+	if enc == nil {
+		return nil, false, fmt.Errorf("not enrolled")
+	}
+	plain, err := s.decryptSecret(*enc)
+	if err != nil {
+		return nil, false, err
+	}
+	secret, err := totpEncoding.DecodeString(plain)
+	return secret, enabled, err
+}
+
+// checkSecondFactor accepts a current TOTP code (once) or an unused recovery
+// code (consuming it)
+func (s *Server) checkSecondFactor(ctx context.Context, uid int64, code string) bool {
+	code = normalizeCode(code)
+	if len(code) == 6 {
+		secret, _, err := s.totpSecret(ctx, uid)
+		if err != nil {
+			return false
+		}
+		step := totpStep(secret, code, time.Now())
+		if step < 0 {
+			return false
+		}
+		// The same code can't be replayed, nor an older one
+		tag, err := s.DB.Exec(ctx, "update app_user set totp_last_step=$2 where id=$1 and totp_last_step < $2", uid, step)
+		return err == nil && tag.RowsAffected() == 1
+	}
+	tag, err := s.DB.Exec(ctx, `
+		update user_recovery_code set used_at = now()
+		where user_id = $1 and code_hash = $2 and used_at is null`, uid, hashToken(code))
+	return err == nil && tag.RowsAffected() == 1
+}
+
+// newRecoveryCodes replaces the recovery codes of a user
+func (s *Server) newRecoveryCodes(ctx context.Context, uid int64) ([]string, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "delete from user_recovery_code where user_id=$1", uid); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = c[:5] + "-" + c[5:]
+		if _, err := tx.Exec(ctx, "insert into user_recovery_code(user_id, code_hash) values ($1, $2)", uid, hashToken(normalizeCode(c))); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit(ctx)
+}
+
+func (s *Server) requireAdmin2FA(ctx context.Context) bool {
+	var v string
+	_ = s.DB.QueryRow(ctx, "select value from app_setting where key=$1", settingRequireAdmin2FA).Scan(&v)
+	return v == "true"
+}
+
+// Challenge tokens prove the password step of a login. They are signed with
+// their own key so that they can never pass for an access token.
+
+func (s *Server) challengeKey() []byte {
+	k := sha256.Sum256([]byte("2fa-challenge:" + s.JWTSecret))
+	return k[:]
+}
+
+func (s *Server) makeChallenge(uid int64, req LoginRequest) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":    uid,
+		"device": req.Device,
+		"cookie": req.SessionCookie,
+		"exp":    time.Now().Add(challengeTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.challengeKey())
+}
+
+func (s *Server) parseChallenge(tok string) (uid int64, req LoginRequest, err error) {
+	token, err := jwt.Parse(tok, func(*jwt.Token) (any, error) { return s.challengeKey(), nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return 0, req, fmt.Errorf("invalid or expired challenge")
+	}
+	mc := token.Claims.(jwt.MapClaims)
+	sub, ok := mc["sub"].(float64)
+	if !ok {
+		return 0, req, fmt.Errorf("invalid challenge")
+	}
+	req.Device, _ = mc["device"].(string)
+	req.SessionCookie, _ = mc["cookie"].(bool)
+	return int64(sub), req, nil
+}
+
+// handleLoginSecondFactor completes a login started by POST /api/auth/login
+func (s *Server) handleLoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	uid, login, err := s.parseChallenge(req.ChallengeToken)
+	if err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+	var username string
+	var mustChange bool
+	if err := s.DB.QueryRow(r.Context(), "select username, must_change_password from app_user where id=$1", uid).Scan(&username, &mustChange); err != nil {
+		http.Error(w, "invalid challenge", 401)
+		return
+	}
+
+	ip := clientIP(r)
+	attempt, wait := s.Logins.Begin(ip, username)
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+	defer attempt.End()
+	if !s.checkSecondFactor(r.Context(), uid, req.Code) {
+		attempt.Fail()
+		http.Error(w, "invalid code", 401)
+		return
+	}
+	attempt.Succeed()
+	s.finishLogin(w, r, uid, login, mustChange)
+}
+
+func (s *Server) handleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var enabled bool
+	var role string
+	var left int
+	err := s.DB.QueryRow(r.Context(), `
+		select totp_enabled, role,
+		       (select count(*) from user_recovery_code c where c.user_id = u.id and c.used_at is null)
+		from app_user u where id = $1`, uid).Scan(&enabled, &role, &left)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"enabled":             enabled,
+		"recovery_codes_left": left,
+		"required":            role == RoleAdmin && s.requireAdmin2FA(r.Context()),
+	})
+}
+
+// handleTwoFactorEnroll starts (or restarts) enrollment with a new secret
+func (s *Server) handleTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var username string
+	var enabled bool
+	if err := s.DB.QueryRow(r.Context(), "select username, totp_enabled from app_user where id=$1", uid).Scan(&username, &enabled); err != nil {
+		http.Error(w, "user not found", 404)
+		return
+	}
+	if enabled {
+		http.Error(w, "two-factor authentication is already enabled", 409)
+		return
+	}
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		http.Error(w, "random error", 500)
+		return
+	}
+	secret := totpEncoding.EncodeToString(raw)
+	enc, err := s.encryptSecret(secret)
+	if err != nil {
+		http.Error(w, "encryption error", 500)
+		return
+	}
+	if _, err := s.DB.Exec(r.Context(), "update app_user set totp_secret=$2, totp_last_step=0 where id=$1", uid, enc); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, map[string]string{"secret": secret, "otpauth_uri": otpauthURI(username, secret)})
+}
+
+// handleTwoFactorVerify turns 2FA on with a first code, signs the user out
+// everywhere else and returns the recovery codes, which are not shown again
+func (s *Server) handleTwoFactorVerify(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	secret, enabled, err := s.totpSecret(r.Context(), uid)
+	if err != nil {
+		http.Error(w, "enroll first", 400)
+		return
+	}
+	if enabled {
+		http.Error(w, "two-factor authentication is already enabled", 409)
+		return
+	}
+	step := totpStep(secret, normalizeCode(req.Code), time.Now())
+	if step < 0 {
+		http.Error(w, "invalid code", 400)
+		return
+	}
+	// The stored Subsonic password is useless from now on, see subsonicAuth
+	if _, err := s.DB.Exec(r.Context(), "update app_user set totp_enabled=true, totp_last_step=$2, subsonic_password=null where id=$1", uid, step); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	codes, err := s.newRecoveryCodes(r.Context(), uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Sessions opened with the password alone must not outlive it
+	current, _ := SessionIDFromContext(r.Context())
+	if err := s.revokeUserSessions(r.Context(), uid, current); err != nil {
+		log.Printf("revoke sessions of user %d: %v", uid, err)
+	}
+
+	s.audit(r, "user.2fa_enable", auditUser, uid, nil)
+	writeJSON(w, 200, map[string]any{"recovery_codes": codes})
+}
+
+// handleRegenerateRecoveryCodes replaces the recovery codes, given a code
+func (s *Server) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	if !s.checkSecondFactor(r.Context(), uid, req.Code) {
+		http.Error(w, "invalid code", 400)
+		return
+	}
+	codes, err := s.newRecoveryCodes(r.Context(), uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"recovery_codes": codes})
+}
+
+// handleTwoFactorDisable turns 2FA off, given a code
+func (s *Server) handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	uid, _ := UserIDFromContext(r.Context())
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	if role, _ := s.userRole(r.Context(), uid); role == RoleAdmin && s.requireAdmin2FA(r.Context()) {
+		http.Error(w, "two-factor authentication is required for admins", 400)
+		return
+	}
+	if !s.checkSecondFactor(r.Context(), uid, req.Code) {
+		http.Error(w, "invalid code", 400)
+		return
+	}
+	if err := s.clearTwoFactor(r.Context(), uid); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "user.2fa_disable", auditUser, uid, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearTwoFactor(ctx context.Context, uid int64) error {
+	if _, err := s.DB.Exec(ctx, "update app_user set totp_enabled=false, totp_secret=null, totp_last_step=0 where id=$1", uid); err != nil {
+		return err
+	}
+	_, err := s.DB.Exec(ctx, "delete from user_recovery_code where user_id=$1", uid)
+	return err
+}
+
+// handleResetUserTwoFactor lets an admin turn off 2FA for a user who lost
+// both their device and recovery codes
+func (s *Server) handleResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	if err := s.clearTwoFactor(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "user.2fa_reset", auditUser, id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]bool{"require_for_admins": s.requireAdmin2FA(r.Context())})
+}
+
+// handleSetTwoFactorPolicy requires 2FA for admins. Admins without it are
+// then limited to enrolling until they do.
+func (s *Server) handleSetTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RequireForAdmins bool `json:"require_for_admins"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	_, err := s.DB.Exec(r.Context(), `
+		insert into app_setting(key, value) values ($1, $2)
+		on conflict (key) do update set value = excluded.value`,
+		settingRequireAdmin2FA, strconv.FormatBool(req.RequireForAdmins))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.audit(r, "settings.2fa", auditSettings, nil, map[string]any{"require_for_admins": req.RequireForAdmins})
+	writeJSON(w, 200, map[string]bool{"require_for_admins": req.RequireForAdmins})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/example/mediahub/internal/transcode"
+)
+
+// Audio transcoding for low-bandwidth clients:
+// /api/items/{id}/stream?format=opus|mp3|aac&bitrate=<kbps>, plus a per-user
+// default profile used by Subsonic streams and playback-info.
+
+// handleAudioTranscode streams an audio item re-encoded to the requested profile
+func (s *Server) handleAudioTranscode(w http.ResponseWriter, r *http.Request, id int64, profile transcode.AudioProfile) {
+	var path, kind string
+	var present bool
+	err := s.DB.QueryRow(r.Context(), "select path, kind, present from media_item where id=$1", id).Scan(&path, &kind, &present)
+	if err != nil || !present {
+		http.NotFound(w, r)
+		return
+	}
+	if kind != "audio" {
+		http.Error(w, "only audio items can be transcoded to an audio format", 400)
+		return
+	}
+	s.AudioTranscoder.Serve(w, r, id, path, profile)
+}
+
+// userAudioProfile returns the stored default profile of a user, if any
+func (s *Server) userAudioProfile(ctx context.Context, uid int64) (transcode.AudioProfile, bool) {
+	var format string
+	var kbps int
+	err := s.DB.QueryRow(ctx, "select audio_format, audio_bitrate from user_transcode_profile where user_id=$1", uid).Scan(&format, &kbps)
+	if err != nil {
+		return transcode.AudioProfile{}, false
+	}
+	p, err := transcode.ParseAudioProfile(format, kbps)
+	return p, err == nil
+}
+
+// handleGetTranscodeProfile returns the current user's default profile,
+// with an empty format when none is set
+func (s *Server) handleGetTranscodeProfile(w http.ResponseWriter, r *http.Request) {
+	uid, ok := UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+	out := TranscodeProfile{}
+	if p, ok := s.userAudioProfile(r.Context(), uid); ok {
+		out = TranscodeProfile{AudioFormat: p.Format.Name, AudioBitrate: p.Kbps}
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleSetTranscodeProfile stores the current user's default profile;
+// an empty audio_format removes it (original files are streamed)
+func (s *Server) handleSetTranscodeProfile(w http.ResponseWriter, r *http.Request) {
+	uid, ok := UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+	var req TranscodeProfile
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+
+	if strings.TrimSpace(req.AudioFormat) == "" {
+		if _, err := s.DB.Exec(r.Context(), "delete from user_transcode_profile where user_id=$1", uid); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		writeJSON(w, 200, TranscodeProfile{})
+		return
+	}
+
+	p, err := transcode.ParseAudioProfile(req.AudioFormat, req.AudioBitrate)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	_, err = s.DB.Exec(r.Context(), `
+		insert into user_transcode_profile(user_id, audio_format, audio_bitrate, updated_at)
+		values ($1,$2,$3,now())
+		on conflict (user_id) do update set audio_format=excluded.audio_format, audio_bitrate=excluded.audio_bitrate, updated_at=now()`,
+		uid, p.Format.Name, p.Kbps)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, TranscodeProfile{AudioFormat: p.Format.Name, AudioBitrate: p.Kbps})
+}
+
+// audioTranscodeQuery is the stream path of an item in a given profile,
+// relative to /api/items/{id}/
+func audioTranscodeQuery(p transcode.AudioProfile) string {
+	return "stream?format=" + p.Format.Name + "&bitrate=" + strconv.Itoa(p.Kbps)
+}
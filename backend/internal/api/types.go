@@ -1,20 +1,167 @@
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/example/mediahub/internal/media"
+)
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Device        string `json:"device"`         // optional name shown in the session list
+	SessionCookie bool   `json:"session_cookie"` // also start a cookie session
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`           // access token lifetime in seconds
+	CSRFToken    string `json:"csrf_token,omitempty"` // with session_cookie: send as X-CSRF-Token
+
+	MustChangePassword bool `json:"must_change_password,omitempty"` // only PUT /api/users/password is allowed
+
+	// With two-factor authentication only these are set: send the challenge
+	// token and a code to POST /api/auth/login/2fa within 5 minutes
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type UserSession struct {
+	ID         int64     `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Cookie     bool      `json:"cookie"` // browser cookie session
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`     // read, scan, admin; none = unrestricted
+	ExpiresAt *time.Time `json:"expires_at"` // optional
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"` // shown once
+}
+
+type Share struct {
+	ID            int64      `json:"id"`
+	Kind          string     `json:"kind"` // item, folder or tag
+	ItemID        *int64     `json:"item_id,omitempty"`
+	LibraryID     *int64     `json:"library_id,omitempty"`
+	FolderPath    *string    `json:"folder_path,omitempty"` // in the library, "" for all of it
+	TagID         *int64     `json:"tag_id,omitempty"`
+	Name          string     `json:"name"`
+	Prefix        string     `json:"prefix"` // start of the token
+	HasPassword   bool       `json:"has_password"`
+	AllowDownload bool       `json:"allow_download"`
+	Views         int        `json:"views"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastViewedAt  *time.Time `json:"last_viewed_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
+
+type CreateShareRequest struct {
+	ItemID        *int64     `json:"item_id"`
+	LibraryID     *int64     `json:"library_id"` // with path, a folder of the library
+	Path          string     `json:"path"`
+	TagID         *int64     `json:"tag_id"`
+	Name          string     `json:"name"`     // defaults to the file, folder or tag name
+	Password      string     `json:"password"` // optional
+	AllowDownload bool       `json:"allow_download"`
+	ExpiresAt     *time.Time `json:"expires_at"` // optional
+}
+
+type CreateShareResponse struct {
+	Share
+	Token string `json:"token"` // shown once
+	Path  string `json:"path"`  // /s/<token>
+}
+
+// SharedListing is what a share link shows, without an account
+type SharedListing struct {
+	Name             string       `json:"name"`
+	Kind             string       `json:"kind"`
+	AllowDownload    bool         `json:"allow_download"`
+	ExpiresAt        *time.Time   `json:"expires_at"`
+	PasswordRequired bool         `json:"password_required"`
+	Path             string       `json:"path"`    // folder shares: current folder, relative to the share
+	Folders          []string     `json:"folders"` // subfolders of Path
+	Items            []SharedItem `json:"items"`
+}
+
+type SharedItem struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Path        string     `json:"path"` // relative to the share
+	Kind        string     `json:"kind"`
+	SizeBytes   int64      `json:"size_bytes"`
+	MTime       *time.Time `json:"mtime,omitempty"`
+	ThumbURL    string     `json:"thumb_url,omitempty"`
+	StreamURL   string     `json:"stream_url,omitempty"` // not for other files without downloads
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+type UserIdentity struct {
+	ID        int64     `json:"id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *int64          `json:"actor_id"`
+	ActorName  string          `json:"actor_name"`
+	Action     string          `json:"action"` // e.g. library.delete, user.create
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	RequestID  string          `json:"request_id"` // as in the server log lines
+	IP         string          `json:"ip"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+type PagedAudit struct {
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+	Total    int64        `json:"total"`
+	Entries  []AuditEntry `json:"entries"`
 }
 
 type Library struct {
-	ID    int64    `json:"id"`
-	Name  string   `json:"name"`
-	Roots []string `json:"roots"`
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Roots      []string `json:"roots"`
+	Restricted bool     `json:"restricted"`
+}
+
+// LibraryAccess is who may see a restricted library (admins always can)
+type LibraryAccess struct {
+	Restricted bool    `json:"restricted"`
+	UserIDs    []int64 `json:"user_ids"`
 }
 
 type CreateLibraryRequest struct {
@@ -23,16 +170,53 @@ type CreateLibraryRequest struct {
 }
 
 type MediaItem struct {
-	ID         int64      `json:"id"`
-	LibraryID  int64      `json:"library_id"`
-	RelPath    string     `json:"rel_path"`
-	Path       string     `json:"path"`
-	Kind       string     `json:"kind"`
-	Present    bool       `json:"present"`
-	SizeBytes  int64      `json:"size_bytes"`
-	MTime      *time.Time `json:"mtime,omitempty"`
-	LastSeenAt time.Time  `json:"last_seen_at"`
-	ThumbURL   string     `json:"thumb_url,omitempty"`
+	ID         int64       `json:"id"`
+	LibraryID  int64       `json:"library_id"`
+	RelPath    string      `json:"rel_path"`
+	Path       string      `json:"path"`
+	Kind       string      `json:"kind"`
+	Present    bool        `json:"present"`
+	SizeBytes  int64       `json:"size_bytes"`
+	MTime      *time.Time  `json:"mtime,omitempty"`
+	LastSeenAt time.Time   `json:"last_seen_at"`
+	ThumbURL   string      `json:"thumb_url,omitempty"`
+	StreamURL  string      `json:"stream_url,omitempty"`
+	ReplayGain *ReplayGain `json:"replay_gain,omitempty"`
+	VideoMeta  *VideoMeta  `json:"video_meta,omitempty"`
+}
+
+// VideoMeta is the metadata read from a video's Kodi NFO sidecar
+type VideoMeta struct {
+	Title         *string           `json:"title,omitempty"`
+	OriginalTitle *string           `json:"original_title,omitempty"`
+	Plot          *string           `json:"plot,omitempty"`
+	Tagline       *string           `json:"tagline,omitempty"`
+	Year          *int              `json:"year,omitempty"`
+	Premiered     *string           `json:"premiered,omitempty"`
+	RuntimeMin    *int              `json:"runtime_min,omitempty"`
+	Genres        []string          `json:"genres"`
+	Studios       []string          `json:"studios"`
+	MPAA          *string           `json:"mpaa,omitempty"`
+	Rating        *float64          `json:"rating,omitempty"`
+	Actors        []media.NFOActor  `json:"actors"`
+	ExternalIDs   map[string]string `json:"external_ids"`
+}
+
+// ReplayGain carries normalization info for audio playback:
+// gains in dB relative to -18 LUFS, peaks linear (1.0 = full scale)
+type ReplayGain struct {
+	TrackGainDB *float64 `json:"track_gain_db,omitempty"`
+	TrackPeak   *float64 `json:"track_peak,omitempty"`
+	AlbumGainDB *float64 `json:"album_gain_db,omitempty"`
+	AlbumPeak   *float64 `json:"album_peak,omitempty"`
+}
+
+// newReplayGain returns nil when nothing has been analyzed yet
+func newReplayGain(trackGain, trackPeak, albumGain, albumPeak *float64) *ReplayGain {
+	if trackGain == nil && albumGain == nil {
+		return nil
+	}
+	return &ReplayGain{TrackGainDB: trackGain, TrackPeak: trackPeak, AlbumGainDB: albumGain, AlbumPeak: albumPeak}
 }
 
 type PagedItems struct {
@@ -41,3 +225,151 @@ type PagedItems struct {
 	Total    int64       `json:"total"`
 	Items    []MediaItem `json:"items"`
 }
+
+type Artist struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	AlbumCount int64  `json:"album_count"`
+	TrackCount int64  `json:"track_count"`
+}
+
+type Album struct {
+	ID         int64   `json:"id"`
+	ArtistID   int64   `json:"artist_id"`
+	ArtistName string  `json:"artist_name"`
+	Title      string  `json:"title"`
+	Year       *int    `json:"year,omitempty"`
+	Genre      *string `json:"genre,omitempty"`
+	TrackCount int64   `json:"track_count"`
+	DurationMs int64   `json:"duration_ms"`
+}
+
+type Track struct {
+	ItemID     int64       `json:"item_id"`
+	AlbumID    int64       `json:"album_id"`
+	AlbumTitle string      `json:"album_title"`
+	ArtistID   int64       `json:"artist_id"`
+	ArtistName string      `json:"artist_name"`
+	Title      string      `json:"title"`
+	TrackNo    *int        `json:"track_no,omitempty"`
+	DiscNo     *int        `json:"disc_no,omitempty"`
+	Year       *int        `json:"year,omitempty"`
+	Genre      *string     `json:"genre,omitempty"`
+	DurationMs *int        `json:"duration_ms,omitempty"`
+	ReplayGain *ReplayGain `json:"replay_gain,omitempty"`
+	StreamURL  string      `json:"stream_url"`
+}
+
+type AlbumDetail struct {
+	Album
+	Tracks []Track `json:"tracks"`
+}
+
+type PagedTracks struct {
+	Page     int     `json:"page"`
+	PageSize int     `json:"page_size"`
+	Total    int64   `json:"total"`
+	Tracks   []Track `json:"tracks"`
+}
+
+type Lyrics struct {
+	ItemID int64             `json:"item_id"`
+	Source string            `json:"source"`
+	Synced bool              `json:"synced"`
+	Lang   string            `json:"lang,omitempty"`
+	Lines  []media.LyricLine `json:"lines,omitempty"`
+	Text   string            `json:"text"`
+}
+
+type Show struct {
+	ID           int64    `json:"id"`
+	Title        string   `json:"title"`
+	Year         *int     `json:"year,omitempty"`
+	Plot         *string  `json:"plot,omitempty"`
+	Genres       []string `json:"genres"`
+	Rating       *float64 `json:"rating,omitempty"`
+	SeasonCount  int64    `json:"season_count"`
+	EpisodeCount int64    `json:"episode_count"`
+	WatchedCount int64    `json:"watched_count"`
+	ThumbItemID  *int64   `json:"thumb_item_id,omitempty"`
+	ThumbURL     string   `json:"thumb_url,omitempty"`
+}
+
+type Season struct {
+	ID           int64     `json:"id"`
+	SeriesID     int64     `json:"series_id"`
+	Number       int       `json:"number"`
+	EpisodeCount int64     `json:"episode_count"`
+	WatchedCount int64     `json:"watched_count"`
+	Episodes     []Episode `json:"episodes"`
+}
+
+type Episode struct {
+	ItemID     int64   `json:"item_id"`
+	SeasonNo   int     `json:"season_no"`
+	EpisodeNo  int     `json:"episode_no"`
+	EpisodeEnd *int    `json:"episode_end,omitempty"`
+	Title      *string `json:"title,omitempty"`
+	DurationMs *int    `json:"duration_ms,omitempty"`
+	HasThumb   bool    `json:"has_thumb"`
+	Watched    bool    `json:"watched"`
+	StreamURL  string  `json:"stream_url"`
+	ThumbURL   string  `json:"thumb_url,omitempty"`
+}
+
+type Movie struct {
+	ID         int64    `json:"id"`
+	Title      string   `json:"title"`
+	Year       *int     `json:"year,omitempty"`
+	Plot       *string  `json:"plot,omitempty"`
+	Genres     []string `json:"genres"`
+	Rating     *float64 `json:"rating,omitempty"`
+	ItemIDs    []int64  `json:"item_ids"`
+	DurationMs *int     `json:"duration_ms,omitempty"`
+	HasThumb   bool     `json:"has_thumb"`
+	Watched    bool     `json:"watched"`
+	ThumbURL   string   `json:"thumb_url,omitempty"`
+}
+
+type Subtitle struct {
+	Index   int     `json:"index"`
+	Source  string  `json:"source"` // sidecar | embedded
+	Format  string  `json:"format"`
+	Lang    *string `json:"lang,omitempty"`
+	Title   *string `json:"title,omitempty"`
+	Forced  bool    `json:"forced"`
+	SDH     bool    `json:"sdh"`
+	Default bool    `json:"default"`
+	URL     string  `json:"url"`
+}
+
+// PlaybackInfo describes a file's streams and how the requesting client
+// should play it
+type PlaybackInfo struct {
+	ItemID        int64  `json:"item_id"`
+	Kind          string `json:"kind"`
+	MimeType      string `json:"mime_type"`
+	Container     string `json:"container,omitempty"`
+	VideoCodec    string `json:"video_codec,omitempty"`
+	VideoProfile  string `json:"video_profile,omitempty"`
+	PixFmt        string `json:"pix_fmt,omitempty"`
+	Width         *int   `json:"width,omitempty"`
+	Height        *int   `json:"height,omitempty"`
+	AudioCodec    string `json:"audio_codec,omitempty"`
+	AudioChannels int    `json:"audio_channels,omitempty"`
+	BitRate       int64  `json:"bit_rate,omitempty"`
+	DurationMs    *int   `json:"duration_ms,omitempty"`
+	media.PlaybackDecision
+	StreamURL    string `json:"stream_url"`
+	HLSURL       string `json:"hls_url,omitempty"`       // videos that cannot be direct-played
+	RemuxURL     string `json:"remux_url,omitempty"`     // fragmented MP4, seek with &t=<seconds>
+	TranscodeURL string `json:"transcode_url,omitempty"` // audio re-encoded to the user's profile
+	PlayURL      string `json:"play_url"`                // what the client should load
+}
+
+// TranscodeProfile is a user's default audio transcoding; an empty format
+// means original files are streamed
+type TranscodeProfile struct {
+	AudioFormat  string `json:"audio_format"`
+	AudioBitrate int    `json:"audio_bitrate"`
+}
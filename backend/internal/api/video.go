@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/example/mediahub/internal/media"
+)
+
+// TV shows and movies, recognized by the metadata worker from video file
+// names and folders. An item counts as watched once it is in the user's
+// playback history.
+
+// handleShows lists series with at least one present episode
+func (s *Server) handleShows(w http.ResponseWriter, r *http.Request) {
+	userID, _ := UserIDFromContext(r.Context())
+	lid, _ := strconv.ParseInt(r.URL.Query().Get("library_id"), 10, 64)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	args := []any{userID}
+	where := []string{"mi.present = true"}
+	if scope := s.libraryScope(r.Context()); scope != nil {
+		args = append(args, scope)
+		where = append(where, fmt.Sprintf("mi.library_id = any($%d)", len(args)))
+	}
+	if lid > 0 {
+		args = append(args, lid)
+		where = append(where, fmt.Sprintf("mi.library_id = $%d", len(args)))
+	}
+	if q != "" {
+		args = append(args, "%"+q+"%")
+		where = append(where, fmt.Sprintf("se.title ILIKE $%d", len(args)))
+	}
+
+	rows, err := s.DB.Query(r.Context(), fmt.Sprintf(`
+		select se.id, se.title, se.year, se.plot, se.genres, se.rating,
+		       count(distinct e.season_id), count(e.item_id), count(up.item_id),
+		       (array_agg(e.item_id order by e.season_id, e.episode_no) filter (where mi.thumb_path is not null))[1]
+		from series se
+		join episode e on e.series_id = se.id
+		join media_item mi on mi.id = e.item_id
+		left join user_playback up on up.item_id = e.item_id and up.user_id = $1
+		where %s
+		group by se.id
+		order by se.sort_title asc, se.year asc nulls last
+		limit 5000`, strings.Join(where, " and ")), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []Show{}
+	for rows.Next() {
+		var sh Show
+		if err := rows.Scan(&sh.ID, &sh.Title, &sh.Year, &sh.Plot, &sh.Genres, &sh.Rating, &sh.SeasonCount, &sh.EpisodeCount, &sh.WatchedCount, &sh.ThumbItemID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if sh.ThumbItemID != nil {
+			sh.ThumbURL = s.mediaURL(r.Context(), *sh.ThumbItemID, "thumb")
+		}
+		out = append(out, sh)
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleShowSeasons returns the seasons of a series with their present
+// episodes in episode order
+func (s *Server) handleShowSeasons(w http.ResponseWriter, r *http.Request) {
+	userID, _ := UserIDFromContext(r.Context())
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if id <= 0 {
+		http.Error(w, "bad id", 400)
+		return
+	}
+
+	var exists bool
+	if err := s.DB.QueryRow(r.Context(), "select exists(select 1 from series where id = $1)", id).Scan(&exists); err != nil || !exists {
+		http.Error(w, "not found", 404)
+		return
+	}
+
+	rows, err := s.DB.Query(r.Context(), `
+		select e.season_id, sn.number, e.item_id, e.episode_no, e.episode_end, e.title,
+		       mi.duration_ms, mi.thumb_path is not null, up.item_id is not null
+		from episode e
+		join season sn on sn.id = e.season_id
+		join media_item mi on mi.id = e.item_id
+		left join user_playback up on up.item_id = e.item_id and up.user_id = $2
+		where e.series_id = $1 and mi.present = true and `+inScope("mi.library_id", 3)+`
+		order by sn.number asc, e.episode_no asc, mi.rel_path asc`, id, userID, s.libraryScope(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []Season{}
+	for rows.Next() {
+		var seasonID int64
+		var ep Episode
+		if err := rows.Scan(&seasonID, &ep.SeasonNo, &ep.ItemID, &ep.EpisodeNo, &ep.EpisodeEnd, &ep.Title,
+			&ep.DurationMs, &ep.HasThumb, &ep.Watched); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		ep.StreamURL = s.mediaURL(r.Context(), ep.ItemID, "stream")
+		if ep.HasThumb {
+			ep.ThumbURL = s.mediaURL(r.Context(), ep.ItemID, "thumb")
+		}
+		if len(out) == 0 || out[len(out)-1].ID != seasonID {
+			out = append(out, Season{ID: seasonID, SeriesID: id, Number: ep.SeasonNo, Episodes: []Episode{}})
+		}
+		sn := &out[len(out)-1]
+		sn.Episodes = append(sn.Episodes, ep)
+		sn.EpisodeCount++
+		if ep.Watched {
+			sn.WatchedCount++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, out)
+}
+
+// handleMovies lists movies with at least one present file
+func (s *Server) handleMovies(w http.ResponseWriter, r *http.Request) {
+	userID, _ := UserIDFromContext(r.Context())
+	lid, _ := strconv.ParseInt(r.URL.Query().Get("library_id"), 10, 64)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	sort := strings.TrimSpace(r.URL.Query().Get("sort")) // name|year|recent
+	watched := r.URL.Query().Get("watched")              // true|false
+
+	args := []any{userID}
+	where := []string{"mi.present = true"}
+	if scope := s.libraryScope(r.Context()); scope != nil {
+		args = append(args, scope)
+		where = append(where, fmt.Sprintf("mi.library_id = any($%d)", len(args)))
+	}
+	if lid > 0 {
+		args = append(args, lid)
+		where = append(where, fmt.Sprintf("mi.library_id = $%d", len(args)))
+	}
+	if q != "" {
+		args = append(args, "%"+q+"%")
+		where = append(where, fmt.Sprintf("m.title ILIKE $%d", len(args)))
+	}
+	having := ""
+	switch watched {
+	case "true":
+		having = "having count(up.item_id) > 0"
+	case "false":
+		having = "having count(up.item_id) = 0"
+	}
+
+	orderBy := "m.sort_title asc, m.year asc nulls last"
+	switch sort {
+	case "year":
+		orderBy = "m.year desc nulls last, m.sort_title asc"
+	case "recent":
+		orderBy = "max(mi.created_at) desc"
+	}
+
+	rows, err := s.DB.Query(r.Context(), fmt.Sprintf(`
+		select m.id, m.title, m.year, meta.plot, coalesce(meta.genres, '{}'), meta.rating,
+		       array_agg(mi.id order by mi.size_bytes desc),
+		       max(mi.duration_ms), (array_agg(mi.id order by mi.size_bytes desc) filter (where mi.thumb_path is not null))[1],
+		       count(up.item_id) > 0
+		from movie m
+		join movie_file f on f.movie_id = m.id
+		join media_item mi on mi.id = f.item_id
+		left join user_playback up on up.item_id = f.item_id and up.user_id = $1
+		left join lateral (
+			select vm.plot, vm.genres, vm.rating
+			from movie_file f2
+			join video_meta vm on vm.item_id = f2.item_id
+			where f2.movie_id = m.id
+			limit 1
+		) meta on true
+		where %s
+		group by m.id, meta.plot, meta.genres, meta.rating
+		%s
+		order by %s
+		limit 5000`, strings.Join(where, " and "), having, orderBy), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	out := []Movie{}
+	for rows.Next() {
+		var m Movie
+		var thumbItem *int64
+		if err := rows.Scan(&m.ID, &m.Title, &m.Year, &m.Plot, &m.Genres, &m.Rating, &m.ItemIDs, &m.DurationMs, &thumbItem, &m.Watched); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if thumbItem != nil {
+			m.HasThumb = true
+			m.ThumbURL = s.mediaURL(r.Context(), *thumbItem, "thumb")
+		}
+		out = append(out, m)
+	}
+	writeJSON(w, 200, out)
+}
+
+// loadVideoMeta returns the NFO metadata of a video, nil when it has none
+func (s *Server) loadVideoMeta(ctx context.Context, itemID int64) *VideoMeta {
+	var m VideoMeta
+	var actors, ids []byte
+	err := s.DB.QueryRow(ctx, `
+		select title, original_title, plot, tagline, year, premiered, runtime_min,
+		       genres, studios, mpaa, rating, actors, external_ids
+		from video_meta where item_id = $1`, itemID,
+	).Scan(&m.Title, &m.OriginalTitle, &m.Plot, &m.Tagline, &m.Year, &m.Premiered, &m.RuntimeMin,
+		&m.Genres, &m.Studios, &m.MPAA, &m.Rating, &actors, &ids)
+	if err != nil {
+		return nil
+	}
+	_ = json.Unmarshal(actors, &m.Actors)
+	_ = json.Unmarshal(ids, &m.ExternalIDs)
+	if m.Actors == nil {
+		m.Actors = []media.NFOActor{}
+	}
+	return &m
+}
@@ -2,17 +2,67 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	DatabaseURL   string
-	JWTSecret     string
-	ThumbDir      string
-	IndexOther    bool
-	ExtPhoto      map[string]struct{}
-	ExtAudio      map[string]struct{}
-	ExtVideo      map[string]struct{}
+	DatabaseURL  string
+	JWTSecret    string
+	ThumbDir     string
+	TranscodeDir string
+	AudioCacheMB int // transcoded audio cache size, 0 disables caching
+	ImageCacheMB int // resized image cache size, 0 disables caching
+	SignedURLTTL time.Duration
+	SessionTTL   time.Duration // session (cookie and refresh token) lifetime
+	AccessTTL    time.Duration // access JWT lifetime
+	IndexOther   bool
+
+	// Bootstrap admin, created when there are no users. Without a password
+	// it is "admin" and must be changed at first login.
+	AdminUsername string
+	AdminPassword string
+
+	// Password policy for new passwords
+	PasswordMinLength  int
+	PasswordMinClasses int // of lowercase, uppercase, digits, other
+
+	// Login throttling: past the failures, an IP or username is locked out
+	// until LoginLockout has passed since the last failure. 0 disables.
+	LoginMaxFailures   int // per username
+	LoginMaxFailuresIP int // per client IP
+	LoginLockout       time.Duration
+
+	// Reverse proxies (addresses or CIDR ranges) whose X-Forwarded-For and
+	// X-Real-IP headers give the client address; others' are ignored
+	TrustedProxies []string
+
+	AuditRetentionDays int // audit log entries older than this are deleted, 0 keeps them
+
+	// OpenID Connect single sign-on, enabled by OIDC_ISSUER
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string   // this server's /api/auth/oidc/callback as the browser reaches it
+	OIDCScopes       []string // default openid, profile, email
+	OIDCGroupsClaim  string   // default "groups"
+	OIDCAdminGroups  []string // members become admins, others viewers; empty leaves roles alone
+	OIDCAutoCreate   bool     // create unknown users on first login
+	OIDCPostLoginURL string   // frontend URL the callback returns to
+
+	// CORS for browsers on other origins. Without allowed origins only the
+	// backend's own origin works, as when a reverse proxy serves both.
+	CORSAllowedOrigins []string // exact, or wildcard subdomains: https://*.example.com
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSMaxAge         time.Duration // preflight cache lifetime
+	CORSDev            bool          // allow any origin, never in production
+
+	ExtPhoto map[string]struct{}
+	ExtAudio map[string]struct{}
+	ExtVideo map[string]struct{}
 }
 
 func parseCSVSet(v string) map[string]struct{} {
@@ -27,19 +77,112 @@ func parseCSVSet(v string) map[string]struct{} {
 	return out
 }
 
+// parseCSVList keeps case, unlike parseCSVSet
+func parseCSVList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
 func Load() Config {
 	indexOther := strings.ToLower(strings.TrimSpace(os.Getenv("INDEX_OTHER"))) == "true"
 	cfg := Config{
-		DatabaseURL: os.Getenv("DATABASE_URL"),
-		JWTSecret:   os.Getenv("JWT_SECRET"),
-		ThumbDir:    os.Getenv("THUMB_DIR"),
-		IndexOther:  indexOther,
-		ExtPhoto:    parseCSVSet(os.Getenv("MEDIA_EXT_PHOTO")),
-		ExtAudio:    parseCSVSet(os.Getenv("MEDIA_EXT_AUDIO")),
-		ExtVideo:    parseCSVSet(os.Getenv("MEDIA_EXT_VIDEO")),
+		DatabaseURL:  os.Getenv("DATABASE_URL"),
+		JWTSecret:    os.Getenv("JWT_SECRET"),
+		ThumbDir:     os.Getenv("THUMB_DIR"),
+		TranscodeDir: os.Getenv("TRANSCODE_DIR"),
+		AudioCacheMB: 1024,
+		ImageCacheMB: 512,
+		SignedURLTTL: 6 * time.Hour,
+		SessionTTL:   30 * 24 * time.Hour,
+		AccessTTL:    15 * time.Minute,
+		IndexOther:   indexOther,
+
+		AdminUsername:      strings.TrimSpace(os.Getenv("ADMIN_USERNAME")),
+		AdminPassword:      os.Getenv("ADMIN_PASSWORD"),
+		PasswordMinLength:  8,
+		PasswordMinClasses: 1,
+		LoginMaxFailures:   5,
+		LoginMaxFailuresIP: 20,
+		LoginLockout:       15 * time.Minute,
+		AuditRetentionDays: 365,
+
+		OIDCIssuer:       strings.TrimSpace(os.Getenv("OIDC_ISSUER")),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:       strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " ")),
+		OIDCGroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		OIDCAdminGroups:  parseCSVList(os.Getenv("OIDC_ADMIN_GROUPS")),
+		OIDCAutoCreate:   strings.ToLower(strings.TrimSpace(os.Getenv("OIDC_AUTO_CREATE"))) != "false",
+		OIDCPostLoginURL: os.Getenv("OIDC_POST_LOGIN_URL"),
+
+		TrustedProxies: parseCSVList(os.Getenv("TRUSTED_PROXIES")),
+
+		CORSAllowedOrigins: parseCSVList(os.Getenv("CORS_ALLOWED_ORIGINS")),
+		CORSAllowedMethods: parseCSVList(os.Getenv("CORS_ALLOWED_METHODS")),
+		CORSAllowedHeaders: parseCSVList(os.Getenv("CORS_ALLOWED_HEADERS")),
+		CORSMaxAge:         10 * time.Minute,
+		CORSDev:            strings.ToLower(strings.TrimSpace(os.Getenv("CORS_DEV"))) == "true",
+
+		ExtPhoto: parseCSVSet(os.Getenv("MEDIA_EXT_PHOTO")),
+		ExtAudio: parseCSVSet(os.Getenv("MEDIA_EXT_AUDIO")),
+		ExtVideo: parseCSVSet(os.Getenv("MEDIA_EXT_VIDEO")),
 	}
 	if cfg.ThumbDir == "" {
 		cfg.ThumbDir = "/data/thumbs"
 	}
+	if cfg.AdminUsername == "" {
+		cfg.AdminUsername = "admin"
+	}
+	if cfg.OIDCPostLoginURL == "" {
+		cfg.OIDCPostLoginURL = "/"
+	}
+	if len(cfg.CORSAllowedMethods) == 0 {
+		cfg.CORSAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	}
+	if len(cfg.CORSAllowedHeaders) == 0 {
+		cfg.CORSAllowedHeaders = []string{"Authorization", "Content-Type", "X-CSRF-Token", "X-Api-Key"}
+	}
+	if cfg.TranscodeDir == "" {
+		cfg.TranscodeDir = filepath.Join(os.TempDir(), "mediahub-transcode")
+	}
+	cfg.AudioCacheMB = envInt("TRANSCODE_AUDIO_CACHE_MB", cfg.AudioCacheMB, 0)
+	cfg.ImageCacheMB = envInt("TRANSCODE_IMAGE_CACHE_MB", cfg.ImageCacheMB, 0)
+	cfg.SignedURLTTL = envDuration("SIGNED_URL_TTL", cfg.SignedURLTTL)
+	cfg.SessionTTL = envDuration("SESSION_TTL", cfg.SessionTTL)
+	cfg.AccessTTL = envDuration("ACCESS_TOKEN_TTL", cfg.AccessTTL)
+
+	cfg.PasswordMinLength = envInt("PASSWORD_MIN_LENGTH", cfg.PasswordMinLength, 1)
+	cfg.PasswordMinClasses = min(envInt("PASSWORD_MIN_CLASSES", cfg.PasswordMinClasses, 1), 4)
+	cfg.LoginMaxFailures = envInt("LOGIN_MAX_FAILURES", cfg.LoginMaxFailures, 0)
+	cfg.LoginMaxFailuresIP = envInt("LOGIN_MAX_FAILURES_PER_IP", cfg.LoginMaxFailuresIP, 0)
+	cfg.LoginLockout = envDuration("LOGIN_LOCKOUT", cfg.LoginLockout)
+	cfg.CORSMaxAge = envDuration("CORS_MAX_AGE", cfg.CORSMaxAge)
+	cfg.AuditRetentionDays = envInt("AUDIT_RETENTION_DAYS", cfg.AuditRetentionDays, 0)
 	return cfg
 }
+
+// envInt reads an integer variable; unset, malformed or below floor keeps def
+func envInt(name string, def, floor int) int {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= floor {
+			return n
+		}
+	}
+	return def
+}
+
+// envDuration reads a positive duration ("90s", "12h")
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
@@ -0,0 +1,114 @@
+// Package cors answers cross-origin requests from an allowlist of origins.
+// Allowed origins get credentialed CORS (cookies, Authorization); others get
+// no CORS headers at all, so browsers keep them to the same-origin policy.
+package cors
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Exact origins ("https://media.example.com") or any subdomain of a
+	// domain ("https://*.example.com", which doesn't match example.com)
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAge         time.Duration // how long browsers may cache a preflight
+	Dev            bool          // allow any origin, for local development only
+}
+
+type Policy struct {
+	exact     map[string]bool
+	wildcards []wildcard
+	methods   string
+	headers   string
+	maxAge    string
+	dev       bool
+}
+
+// wildcard matches https://*.example.com as scheme "https" and host suffix
+// ".example.com" (port included, if any)
+type wildcard struct {
+	scheme, suffix string
+}
+
+func New(c Config) *Policy {
+	p := &Policy{
+		exact:   map[string]bool{},
+		methods: strings.Join(c.AllowedMethods, ", "),
+		headers: strings.Join(c.AllowedHeaders, ", "),
+		dev:     c.Dev,
+	}
+	if c.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(c.MaxAge.Seconds()))
+	}
+	for _, o := range c.AllowedOrigins {
+		o = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(o)), "/")
+		if scheme, host, ok := strings.Cut(o, "://*."); ok {
+			p.wildcards = append(p.wildcards, wildcard{scheme: scheme, suffix: "." + host})
+		} else if o != "" {
+			p.exact[o] = true
+		}
+	}
+	return p
+}
+
+// Allowed reports whether an Origin header value is on the allowlist
+func (p *Policy) Allowed(origin string) bool {
+	if p.dev {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, w := range p.wildcards {
+		if u.Scheme == w.scheme && len(u.Host) > len(w.suffix) && strings.HasSuffix(u.Host, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler sets the CORS headers of allowed origins and answers their
+// preflight requests. Requests of other origins go on untouched: same-origin
+// requests send an Origin too, and the browser enforces the rest.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		allowed := p.Allowed(origin)
+		if allowed {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowed {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", p.methods)
+			h.Set("Access-Control-Allow-Headers", p.headers)
+			if p.maxAge != "" {
+				h.Set("Access-Control-Max-Age", p.maxAge)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -67,15 +67,17 @@ func (d *DB) Migrate(ctx context.Context, migrationsDir string) error {
 	return nil
 }
 
-func (d *DB) EnsureDefaultAdmin(ctx context.Context, username, passwordHash string) error {
-	var exists bool
-	err := d.Pool.QueryRow(ctx, "select exists(select 1 from app_user where username=$1)", username).Scan(&exists)
+// EnsureDefaultAdmin creates the bootstrap admin when there are no users at
+// all, so that a renamed or deleted admin doesn't come back. It reports
+// whether the user was created.
+func (d *DB) EnsureDefaultAdmin(ctx context.Context, username, passwordHash string, mustChangePassword bool) (bool, error) {
+	tag, err := d.Pool.Exec(ctx, `
+		insert into app_user(username, password_hash, role, must_change_password, created_at)
+		select $1, $2, 'admin', $3, $4
+		where not exists (select 1 from app_user)`,
+		username, passwordHash, mustChangePassword, time.Now())
 	if err != nil {
-		return err
+		return false, err
 	}
-	if exists {
-		return nil
-	}
-	_, err = d.Pool.Exec(ctx, "insert into app_user(username, password_hash, created_at) values ($1,$2,$3)", username, passwordHash, time.Now())
-	return err
+	return tag.RowsAffected() > 0, nil
 }
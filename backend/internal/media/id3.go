@@ -0,0 +1,178 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"unicode/utf16"
+)
+
+// ffprobe surfaces USLT (unsynced lyrics) as a "lyrics" tag but drops SYLT
+// frames entirely, so synced lyrics are read here straight from the ID3v2 tag.
+
+// ReadSYLT returns the first SYLT frame with millisecond timestamps in the
+// file's ID3v2.3/2.4 tag, and its language. A file without one returns nil.
+func ReadSYLT(path string) ([]LyricLine, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, "", nil
+	}
+	if string(header[:3]) != "ID3" {
+		return nil, "", nil
+	}
+	version := header[3]
+	flags := header[5]
+	if version != 3 && version != 4 {
+		return nil, "", nil
+	}
+	if flags&0x80 != 0 {
+		// Tag-wide unsynchronisation is rare in practice; not worth decoding here
+		return nil, "", nil
+	}
+	size := syncsafe(header[6:10])
+	if size <= 0 || size > 64<<20 {
+		return nil, "", nil
+	}
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(f, tag); err != nil {
+		return nil, "", nil
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		// Skip extended header
+		ext := int(binary.BigEndian.Uint32(tag[:4]))
+		if version == 4 {
+			ext = syncsafe(tag[:4])
+		} else {
+			ext += 4
+		}
+		pos = ext
+	}
+
+	for pos+10 <= len(tag) {
+		id := string(tag[pos : pos+4])
+		if id[0] == 0 {
+			break // padding
+		}
+		var frameSize int
+		if version == 4 {
+			frameSize = syncsafe(tag[pos+4 : pos+8])
+		} else {
+			frameSize = int(binary.BigEndian.Uint32(tag[pos+4 : pos+8]))
+		}
+		body := pos + 10
+		if frameSize <= 0 || body+frameSize > len(tag) {
+			break
+		}
+		if id == "SYLT" {
+			if lines, lang := parseSYLT(tag[body : body+frameSize]); len(lines) > 0 {
+				return lines, lang, nil
+			}
+		}
+		pos = body + frameSize
+	}
+	return nil, "", nil
+}
+
+// parseSYLT decodes a SYLT frame body:
+// encoding(1) language(3) timestamp format(1) content type(1) descriptor\0 {text\0 time(4)}...
+func parseSYLT(b []byte) ([]LyricLine, string) {
+	if len(b) < 6 {
+		return nil, ""
+	}
+	enc := b[0]
+	lang := string(b[1:4])
+	if b[4] != 2 {
+		// Only millisecond timestamps; MPEG frame counts need the bitrate
+		return nil, lang
+	}
+	rest := b[6:]
+	_, rest = splitTerminated(rest, enc) // content descriptor
+
+	var lines []LyricLine
+	for len(rest) > 0 {
+		var text string
+		text, rest = splitTerminated(rest, enc)
+		if len(rest) < 4 {
+			break
+		}
+		ms := int(binary.BigEndian.Uint32(rest[:4]))
+		rest = rest[4:]
+		// Some taggers prefix each line with "\n" instead of using separate lines
+		for len(text) > 0 && (text[0] == '\n' || text[0] == '\r') {
+			text = text[1:]
+		}
+		lines = append(lines, LyricLine{StartMs: ms, Text: text})
+	}
+	return lines, lang
+}
+
+// splitTerminated returns the string up to the encoding's terminator and the remainder
+func splitTerminated(b []byte, enc byte) (string, []byte) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				s := b[:i]
+				if enc == 2 {
+					return decodeUTF16BE(s), b[i+2:]
+				}
+				return decodeUTF16(s), b[i+2:]
+			}
+		}
+		return decodeUTF16(b), nil
+	}
+	for i, c := range b {
+		if c == 0 {
+			if enc == 0 {
+				return decodeLatin1(b[:i]), b[i+1:]
+			}
+			return string(b[:i]), b[i+1:]
+		}
+	}
+	if enc == 0 {
+		return decodeLatin1(b), nil
+	}
+	return string(b), nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func decodeLatin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// decodeUTF16 decodes UTF-16 using its BOM (little endian if absent)
+func decodeUTF16(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		return decodeUTF16BE(b[2:])
+	}
+	if len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE {
+		b = b[2:]
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func decodeUTF16BE(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,117 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReplayGainReference is the ReplayGain 2.0 target loudness in LUFS
+const ReplayGainReference = -18.0
+
+// Loudness is the gain information of a track or album.
+// GainDB is relative to ReplayGainReference; Peak is linear (1.0 = full scale).
+type Loudness struct {
+	LUFS   float64
+	GainDB float64
+	Peak   *float64
+}
+
+// TagLoudness holds loudness read from existing tags
+type TagLoudness struct {
+	Track *Loudness
+	Album *Loudness
+}
+
+var gainValueRe = regexp.MustCompile(`^\s*([+-]?\d+(?:\.\d+)?)`)
+
+// ReadLoudnessTags reads REPLAYGAIN_* tags, falling back to the Opus R128_*
+// tags (Q7.8 fixed point gains relative to -23 LUFS)
+func ReadLoudnessTags(p *ProbeResult) TagLoudness {
+	var out TagLoudness
+	if g, ok := parseGain(p.Tag("replaygain_track_gain")); ok {
+		out.Track = &Loudness{GainDB: g, LUFS: ReplayGainReference - g, Peak: parsePeak(p.Tag("replaygain_track_peak"))}
+	} else if g, ok := parseR128(p.Tag("r128_track_gain")); ok {
+		out.Track = &Loudness{GainDB: g, LUFS: ReplayGainReference - g}
+	}
+	if g, ok := parseGain(p.Tag("replaygain_album_gain")); ok {
+		out.Album = &Loudness{GainDB: g, LUFS: ReplayGainReference - g, Peak: parsePeak(p.Tag("replaygain_album_peak"))}
+	} else if g, ok := parseR128(p.Tag("r128_album_gain")); ok {
+		out.Album = &Loudness{GainDB: g, LUFS: ReplayGainReference - g}
+	}
+	return out
+}
+
+// parseGain parses "-6.54 dB"
+func parseGain(v string) (float64, bool) {
+	m := gainValueRe.FindStringSubmatch(v)
+	if m == nil {
+		return 0, false
+	}
+	g, err := strconv.ParseFloat(m[1], 64)
+	return g, err == nil
+}
+
+func parsePeak(v string) *float64 {
+	p, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || p <= 0 {
+		return nil
+	}
+	return &p
+}
+
+// parseR128 converts an R128 gain (1/256 dB, reference -23 LUFS) to a ReplayGain 2.0 gain
+func parseR128(v string) (float64, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, false
+	}
+	return float64(n)/256 + (ReplayGainReference - (-23)), true
+}
+
+var (
+	ebuIntegratedRe = regexp.MustCompile(`I:\s+(-?[\d.]+|-inf)\s+LUFS`)
+	ebuPeakRe       = regexp.MustCompile(`Peak:\s+(-?[\d.]+|-inf)\s+dBFS`)
+)
+
+// MeasureLoudness runs ffmpeg's ebur128 filter and returns integrated
+// loudness and true peak of the file's audio
+func MeasureLoudness(ctx context.Context, path string) (*Loudness, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner", "-nostats",
+		"-i", path,
+		"-vn",
+		"-af", "ebur128=peak=true:framelog=verbose",
+		"-f", "null", "-",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg ebur128 failed: %v", err)
+	}
+
+	// Only the final summary holds the totals
+	out := string(output)
+	if i := strings.LastIndex(out, "Summary:"); i >= 0 {
+		out = out[i:]
+	}
+	m := ebuIntegratedRe.FindStringSubmatch(out)
+	if m == nil || m[1] == "-inf" {
+		return nil, fmt.Errorf("no integrated loudness in ebur128 output")
+	}
+	lufs, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	l := &Loudness{LUFS: lufs, GainDB: ReplayGainReference - lufs}
+	if pm := ebuPeakRe.FindStringSubmatch(out); pm != nil && pm[1] != "-inf" {
+		if dbfs, err := strconv.ParseFloat(pm[1], 64); err == nil {
+			peak := math.Pow(10, dbfs/20)
+			l.Peak = &peak
+		}
+	}
+	return l, nil
+}
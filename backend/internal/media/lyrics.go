@@ -0,0 +1,122 @@
+package media
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// LyricLine is one timed line of synced lyrics
+type LyricLine struct {
+	StartMs int    `json:"start_ms"`
+	Text    string `json:"text"`
+}
+
+var (
+	lrcTimeRe   = regexp.MustCompile(`\[(\d{1,3}):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	lrcOffsetRe = regexp.MustCompile(`(?i)^\[offset:\s*([+-]?\d+)\s*\]`)
+	lrcMetaRe   = regexp.MustCompile(`^\[[a-zA-Z]+:.*\]$`)
+)
+
+// IsLRC reports whether content contains LRC timestamps
+func IsLRC(content string) bool {
+	return lrcTimeRe.MatchString(content)
+}
+
+// ParseLRC parses LRC content into lines ordered by start time. Lines with
+// several timestamps ("[00:12.00][01:30.00]chorus") are repeated, and the
+// [offset:ms] tag is applied (positive offset shows lyrics earlier).
+func ParseLRC(content string) []LyricLine {
+	offset := 0
+	var lines []LyricLine
+	for _, raw := range strings.Split(content, "\n") {
+		raw = strings.TrimSpace(raw)
+		if m := lrcOffsetRe.FindStringSubmatch(raw); m != nil {
+			offset, _ = strconv.Atoi(m[1])
+			continue
+		}
+		stamps := lrcTimeRe.FindAllStringSubmatchIndex(raw, -1)
+		if len(stamps) == 0 || stamps[0][0] != 0 {
+			// Untimed line or [ar:]/[ti:] style metadata
+			continue
+		}
+		// Timestamps are a prefix; text follows the last consecutive one
+		end := 0
+		var starts []int
+		for _, loc := range stamps {
+			if loc[0] != end {
+				break
+			}
+			starts = append(starts, lrcStampMs(raw, loc))
+			end = loc[1]
+		}
+		text := strings.TrimSpace(raw[end:])
+		for _, st := range starts {
+			ms := st - offset
+			if ms < 0 {
+				ms = 0
+			}
+			lines = append(lines, LyricLine{StartMs: ms, Text: text})
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].StartMs < lines[j].StartMs })
+	return lines
+}
+
+func lrcStampMs(s string, loc []int) int {
+	min, _ := strconv.Atoi(s[loc[2]:loc[3]])
+	sec, _ := strconv.Atoi(s[loc[4]:loc[5]])
+	frac := 0
+	if loc[6] >= 0 {
+		f := s[loc[6]:loc[7]]
+		frac, _ = strconv.Atoi(f)
+		// ".5" = 500ms, ".05" = 50ms, ".005" = 5ms
+		for i := len(f); i < 3; i++ {
+			frac *= 10
+		}
+	}
+	return (min*60+sec)*1000 + frac
+}
+
+// PlainLyrics strips LRC timestamps and metadata tags, leaving the text
+func PlainLyrics(content string) string {
+	if !IsLRC(content) {
+		return strings.TrimSpace(content)
+	}
+	var out []string
+	for _, raw := range strings.Split(content, "\n") {
+		raw = strings.TrimSpace(raw)
+		if lrcMetaRe.MatchString(raw) && !lrcTimeRe.MatchString(raw) {
+			continue
+		}
+		out = append(out, strings.TrimSpace(lrcTimeRe.ReplaceAllString(raw, "")))
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// FormatLRC renders timed lines back to LRC text
+func FormatLRC(lines []LyricLine) string {
+	var b strings.Builder
+	for _, l := range lines {
+		fmt.Fprintf(&b, "[%02d:%02d.%02d]%s\n", l.StartMs/60000, (l.StartMs/1000)%60, (l.StartMs%1000)/10, l.Text)
+	}
+	return b.String()
+}
+
+// DecodeLyricsFile turns sidecar bytes into text: UTF-8 (with or without BOM),
+// UTF-16 with BOM, otherwise Latin-1 which is common for old .lrc files
+func DecodeLyricsFile(b []byte) string {
+	switch {
+	case len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF:
+		b = b[3:]
+	case len(b) >= 2 && (b[0] == 0xFF && b[1] == 0xFE || b[0] == 0xFE && b[1] == 0xFF):
+		return decodeUTF16(b)
+	}
+	if utf8.Valid(b) {
+		return strings.ReplaceAll(string(b), "\r\n", "\n")
+	}
+	return strings.ReplaceAll(decodeLatin1(b), "\r\n", "\n")
+}
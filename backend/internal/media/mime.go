@@ -0,0 +1,125 @@
+package media
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// MIME types by file extension. http.ServeContent falls back to
+// mime.TypeByExtension, which knows none of mkv, m2ts, flac, opus or heic
+// on most systems.
+var mimeByExt = map[string]string{
+	// video
+	"mp4":  "video/mp4",
+	"m4v":  "video/mp4",
+	"mkv":  "video/x-matroska",
+	"webm": "video/webm",
+	"mov":  "video/quicktime",
+	"avi":  "video/x-msvideo",
+	"ts":   "video/mp2t",
+	"m2ts": "video/mp2t",
+	"mts":  "video/mp2t",
+	"mpg":  "video/mpeg",
+	"mpeg": "video/mpeg",
+	"3gp":  "video/3gpp",
+	"ogv":  "video/ogg",
+	"wmv":  "video/x-ms-wmv",
+	"flv":  "video/x-flv",
+	// audio
+	"mp3":  "audio/mpeg",
+	"m4a":  "audio/mp4",
+	"m4b":  "audio/mp4",
+	"alac": "audio/mp4",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"ogg":  "audio/ogg",
+	"oga":  "audio/ogg",
+	"opus": "audio/ogg",
+	"wav":  "audio/wav",
+	"aiff": "audio/aiff",
+	"aif":  "audio/aiff",
+	"wma":  "audio/x-ms-wma",
+	"ape":  "audio/x-ape",
+	"wv":   "audio/x-wavpack",
+	"dsf":  "audio/x-dsf",
+	// photo
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"avif": "image/avif",
+	"heic": "image/heic",
+	"heif": "image/heif",
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"bmp":  "image/bmp",
+	"dng":  "image/x-adobe-dng",
+	"cr2":  "image/x-canon-cr2",
+	"nef":  "image/x-nikon-nef",
+	"arw":  "image/x-sony-arw",
+}
+
+// MIME types by normalized container, for files whose extension is unknown
+var mimeByContainer = map[string]map[string]string{
+	"video": {"mp4": "video/mp4", "mov": "video/quicktime", "mkv": "video/x-matroska", "webm": "video/webm",
+		"ts": "video/mp2t", "avi": "video/x-msvideo", "mpeg": "video/mpeg", "ogg": "video/ogg", "asf": "video/x-ms-wmv", "flv": "video/x-flv"},
+	"audio": {"mp4": "audio/mp4", "mp3": "audio/mpeg", "flac": "audio/flac", "ogg": "audio/ogg", "webm": "audio/webm",
+		"wav": "audio/wav", "aiff": "audio/aiff", "aac": "audio/aac", "asf": "audio/x-ms-wma", "mkv": "audio/x-matroska"},
+}
+
+// ContentType returns the MIME type of a media file from its extension,
+// then from its probed container, then a generic type for its kind
+func ContentType(kind, path, container string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if t, ok := mimeByExt[ext]; ok {
+		// An audio-only .mp4/.webm/.mkv is still served with the audio type
+		if kind == "audio" && strings.HasPrefix(t, "video/") {
+			if at := mimeByContainer["audio"][container]; at != "" {
+				return at
+			}
+		}
+		return t
+	}
+	if t := mimeByContainer[kind][container]; t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// NormalizeContainer maps an ffprobe format_name ("mov,mp4,m4a,3gp,3g2,mj2",
+// "matroska,webm") to a single container name, using the extension to pick
+// between the aliases
+func NormalizeContainer(formatName, path string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	names := strings.Split(strings.ToLower(formatName), ",")
+	has := func(n string) bool {
+		for _, name := range names {
+			if name == n {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("matroska"):
+		if ext == "webm" {
+			return "webm"
+		}
+		return "mkv"
+	case has("mov"):
+		if ext == "mov" || ext == "qt" {
+			return "mov"
+		}
+		return "mp4"
+	case has("mpegts"):
+		return "ts"
+	case has("mpeg"), has("mpegvideo"):
+		return "mpeg"
+	case has("aiff"):
+		return "aiff"
+	case len(names) > 0 && names[0] != "":
+		return names[0]
+	}
+	return ext
+}
@@ -0,0 +1,214 @@
+package media
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxNFOSize = 1 << 20
+
+// NFO holds the fields we use from a Kodi movie.nfo, tvshow.nfo or episode NFO.
+// Kind is the root element: "movie", "tvshow" or "episodedetails".
+type NFO struct {
+	Kind          string
+	Title         string
+	OriginalTitle string
+	SortTitle     string
+	ShowTitle     string // episodes only
+	Plot          string
+	Tagline       string
+	Year          int
+	Premiered     string
+	RuntimeMin    int
+	Season        int
+	Episode       int
+	Genres        []string
+	Studios       []string
+	MPAA          string
+	Rating        *float64
+	Actors        []NFOActor
+	ExternalIDs   map[string]string // imdb, tmdb, tvdb, ...
+}
+
+type NFOActor struct {
+	Name  string `json:"name"`
+	Role  string `json:"role,omitempty"`
+	Order int    `json:"order"`
+}
+
+// nfoXML mirrors the Kodi NFO schema; the three root types share their fields
+type nfoXML struct {
+	XMLName       xml.Name
+	Title         string   `xml:"title"`
+	OriginalTitle string   `xml:"originaltitle"`
+	SortTitle     string   `xml:"sorttitle"`
+	ShowTitle     string   `xml:"showtitle"`
+	Plot          string   `xml:"plot"`
+	Outline       string   `xml:"outline"`
+	Tagline       string   `xml:"tagline"`
+	Year          string   `xml:"year"`
+	Premiered     string   `xml:"premiered"`
+	Aired         string   `xml:"aired"`
+	Runtime       string   `xml:"runtime"`
+	Season        string   `xml:"season"`
+	Episode       string   `xml:"episode"`
+	Genres        []string `xml:"genre"`
+	Studios       []string `xml:"studio"`
+	MPAA          string   `xml:"mpaa"`
+	Rating        string   `xml:"rating"`
+	Ratings       []struct {
+		Name    string `xml:"name,attr"`
+		Default bool   `xml:"default,attr"`
+		Value   string `xml:"value"`
+	} `xml:"ratings>rating"`
+	Actors []struct {
+		Name  string `xml:"name"`
+		Role  string `xml:"role"`
+		Order string `xml:"order"`
+	} `xml:"actor"`
+	UniqueIDs []struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"uniqueid"`
+	ID     string `xml:"id"`
+	IMDbID string `xml:"imdbid"`
+	TMDbID string `xml:"tmdbid"`
+	TVDbID string `xml:"tvdbid"`
+}
+
+var imdbIDRe = regexp.MustCompile(`\btt\d{7,8}\b`)
+
+// ReadNFO parses a Kodi NFO file. Files that only contain a scraper URL
+// (allowed by Kodi) yield an NFO with just the IMDb ID when one is present.
+func ReadNFO(path string) (*NFO, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxNFOSize))
+	if err != nil {
+		return nil, err
+	}
+	return ParseNFO(b)
+}
+
+// ParseNFO parses NFO content; only the first root element is read, so
+// multi-episode files and trailing scraper URLs are tolerated
+func ParseNFO(b []byte) (*NFO, error) {
+	var raw nfoXML
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.Strict = false
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Kodi writes UTF-8; other declared charsets are read as-is
+		return input, nil
+	}
+	if err := dec.Decode(&raw); err != nil {
+		if id := imdbIDRe.Find(b); id != nil {
+			return &NFO{ExternalIDs: map[string]string{"imdb": string(id)}}, nil
+		}
+		return nil, err
+	}
+
+	n := &NFO{
+		Kind:          strings.ToLower(raw.XMLName.Local),
+		Title:         strings.TrimSpace(raw.Title),
+		OriginalTitle: strings.TrimSpace(raw.OriginalTitle),
+		SortTitle:     strings.TrimSpace(raw.SortTitle),
+		ShowTitle:     strings.TrimSpace(raw.ShowTitle),
+		Plot:          strings.TrimSpace(raw.Plot),
+		Tagline:       strings.TrimSpace(raw.Tagline),
+		Premiered:     strings.TrimSpace(raw.Premiered),
+		MPAA:          strings.TrimSpace(raw.MPAA),
+		ExternalIDs:   map[string]string{},
+	}
+	switch n.Kind {
+	case "movie", "tvshow", "episodedetails":
+	default:
+		return nil, errors.New("unsupported nfo root element: " + raw.XMLName.Local)
+	}
+
+	if n.Plot == "" {
+		n.Plot = strings.TrimSpace(raw.Outline)
+	}
+	if n.Premiered == "" {
+		n.Premiered = strings.TrimSpace(raw.Aired)
+	}
+	n.Year = parseYear(raw.Year)
+	if n.Year == 0 {
+		n.Year = parseYear(n.Premiered)
+	}
+	n.RuntimeMin, _ = strconv.Atoi(strings.TrimSpace(raw.Runtime))
+	n.Season, _ = strconv.Atoi(strings.TrimSpace(raw.Season))
+	n.Episode, _ = strconv.Atoi(strings.TrimSpace(raw.Episode))
+	n.Genres = splitNFOList(raw.Genres)
+	n.Studios = splitNFOList(raw.Studios)
+
+	// <ratings> (Kodi 17+) wins over the legacy <rating>; prefer the default one
+	for _, r := range raw.Ratings {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(r.Value), 64); err == nil && (n.Rating == nil || r.Default) {
+			n.Rating = &v
+		}
+	}
+	if n.Rating == nil {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(raw.Rating), 64); err == nil && v > 0 {
+			n.Rating = &v
+		}
+	}
+
+	for i, a := range raw.Actors {
+		name := strings.TrimSpace(a.Name)
+		if name == "" {
+			continue
+		}
+		order, err := strconv.Atoi(strings.TrimSpace(a.Order))
+		if err != nil {
+			order = i
+		}
+		n.Actors = append(n.Actors, NFOActor{Name: name, Role: strings.TrimSpace(a.Role), Order: order})
+	}
+
+	for _, u := range raw.UniqueIDs {
+		t, v := strings.ToLower(strings.TrimSpace(u.Type)), strings.TrimSpace(u.Value)
+		if t != "" && v != "" {
+			n.ExternalIDs[t] = v
+		}
+	}
+	legacy := map[string]string{"imdb": raw.IMDbID, "tmdb": raw.TMDbID, "tvdb": raw.TVDbID}
+	if id := strings.TrimSpace(raw.ID); id != "" {
+		// <id> is an IMDb ID for movies and a TVDB ID for shows
+		if imdbIDRe.MatchString(id) {
+			legacy["imdb"] = id
+		} else if n.Kind == "tvshow" {
+			legacy["tvdb"] = id
+		}
+	}
+	for t, v := range legacy {
+		if v = strings.TrimSpace(v); v != "" && n.ExternalIDs[t] == "" {
+			n.ExternalIDs[t] = v
+		}
+	}
+	return n, nil
+}
+
+// splitNFOList flattens repeated elements and "Drama / Crime" style values
+func splitNFOList(values []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, part := range strings.Split(v, "/") {
+			part = strings.TrimSpace(part)
+			if part != "" && !seen[strings.ToLower(part)] {
+				seen[strings.ToLower(part)] = true
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
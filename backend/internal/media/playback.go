@@ -0,0 +1,195 @@
+package media
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Playback methods, from cheapest to most expensive
+const (
+	DirectPlay = "direct_play" // serve the file as-is
+	Remux      = "remux"       // copy the streams into a container the client plays
+	Transcode  = "transcode"   // re-encode the video and/or audio
+)
+
+// StreamInfo is what the playback decision needs to know about a file
+type StreamInfo struct {
+	Kind          string
+	Path          string
+	Container     string
+	VideoCodec    string
+	VideoProfile  string
+	PixFmt        string
+	Height        int
+	AudioCodec    string
+	AudioChannels int
+	BitRate       int64
+}
+
+// StreamInfo summarizes probe output for the playback decision
+func (p *ProbeResult) StreamInfo(kind, path string) StreamInfo {
+	info := StreamInfo{Kind: kind, Path: path, Container: NormalizeContainer(p.Format.FormatName, path)}
+	if kind == "video" {
+		if v := p.FirstStream("video"); v != nil {
+			info.VideoCodec, info.VideoProfile, info.PixFmt, info.Height = v.CodecName, v.Profile, v.PixFmt, v.Height
+		}
+	}
+	if a := p.FirstStream("audio"); a != nil {
+		info.AudioCodec, info.AudioChannels = a.CodecName, a.Channels
+	}
+	info.BitRate, _ = strconv.ParseInt(p.Format.BitRate, 10, 64)
+	return info
+}
+
+// ClientProfile describes what a client can play. Empty sets mean "use the
+// default browser profile"; zero limits mean unlimited.
+type ClientProfile struct {
+	Containers       map[string]bool
+	VideoCodecs      map[string]bool
+	AudioCodecs      map[string]bool
+	ImageFormats     map[string]bool
+	MaxHeight        int
+	MaxBitrate       int64
+	MaxAudioChannels int
+}
+
+// What current Chrome/Firefox/Safari all play without plugins
+var (
+	defaultContainers   = []string{"mp4", "webm", "mp3", "ogg", "flac", "wav", "aac"}
+	defaultVideoCodecs  = []string{"h264", "vp8", "vp9", "av1"}
+	defaultAudioCodecs  = []string{"aac", "mp3", "opus", "vorbis", "flac", "pcm_s16le"}
+	defaultImageFormats = []string{"jpeg", "png", "gif", "webp", "avif", "bmp"}
+)
+
+// Client-side names for ffprobe codec and container names
+var codecAliases = map[string]string{
+	"avc": "h264", "x264": "h264", "h265": "hevc", "x265": "hevc", "hvc1": "hevc",
+	"mpeg2": "mpeg2video", "vp09": "vp9", "av01": "av1",
+	"mp4a": "aac", "mp3float": "mp3", "wav": "pcm_s16le",
+	"m4v": "mp4", "m4a": "mp4", "mkv": "mkv", "matroska": "mkv", "jpg": "jpeg",
+}
+
+// NormalizeCodec lower-cases a codec/container name and resolves aliases
+func NormalizeCodec(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if a, ok := codecAliases[name]; ok {
+		return a
+	}
+	return name
+}
+
+// ParseCodecList turns "h264,hevc" into a normalized set
+func ParseCodecList(v string) map[string]bool {
+	out := map[string]bool{}
+	for _, p := range strings.Split(v, ",") {
+		if p = NormalizeCodec(p); p != "" {
+			out[p] = true
+		}
+	}
+	return out
+}
+
+// WithDefaults fills the unset capability sets with the browser defaults
+func (p ClientProfile) WithDefaults() ClientProfile {
+	fill := func(set map[string]bool, defaults []string) map[string]bool {
+		if len(set) > 0 {
+			return set
+		}
+		out := map[string]bool{}
+		for _, d := range defaults {
+			out[d] = true
+		}
+		return out
+	}
+	p.Containers = fill(p.Containers, defaultContainers)
+	p.VideoCodecs = fill(p.VideoCodecs, defaultVideoCodecs)
+	p.AudioCodecs = fill(p.AudioCodecs, defaultAudioCodecs)
+	p.ImageFormats = fill(p.ImageFormats, defaultImageFormats)
+	return p
+}
+
+// PlaybackDecision says how a client should play a file and why
+type PlaybackDecision struct {
+	Method         string   `json:"method"`
+	TranscodeVideo bool     `json:"transcode_video"`
+	TranscodeAudio bool     `json:"transcode_audio"`
+	Reasons        []string `json:"reasons"`
+}
+
+// DecidePlayback picks the cheapest playback method the client supports
+func DecidePlayback(info StreamInfo, profile ClientProfile) PlaybackDecision {
+	p := profile.WithDefaults()
+	d := PlaybackDecision{Method: DirectPlay, Reasons: []string{}}
+
+	if info.Kind == "photo" {
+		format := NormalizeCodec(strings.TrimPrefix(strings.ToLower(filepath.Ext(info.Path)), "."))
+		if !p.ImageFormats[format] {
+			d.Method = Transcode
+			d.Reasons = append(d.Reasons, fmt.Sprintf("image format %s not supported", format))
+		}
+		return d
+	}
+
+	videoOK, audioOK := true, true
+	if info.Kind == "video" && info.VideoCodec != "" {
+		codec := NormalizeCodec(info.VideoCodec)
+		switch {
+		case !p.VideoCodecs[codec]:
+			videoOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("video codec %s not supported", codec))
+		case codec == "h264" && is10Bit(info) && !p.VideoCodecs["h264_10bit"]:
+			// Browsers decode 8-bit H.264 only
+			videoOK = false
+			d.Reasons = append(d.Reasons, "10-bit h264 not supported")
+		}
+		if p.MaxHeight > 0 && info.Height > p.MaxHeight {
+			videoOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("height %d above %d", info.Height, p.MaxHeight))
+		}
+	}
+	if info.AudioCodec != "" {
+		codec := NormalizeCodec(info.AudioCodec)
+		if !p.AudioCodecs[codec] {
+			audioOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("audio codec %s not supported", codec))
+		}
+		if p.MaxAudioChannels > 0 && info.AudioChannels > p.MaxAudioChannels {
+			audioOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%d audio channels above %d", info.AudioChannels, p.MaxAudioChannels))
+		}
+	}
+	if p.MaxBitrate > 0 && info.BitRate > p.MaxBitrate {
+		// Re-encoding the video is what brings the bitrate down
+		if info.Kind == "video" {
+			videoOK = false
+		} else {
+			audioOK = false
+		}
+		d.Reasons = append(d.Reasons, fmt.Sprintf("bitrate %d above %d", info.BitRate, p.MaxBitrate))
+	}
+
+	container := NormalizeCodec(info.Container)
+	containerOK := p.Containers[container]
+	if !containerOK {
+		d.Reasons = append(d.Reasons, fmt.Sprintf("container %s not supported", container))
+	}
+
+	switch {
+	case !videoOK || !audioOK:
+		d.Method = Transcode
+		d.TranscodeVideo = !videoOK
+		d.TranscodeAudio = !audioOK
+	case !containerOK && p.Containers["mp4"]:
+		// Remuxing targets fragmented MP4
+		d.Method = Remux
+	case !containerOK:
+		d.Method = Transcode
+	}
+	return d
+}
+
+func is10Bit(info StreamInfo) bool {
+	return strings.Contains(info.PixFmt, "10") || strings.Contains(strings.ToLower(info.VideoProfile), "high 10")
+}
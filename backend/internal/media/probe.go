@@ -0,0 +1,123 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Stream is a single stream as reported by ffprobe
+type Stream struct {
+	Index       int               `json:"index"`
+	CodecType   string            `json:"codec_type"`
+	CodecName   string            `json:"codec_name"`
+	Profile     string            `json:"profile"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	PixFmt      string            `json:"pix_fmt"`
+	Channels    int               `json:"channels"`
+	SampleRate  string            `json:"sample_rate"`
+	BitRate     string            `json:"bit_rate"`
+	Disposition map[string]int    `json:"disposition"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// Format is the container section of ffprobe output
+type Format struct {
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// ProbeResult is the parsed output of `ffprobe -show_format -show_streams`
+type ProbeResult struct {
+	Format  Format   `json:"format"`
+	Streams []Stream `json:"streams"`
+}
+
+// Probe runs ffprobe on a file and returns its container, streams and tags
+func Probe(ctx context.Context, path string) (*ProbeResult, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	var res ProbeResult
+	if err := json.Unmarshal(output, &res); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %w", err)
+	}
+	return &res, nil
+}
+
+// DurationMs returns the container duration in milliseconds, or 0 if unknown
+func (p *ProbeResult) DurationMs() int {
+	d, err := strconv.ParseFloat(strings.TrimSpace(p.Format.Duration), 64)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return int(d * 1000)
+}
+
+// FirstStream returns the first stream of the given type ("video", "audio", "subtitle")
+// ignoring attached pictures (cover art is exposed as a video stream).
+func (p *ProbeResult) FirstStream(codecType string) *Stream {
+	for i := range p.Streams {
+		st := &p.Streams[i]
+		if st.CodecType != codecType {
+			continue
+		}
+		if st.Disposition["attached_pic"] == 1 {
+			continue
+		}
+		return st
+	}
+	return nil
+}
+
+// Tag looks up the first non-empty tag among keys, case-insensitively.
+// Container tags win over stream tags; Ogg/Opus files keep their
+// Vorbis comments on the audio stream, so those are searched too.
+func (p *ProbeResult) Tag(keys ...string) string {
+	sources := []map[string]string{p.Format.Tags}
+	if st := p.FirstStream("audio"); st != nil {
+		sources = append(sources, st.Tags)
+	}
+	for _, key := range keys {
+		for _, tags := range sources {
+			for k, v := range tags {
+				if strings.EqualFold(k, key) && strings.TrimSpace(v) != "" {
+					return strings.TrimSpace(v)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// TagWithPrefix returns the first tag whose key starts with prefix
+// (case-insensitively), e.g. "lyrics" matches ID3 "lyrics-eng"
+func (p *ProbeResult) TagWithPrefix(prefix string) (string, string) {
+	sources := []map[string]string{p.Format.Tags}
+	if st := p.FirstStream("audio"); st != nil {
+		sources = append(sources, st.Tags)
+	}
+	prefix = strings.ToLower(prefix)
+	for _, tags := range sources {
+		for k, v := range tags {
+			if strings.HasPrefix(strings.ToLower(k), prefix) && strings.TrimSpace(v) != "" {
+				return k, v
+			}
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,113 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// TextSubtitleCodecs are the ffprobe codec names of subtitle streams that can
+// be converted to WebVTT; bitmap formats (PGS, VobSub, DVB) cannot
+var TextSubtitleCodecs = map[string]bool{
+	"subrip": true, "srt": true, "ass": true, "ssa": true,
+	"webvtt": true, "mov_text": true, "text": true,
+}
+
+// SubtitleExts are the sidecar extensions linked to videos
+var SubtitleExts = map[string]bool{"srt": true, "ass": true, "ssa": true, "vtt": true}
+
+// SubtitleSuffix holds what the tokens between the video name and the
+// extension say, e.g. "Movie.en.forced.srt" -> {Lang: "en", Forced: true}
+type SubtitleSuffix struct {
+	Lang   string
+	Title  string
+	Forced bool
+	SDH    bool
+}
+
+// Common language names used instead of codes in subtitle file names
+var subtitleLangNames = map[string]string{
+	"english": "en", "french": "fr", "francais": "fr", "français": "fr", "german": "de", "deutsch": "de",
+	"spanish": "es", "espanol": "es", "español": "es", "italian": "it", "italiano": "it",
+	"portuguese": "pt", "dutch": "nl", "swedish": "sv", "norwegian": "no", "danish": "da",
+	"finnish": "fi", "polish": "pl", "russian": "ru", "japanese": "ja", "chinese": "zh",
+	"korean": "ko", "arabic": "ar", "greek": "el", "turkish": "tr", "hebrew": "he",
+}
+
+var langCodeRe = regexp.MustCompile(`^[a-z]{2,3}(?:[-_][a-z]{2,4})?$`)
+
+// ParseSubtitleSuffix interprets the dot-separated tokens of a sidecar name
+// after the video base name ("en", "eng", "English", "forced", "sdh", "cc")
+func ParseSubtitleSuffix(tokens []string) SubtitleSuffix {
+	var s SubtitleSuffix
+	var rest []string
+	for _, t := range tokens {
+		lt := strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case lt == "":
+		case lt == "forced" || lt == "foreign":
+			s.Forced = true
+		case lt == "sdh" || lt == "cc" || lt == "hi":
+			s.SDH = true
+		case lt == "default":
+		case s.Lang == "" && subtitleLangNames[lt] != "":
+			s.Lang = subtitleLangNames[lt]
+		case s.Lang == "" && langCodeRe.MatchString(lt):
+			s.Lang = strings.ReplaceAll(lt, "_", "-")
+		default:
+			rest = append(rest, t)
+		}
+	}
+	s.Title = strings.Join(rest, " ")
+	return s
+}
+
+var srtTimeRe = regexp.MustCompile(`(\d{1,2}:\d{2}:\d{2}),(\d{3})`)
+
+// SRTToVTT converts SubRip text to WebVTT: adds the header and switches the
+// millisecond separator; cue numbers are valid VTT cue identifiers
+func SRTToVTT(srt string) string {
+	srt = strings.ReplaceAll(strings.TrimPrefix(srt, "\uFEFF"), "\r\n", "\n")
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, line := range strings.Split(srt, "\n") {
+		if strings.Contains(line, "-->") {
+			line = srtTimeRe.ReplaceAllString(line, "$1.$2")
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// NormalizeVTT makes sure a .vtt sidecar starts with the WEBVTT header
+func NormalizeVTT(vtt string) string {
+	vtt = strings.ReplaceAll(strings.TrimPrefix(vtt, "\uFEFF"), "\r\n", "\n")
+	if !strings.HasPrefix(vtt, "WEBVTT") {
+		vtt = "WEBVTT\n\n" + vtt
+	}
+	return vtt
+}
+
+// ConvertToVTT converts a subtitle stream with ffmpeg. For a sidecar file
+// pass streamIndex -1; for an embedded track pass its ffprobe stream index.
+func ConvertToVTT(ctx context.Context, src string, streamIndex int, w io.Writer) error {
+	args := []string{"-v", "error", "-i", src}
+	if streamIndex >= 0 {
+		args = append(args, "-map", fmt.Sprintf("0:%d", streamIndex))
+	}
+	args = append(args, "-f", "webvtt", "pipe:1")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v, output: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package media
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AudioTags holds the normalized tags of an audio file.
+// ffprobe already unifies ID3v2, Vorbis comments (FLAC/Ogg/Opus) and MP4 atoms
+// into a flat key/value map; this only resolves the key aliases between them.
+type AudioTags struct {
+	Title       string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	TrackNo     int
+	TrackTotal  int
+	DiscNo      int
+	DiscTotal   int
+	Year        int
+	Genre       string
+	DurationMs  int
+}
+
+// ParseAudioTags extracts audio tags from probe output, falling back to the
+// file name for the title and to "Unknown Artist"/"Unknown Album" otherwise.
+func ParseAudioTags(p *ProbeResult, path string) AudioTags {
+	t := AudioTags{
+		Title:       p.Tag("title"),
+		Artist:      p.Tag("artist", "performer"),
+		AlbumArtist: p.Tag("album_artist", "albumartist", "album artist"),
+		Album:       p.Tag("album"),
+		Genre:       p.Tag("genre"),
+		DurationMs:  p.DurationMs(),
+	}
+	t.TrackNo, t.TrackTotal = parseNumberPair(p.Tag("track", "tracknumber", "trkn"))
+	if t.TrackTotal == 0 {
+		t.TrackTotal, _ = strconv.Atoi(p.Tag("tracktotal", "totaltracks"))
+	}
+	t.DiscNo, t.DiscTotal = parseNumberPair(p.Tag("disc", "discnumber", "disk"))
+	if t.DiscTotal == 0 {
+		t.DiscTotal, _ = strconv.Atoi(p.Tag("disctotal", "totaldiscs"))
+	}
+	t.Year = parseYear(p.Tag("date", "year", "originaldate", "tdrc", "tyer"))
+
+	// Multi-value genres come through as "Rock;Pop" or "Rock / Pop"; keep the first
+	if i := strings.IndexAny(t.Genre, ";/"); i > 0 {
+		t.Genre = strings.TrimSpace(t.Genre[:i])
+	}
+
+	if t.Title == "" {
+		base := filepath.Base(path)
+		t.Title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if t.Artist == "" {
+		t.Artist = t.AlbumArtist
+	}
+	if t.Artist == "" {
+		t.Artist = "Unknown Artist"
+	}
+	if t.AlbumArtist == "" {
+		t.AlbumArtist = t.Artist
+	}
+	if t.Album == "" {
+		t.Album = "Unknown Album"
+	}
+	return t
+}
+
+// parseNumberPair parses "3", "3/12" or "03 of 12" style values
+func parseNumberPair(v string) (int, int) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, 0
+	}
+	sep := strings.IndexAny(v, "/ ")
+	if sep < 0 {
+		n, _ := strconv.Atoi(v)
+		return n, 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(v[:sep]))
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(v[sep:]), "/"))
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "of"))
+	total, _ := strconv.Atoi(rest)
+	return n, total
+}
+
+// parseYear extracts the year from "2019", "2019-05-01" or "2019-05-01T00:00:00Z"
+func parseYear(v string) int {
+	v = strings.TrimSpace(v)
+	if len(v) < 4 {
+		return 0
+	}
+	y, err := strconv.Atoi(v[:4])
+	if err != nil || y < 1000 || y > 9999 {
+		return 0
+	}
+	return y
+}
+
+// EmbeddedLyrics holds lyrics found inside an audio file
+type EmbeddedLyrics struct {
+	Content string // LRC text when Synced, plain text otherwise
+	Synced  bool
+	Lang    string
+}
+
+// ReadEmbeddedLyrics looks for SYLT (synced, ID3 only) first, then the
+// USLT/LYRICS/©lyr text that ffprobe reports as a "lyrics" tag
+func ReadEmbeddedLyrics(p *ProbeResult, path string) *EmbeddedLyrics {
+	if lines, lang, err := ReadSYLT(path); err == nil && len(lines) > 0 {
+		return &EmbeddedLyrics{Content: FormatLRC(lines), Synced: true, Lang: strings.TrimSpace(lang)}
+	}
+
+	key, text := p.TagWithPrefix("lyrics")
+	if text == "" {
+		text = p.Tag("unsyncedlyrics", "uslt")
+	}
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return nil
+	}
+	lang := ""
+	// ID3 USLT comes through as "lyrics-<lang>"
+	if i := strings.IndexByte(key, '-'); i > 0 {
+		lang = key[i+1:]
+	}
+	return &EmbeddedLyrics{Content: text, Synced: IsLRC(text), Lang: lang}
+}
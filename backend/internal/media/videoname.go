@@ -0,0 +1,149 @@
+package media
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VideoInfo is what can be derived from a video's library-relative path
+type VideoInfo struct {
+	Kind string // "episode", "movie" or "" when unrecognized
+
+	// Episodes
+	Series       string
+	SeriesYear   int
+	Season       int
+	Episode      int
+	EpisodeEnd   int // last episode of multi-episode files (S01E01E02), 0 otherwise
+	EpisodeTitle string
+
+	// Movies
+	Title string
+	Year  int
+}
+
+var (
+	// Show.Name.S02E05, S02E05E06, S02E05-E06, s2e5
+	seasonEpisodeRe = regexp.MustCompile(`(?i)(?:^|[\s._\-\[(])s(\d{1,3})[\s._\-]?e(\d{1,4})(?:(?:[\s._\-]?e|-)(\d{1,4}))?`)
+	// Show.Name.2x05
+	crossEpisodeRe = regexp.MustCompile(`(?i)(?:^|[\s._\-\[(])(\d{1,2})x(\d{2,3})(?:[\s._\-\])]|$)`)
+	// "Season 2", "Season.02", "S02", "Series 3", "Staffel 2", "Saison 2", "Temporada 2"
+	seasonDirRe = regexp.MustCompile(`(?i)^(?:season|series|staffel|saison|temporada|stagione)?[\s._\-]*s?(\d{1,3})$`)
+	// "05 - Title", "E05 Title", "Episode 5 - Title", "05.Title"
+	episodeFileRe = regexp.MustCompile(`(?i)^(?:episode|ep|e)?[\s._\-]*(\d{1,3})(?:[\s._\-]+(.*))?$`)
+	// "(2019)", "[2019]", ".2019."
+	yearRe = regexp.MustCompile(`(?:^|[\s._\-\[(])((?:19|20)\d{2})(?:[\s._\-\])]|$)`)
+	// Release noise: everything from the first of these tokens on is dropped
+	releaseNoiseRe = regexp.MustCompile(`(?i)[\s._\-\[(](?:2160p|1080p|1080i|720p|576p|480p|4k|uhd|hdr10?|dv|bluray|blu-ray|bdrip|brrip|web-?dl|webrip|web|hdtv|dvdrip|dvd|remux|x264|x265|h\.?264|h\.?265|hevc|avc|aac|ac3|dts|atmos|truehd|proper|repack|extended|unrated|multi|vostfr|subbed)(?:[\s._\-\])]|$)`)
+)
+
+// ParseVideoPath recognizes TV episodes and movies from names such as
+// "Show.Name.S02E05.1080p.mkv", "Show/Season 2/05 - Title.mkv" and
+// "Movie Title (2019)/movie.mkv"
+func ParseVideoPath(relPath string) VideoInfo {
+	relPath = strings.ReplaceAll(relPath, "\\", "/")
+	base := path.Base(relPath)
+	name := strings.TrimSuffix(base, path.Ext(base))
+	dirs := strings.Split(path.Dir(relPath), "/")
+	if len(dirs) == 1 && dirs[0] == "." {
+		dirs = nil
+	}
+
+	// 1. SxxEyy / NxNN in the file name
+	if m := seasonEpisodeRe.FindStringSubmatchIndex(name); m != nil {
+		info := VideoInfo{Kind: "episode"}
+		info.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		info.Episode, _ = strconv.Atoi(name[m[4]:m[5]])
+		if m[6] >= 0 {
+			info.EpisodeEnd, _ = strconv.Atoi(name[m[6]:m[7]])
+		}
+		info.Series, info.SeriesYear = cleanTitleYear(name[:m[0]])
+		info.EpisodeTitle, _ = cleanTitleYear(name[m[1]:])
+		fillSeriesFromDirs(&info, dirs)
+		return info
+	}
+	if m := crossEpisodeRe.FindStringSubmatchIndex(name); m != nil {
+		info := VideoInfo{Kind: "episode"}
+		info.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		info.Episode, _ = strconv.Atoi(name[m[4]:m[5]])
+		info.Series, info.SeriesYear = cleanTitleYear(name[:m[0]])
+		info.EpisodeTitle, _ = cleanTitleYear(name[m[1]:])
+		fillSeriesFromDirs(&info, dirs)
+		return info
+	}
+
+	// 2. Show/Season 2/05 - Title.mkv
+	if len(dirs) >= 2 {
+		if sm := seasonDirRe.FindStringSubmatch(strings.TrimSpace(dirs[len(dirs)-1])); sm != nil && isSeasonDir(dirs[len(dirs)-1]) {
+			if em := episodeFileRe.FindStringSubmatch(strings.TrimSpace(name)); em != nil {
+				info := VideoInfo{Kind: "episode"}
+				info.Season, _ = strconv.Atoi(sm[1])
+				info.Episode, _ = strconv.Atoi(em[1])
+				info.EpisodeTitle, _ = cleanTitleYear(em[2])
+				info.Series, info.SeriesYear = cleanTitleYear(dirs[len(dirs)-2])
+				return info
+			}
+		}
+	}
+
+	// 3. Movies: a year in the file name, else in the parent folder
+	if title, year := cleanTitleYear(name); year > 0 && title != "" {
+		return VideoInfo{Kind: "movie", Title: title, Year: year}
+	}
+	if len(dirs) > 0 {
+		if title, year := cleanTitleYear(dirs[len(dirs)-1]); year > 0 && title != "" {
+			return VideoInfo{Kind: "movie", Title: title, Year: year}
+		}
+	}
+	return VideoInfo{}
+}
+
+// isSeasonDir accepts "Season 2" / "S02" / "Staffel 2" style folders but
+// not arbitrary numbered folders such as "2019"
+func isSeasonDir(dir string) bool {
+	d := strings.ToLower(strings.TrimSpace(dir))
+	if d == "" || (d[0] >= '0' && d[0] <= '9') {
+		return false
+	}
+	return true
+}
+
+// fillSeriesFromDirs takes the series name from the folders when the file
+// name has none ("Show/Season 1/S01E01.mkv"), skipping season folders
+func fillSeriesFromDirs(info *VideoInfo, dirs []string) {
+	if info.Series != "" {
+		return
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if seasonDirRe.MatchString(strings.TrimSpace(dirs[i])) && isSeasonDir(dirs[i]) {
+			continue
+		}
+		info.Series, info.SeriesYear = cleanTitleYear(dirs[i])
+		if info.Series != "" {
+			return
+		}
+	}
+}
+
+// cleanTitleYear turns "Movie.Title.2019.1080p.BluRay" into ("Movie Title", 2019)
+func cleanTitleYear(s string) (string, int) {
+	s = " " + s // noise/year patterns expect a leading separator
+	if loc := releaseNoiseRe.FindStringIndex(s); loc != nil {
+		s = s[:loc[0]]
+	}
+	year := 0
+	// The last year-like token is the release year ("2001 A Space Odyssey 1968")
+	if all := yearRe.FindAllStringSubmatchIndex(s, -1); len(all) > 0 {
+		m := all[len(all)-1]
+		if m[2] > 1 || len(all) == 1 && m[3] < len(s) {
+			year, _ = strconv.Atoi(s[m[2]:m[3]])
+			s = s[:m[0]]
+		}
+	}
+	s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.Trim(s, " -–([{")
+	return s, year
+}
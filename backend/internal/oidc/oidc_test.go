@@ -0,0 +1,225 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIssuer is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that only redeems codes with the PKCE verifier of their challenge
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]string // code -> code_challenge
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{key: key, codes: map[string]string{}}
+	mux := http.NewServeMux()
+	discovery := func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.URL,
+			"authorization_endpoint": iss.URL + "/authorize",
+			"token_endpoint":         iss.URL + "/token",
+			"jwks_uri":               iss.URL + "/jwks",
+		})
+	}
+	mux.HandleFunc("/.well-known/openid-configuration", discovery)
+	// Served under another path, as a misconfigured OIDC_ISSUER would reach it
+	mux.HandleFunc("/realms/other/.well-known/openid-configuration", discovery)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		want, ok := iss.codes[r.FormValue("code")]
+		delete(iss.codes, r.FormValue("code"))
+		iss.mu.Unlock()
+		if !ok || challenge(r.FormValue("code_verifier")) != want {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": iss.idToken(t, nil)})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// idToken signs an ID token for client "mediahub"; edit changes its claims
+func (iss *testIssuer) idToken(t *testing.T, edit func(jwt.MapClaims)) string {
+	t.Helper()
+	c := jwt.MapClaims{
+		"iss":                iss.URL,
+		"aud":                "mediahub",
+		"sub":                "subject-1",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              "nonce-1",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             []string{"media", "admins"},
+	}
+	if edit != nil {
+		edit(c)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	tok.Header["kid"] = "test"
+	raw, err := tok.SignedString(iss.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// authorize plays the login at the issuer: it records the challenge of the
+// authorization URL under a new code
+func (iss *testIssuer) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := NewVerifier()
+	iss.mu.Lock()
+	iss.codes[code] = u.Query().Get("code_challenge")
+	iss.mu.Unlock()
+	return code
+}
+
+func (iss *testIssuer) provider() *Provider {
+	return New(Config{Issuer: iss.URL + "/", ClientID: "mediahub", RedirectURL: "http://app/api/auth/oidc/callback"})
+}
+
+func TestDiscovery(t *testing.T) {
+	iss := newTestIssuer(t)
+	ctx := context.Background()
+
+	authURL, err := iss.provider().AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if got := u.Scheme + "://" + u.Host + u.Path; got != iss.URL+"/authorize" {
+		t.Errorf("authorization endpoint = %s", got)
+	}
+	q := u.Query()
+	for k, want := range map[string]string{
+		"response_type": "code", "client_id": "mediahub", "state": "state-1", "nonce": "nonce-1",
+		"scope": "openid profile email", "code_challenge_method": "S256",
+	} {
+		if q.Get(k) != want {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), want)
+		}
+	}
+
+	// The document must be about the configured issuer
+	other := New(Config{Issuer: iss.URL + "/realms/other", ClientID: "mediahub"})
+	if _, err := other.AuthCodeURL(ctx, "s", "n", "v"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("issuer mismatch: err = %v", err)
+	}
+	down := New(Config{Issuer: "http://127.0.0.1:1", ClientID: "mediahub"})
+	if _, err := down.AuthCodeURL(ctx, "s", "n", "v"); err == nil {
+		t.Error("unreachable issuer: no error")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	if got := challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("challenge = %s", got)
+	}
+	if a, b := NewVerifier(), NewVerifier(); a == b || len(a) < 43 {
+		t.Errorf("verifiers %q and %q", a, b)
+	}
+
+	iss := newTestIssuer(t)
+	p := iss.provider()
+	ctx := context.Background()
+	verifier := NewVerifier()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Exchange(ctx, iss.authorize(t, authURL), NewVerifier(), "nonce-1"); err == nil {
+		t.Error("exchange with another verifier succeeded")
+	}
+	claims, err := p.Exchange(ctx, iss.authorize(t, authURL), verifier, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "subject-1" || claims.PreferredUsername != "alice" || claims.Email != "alice@example.com" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if strings.Join(claims.Groups, ",") != "media,admins" {
+		t.Errorf("groups = %v", claims.Groups)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	iss := newTestIssuer(t)
+	p := iss.provider()
+	ctx := context.Background()
+	if _, err := p.AuthCodeURL(ctx, "s", "n", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.verify(ctx, iss.idToken(t, nil), "nonce-1"); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": iss.URL, "aud": "mediahub", "sub": "subject-1", "nonce": "nonce-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	forged.Header["kid"] = "test"
+	forgedRaw, _ := forged.SignedString(other)
+
+	for _, tc := range []struct {
+		name  string
+		raw   string
+		nonce string
+	}{
+		{"nonce", iss.idToken(t, nil), "nonce-2"},
+		{"missing nonce", iss.idToken(t, func(c jwt.MapClaims) { delete(c, "nonce") }), "nonce-1"},
+		{"audience", iss.idToken(t, func(c jwt.MapClaims) { c["aud"] = "another-client" }), "nonce-1"},
+		{"issuer", iss.idToken(t, func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }), "nonce-1"},
+		{"expired", iss.idToken(t, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), "nonce-1"},
+		{"no expiry", iss.idToken(t, func(c jwt.MapClaims) { delete(c, "exp") }), "nonce-1"},
+		{"no subject", iss.idToken(t, func(c jwt.MapClaims) { delete(c, "sub") }), "nonce-1"},
+		{"signature", forgedRaw, "nonce-1"},
+	} {
+		if _, err := p.verify(ctx, tc.raw, tc.nonce); err == nil {
+			t.Errorf("%s: token accepted", tc.name)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Package realip sets the client address of requests that come through a
+// trusted reverse proxy from its X-Forwarded-For or X-Real-IP header. Other
+// requests keep their peer address: anyone can send those headers, and the
+// login throttle and audit log must not take their word for it.
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type Policy struct {
+	trusted []netip.Prefix
+}
+
+// New parses the trusted proxies, single addresses ("10.0.0.2") or ranges
+// ("172.16.0.0/12")
+func New(proxies []string) (*Policy, error) {
+	p := &Policy{}
+	for _, s := range proxies {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			p.trusted = append(p.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		p.trusted = append(p.trusted, prefix.Masked())
+	}
+	return p, nil
+}
+
+// Trusted reports whether an address is one of the trusted proxies
+func (p *Policy) Trusted(ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address of a request: for a trusted peer, the
+// last X-Forwarded-For hop that isn't a trusted proxy itself (earlier hops are
+// the client's to forge), else X-Real-IP; otherwise the peer address
+func (p *Policy) ClientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !p.Trusted(peer) {
+		return peer
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			if !p.Trusted(hop) || i == 0 {
+				return hop
+			}
+		}
+	}
+	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
+		if _, err := netip.ParseAddr(xrip); err == nil {
+			return xrip
+		}
+	}
+	return peer
+}
+
+// Handler replaces the RemoteAddr of requests with their client address
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := p.ClientIP(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package scan
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/example/mediahub/internal/media"
+)
+
+const maxLyricsFileSize = 512 << 10
+
+// lyricsExts are sidecar extensions linked to the audio file with the same
+// base name; .lrc wins over .txt when both exist
+var lyricsExts = map[string]int{"lrc": 2, "txt": 1}
+
+// sidecarKey identifies a file by directory + base name without extension
+func sidecarKey(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// addLyricsSidecar records a lyrics sidecar found during the walk, keeping the preferred one
+func addLyricsSidecar(sidecars map[string]string, path, ext string) {
+	key := sidecarKey(path)
+	if cur, ok := sidecars[key]; ok {
+		curExt := strings.TrimPrefix(strings.ToLower(filepath.Ext(cur)), ".")
+		if lyricsExts[curExt] >= lyricsExts[ext] {
+			return
+		}
+	}
+	sidecars[key] = path
+}
+
+// linkLyrics stores sidecar lyrics for the audio items seen in this walk,
+// re-reading a sidecar only when its mtime changed, and drops sidecar lyrics
+// whose file disappeared
+func (s *Scanner) linkLyrics(ctx context.Context, audio map[string]int64, sidecars map[string]string) {
+	var orphaned []int64
+	for key, itemID := range audio {
+		sidecar, ok := sidecars[key]
+		if !ok {
+			orphaned = append(orphaned, itemID)
+			continue
+		}
+		info, err := os.Stat(sidecar)
+		if err != nil {
+			continue
+		}
+		mtime := info.ModTime().UTC()
+
+		var unchanged bool
+		_ = s.DB.QueryRow(ctx, `
+			select exists(select 1 from lyrics where item_id=$1 and source='sidecar' and source_path=$2 and source_mtime=$3)
+		`, itemID, sidecar, mtime).Scan(&unchanged)
+		if unchanged {
+			continue
+		}
+
+		content, err := readLyricsFile(sidecar)
+		if err != nil || strings.TrimSpace(content) == "" {
+			continue
+		}
+		synced := media.IsLRC(content)
+		_, _ = s.DB.Exec(ctx, `
+			insert into lyrics(item_id, source, synced, content, source_path, source_mtime, updated_at)
+			values ($1,'sidecar',$2,$3,$4,$5,now())
+			on conflict (item_id, source) do update set
+				synced=excluded.synced,
+				content=excluded.content,
+				source_path=excluded.source_path,
+				source_mtime=excluded.source_mtime,
+				updated_at=now()
+		`, itemID, synced, content, sidecar, mtime)
+	}
+
+	if len(orphaned) > 0 {
+		_, _ = s.DB.Exec(ctx, "delete from lyrics where source='sidecar' and item_id = any($1)", orphaned)
+	}
+}
+
+func readLyricsFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxLyricsFileSize))
+	if err != nil {
+		return "", err
+	}
+	return media.DecodeLyricsFile(b), nil
+}
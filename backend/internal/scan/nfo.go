@@ -0,0 +1,222 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kodi-style video sidecars. NFO files and artwork next to videos are not
+// indexed as items; they are linked to the video after the walk and stored
+// in item_sidecar. A changed NFO re-queues metadata, changed artwork the thumb.
+
+var artworkExts = map[string]bool{"jpg": true, "jpeg": true, "png": true, "tbn": true}
+
+// Folder-level artwork names, and the "<video>-poster.jpg" style suffixes
+var (
+	folderPosterNames = []string{"poster", "folder", "cover"}
+	folderFanartNames = []string{"fanart", "backdrop"}
+	artworkNames      = map[string]bool{
+		"poster": true, "folder": true, "cover": true, "fanart": true, "backdrop": true,
+		"banner": true, "landscape": true, "clearlogo": true, "clearart": true, "discart": true,
+		"logo": true, "thumb": true,
+	}
+	artworkSuffixes = []string{"-poster", "-fanart", "-thumb", "-banner", "-landscape", "-clearlogo", "-clearart", "-discart"}
+)
+
+// videoSidecars collects what the walk found for one library root
+type videoSidecars struct {
+	videos  map[string]int64  // video path -> item id
+	subs    []string          // subtitle sidecars, matched in linkSubtitles
+	files   map[string]string // lower-cased path -> actual path of NFO and artwork files
+	isVideo map[string]bool   // dir -> holds videos or NFOs (cached)
+}
+
+func newVideoSidecars() *videoSidecars {
+	return &videoSidecars{videos: map[string]int64{}, files: map[string]string{}, isVideo: map[string]bool{}}
+}
+
+// isArtworkName reports whether an image name is a Kodi artwork name
+// (poster.jpg, fanart.jpg, Movie-poster.jpg, season01-poster.jpg, ...)
+func isArtworkName(path string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if !artworkExts[ext] {
+		return false
+	}
+	if ext == "tbn" {
+		return true
+	}
+	base := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	if artworkNames[base] {
+		return true
+	}
+	for _, suffix := range artworkSuffixes {
+		if strings.HasSuffix(base, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// videoDir reports whether dir holds video files or NFOs, so artwork-named
+// images there are sidecars rather than photos
+func (s *Scanner) videoDir(vs *videoSidecars, dir string) bool {
+	if v, ok := vs.isVideo[dir]; ok {
+		return v
+	}
+	found := false
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(e.Name())), ".")
+		if _, ok := s.Cfg.ExtVideo[ext]; ok || ext == "nfo" {
+			found = true
+			break
+		}
+	}
+	vs.isVideo[dir] = found
+	return found
+}
+
+// addSidecar records an NFO or artwork file; returns false for files that are
+// not video sidecars and should be indexed as usual
+func (s *Scanner) addSidecar(vs *videoSidecars, path, ext string) bool {
+	if ext != "nfo" {
+		if !isArtworkName(path) || !s.videoDir(vs, filepath.Dir(path)) {
+			return false
+		}
+	}
+	vs.files[strings.ToLower(path)] = path
+	return true
+}
+
+func (vs *videoSidecars) find(candidates ...string) string {
+	for _, c := range candidates {
+		if p, ok := vs.files[strings.ToLower(c)]; ok {
+			return p
+		}
+	}
+	return ""
+}
+
+func (vs *videoSidecars) findImage(dir string, names ...string) string {
+	for _, name := range names {
+		for ext := range artworkExts {
+			if p := vs.find(filepath.Join(dir, name+"."+ext)); p != "" {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// sidecarsFor resolves the sidecars of one video. Folder-level files
+// (movie.nfo, poster.jpg, fanart.jpg) only apply when the video is alone in
+// its folder, i.e. a movie folder rather than a season folder.
+func (vs *videoSidecars) sidecarsFor(path string, alone bool) map[string]string {
+	dir := filepath.Dir(path)
+	key := sidecarKey(path)
+	base := filepath.Base(key)
+	out := map[string]string{}
+
+	nfo := vs.find(key + ".nfo")
+	if nfo == "" && alone {
+		nfo = vs.find(filepath.Join(dir, "movie.nfo"))
+	}
+	if nfo != "" {
+		out["nfo"] = nfo
+	}
+	if show := vs.find(filepath.Join(dir, "tvshow.nfo"), filepath.Join(filepath.Dir(dir), "tvshow.nfo")); show != "" {
+		out["tvshow_nfo"] = show
+	}
+
+	poster := vs.findImage(dir, base+"-poster", base+"-thumb", base)
+	if poster == "" && alone {
+		poster = vs.findImage(dir, folderPosterNames...)
+	}
+	if poster != "" {
+		out["poster"] = poster
+	}
+	fanart := vs.findImage(dir, base+"-fanart")
+	if fanart == "" && alone {
+		fanart = vs.findImage(dir, folderFanartNames...)
+	}
+	if fanart != "" {
+		out["fanart"] = fanart
+	}
+	return out
+}
+
+// linkVideoSidecars stores the sidecars of every video seen in this walk and
+// queues metadata/thumb jobs for items whose sidecars changed
+func (s *Scanner) linkVideoSidecars(ctx context.Context, vs *videoSidecars) {
+	perDir := map[string]int{}
+	for path := range vs.videos {
+		perDir[filepath.Dir(path)]++
+	}
+
+	for path, itemID := range vs.videos {
+		found := vs.sidecarsFor(path, perDir[filepath.Dir(path)] == 1)
+
+		type stored struct {
+			path  string
+			mtime *time.Time
+		}
+		current := map[string]stored{}
+		rows, err := s.DB.Query(ctx, "select kind, path, mtime from item_sidecar where item_id=$1", itemID)
+		if err != nil {
+			continue
+		}
+		for rows.Next() {
+			var kind string
+			var st stored
+			if rows.Scan(&kind, &st.path, &st.mtime) == nil {
+				current[kind] = st
+			}
+		}
+		rows.Close()
+
+		nfoChanged, artChanged := false, false
+		for kind, p := range found {
+			info, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+			mtime := info.ModTime().UTC().Truncate(time.Microsecond)
+			if st, ok := current[kind]; ok && st.path == p && st.mtime != nil && st.mtime.Equal(mtime) {
+				continue
+			}
+			_, _ = s.DB.Exec(ctx, `
+				insert into item_sidecar(item_id, kind, path, mtime) values ($1,$2,$3,$4)
+				on conflict (item_id, kind) do update set path=excluded.path, mtime=excluded.mtime
+			`, itemID, kind, p, mtime)
+			if strings.HasSuffix(kind, "nfo") {
+				nfoChanged = true
+			} else {
+				artChanged = true
+			}
+		}
+		for kind := range current {
+			if _, ok := found[kind]; ok {
+				continue
+			}
+			_, _ = s.DB.Exec(ctx, "delete from item_sidecar where item_id=$1 and kind=$2", itemID, kind)
+			if strings.HasSuffix(kind, "nfo") {
+				nfoChanged = true
+			} else {
+				artChanged = true
+			}
+		}
+
+		if nfoChanged {
+			s.queueJob(ctx, "metadata", itemID)
+		}
+		if artChanged {
+			s.queueJob(ctx, "thumb", itemID)
+		}
+	}
+}
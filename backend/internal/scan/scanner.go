@@ -11,6 +11,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/example/mediahub/internal/config"
+	"github.com/example/mediahub/internal/media"
 )
 
 type Scanner struct {
@@ -56,6 +57,9 @@ func (s *Scanner) ScanLibrary(ctx context.Context, libraryID int64) error {
 	// Walk roots
 	for _, root := range roots {
 		root = filepath.Clean(root)
+		audioByKey := map[string]int64{}   // sidecarKey -> audio item id
+		lyricsByKey := map[string]string{} // sidecarKey -> lyrics sidecar path
+		videoSC := newVideoSidecars()
 		walkFn := func(path string, d fs.DirEntry, werr error) error {
 			if werr != nil {
 				return nil
@@ -64,6 +68,24 @@ func (s *Scanner) ScanLibrary(ctx context.Context, libraryID int64) error {
 				return nil
 			}
 
+			ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+			if _, ok := lyricsExts[ext]; ok {
+				addLyricsSidecar(lyricsByKey, path, ext)
+				if ext == "lrc" {
+					return nil
+				}
+				// .txt may be a plain document too, index it as usual
+			}
+			if media.SubtitleExts[ext] {
+				videoSC.subs = append(videoSC.subs, path)
+				return nil
+			}
+			if ext == "nfo" || artworkExts[ext] {
+				if s.addSidecar(videoSC, path, ext) {
+					return nil
+				}
+			}
+
 			kind, ok := s.kindForExt(filepath.Ext(path))
 			if !ok {
 				return nil
@@ -85,10 +107,12 @@ func (s *Scanner) ScanLibrary(ctx context.Context, libraryID int64) error {
 
 			// Upsert, update if changed
 			// If size/mtime changed, schedule jobs (metadata/thumb).
-			// xmax = 0 means INSERT (new), xmax <> 0 means UPDATE (existing)
+			// xmax = 0 means INSERT (new), xmax <> 0 means UPDATE (existing);
+			// the prev CTE still sees the row as it was before this statement.
 			var itemID int64
-			var isUpdate bool
+			var isUpdate, changed bool
 			err = s.DB.QueryRow(ctx, `
+				with prev as (select size_bytes, mtime from media_item where path=$2)
 				insert into media_item(library_id, path, rel_path, kind, present, size_bytes, mtime, last_seen_at, updated_at)
 				values ($1,$2,$3,$4,true,$5,$6,$7,$7)
 				on conflict (path) do update set
@@ -101,26 +125,44 @@ func (s *Scanner) ScanLibrary(ctx context.Context, libraryID int64) error {
 					updated_at=excluded.updated_at,
 					size_bytes=excluded.size_bytes,
 					mtime=excluded.mtime
-				returning id, (xmax <> 0) as is_update
-			`, libraryID, path, rel, kind, size, mtime, startedAt).Scan(&itemID, &isUpdate)
+				returning id, (xmax <> 0) as is_update,
+					coalesce((select size_bytes <> $5 or mtime is distinct from $6 from prev), true) as changed
+			`, libraryID, path, rel, kind, size, mtime, startedAt).Scan(&itemID, &isUpdate, &changed)
 			if err != nil {
 				return nil
 			}
+			switch kind {
+			case "audio":
+				audioByKey[sidecarKey(path)] = itemID
+			case "video":
+				videoSC.videos[path] = itemID
+			}
 
 			// For new items (insert) or changed items (update with different content)
-			// Create thumb job for video and photo types
-			if !isUpdate && (kind == "video" || kind == "photo") {
-				// New item - create thumb job
-				_, _ = s.DB.Exec(ctx, "insert into job(kind,item_id,run_at,attempts) values ('thumb',$1,NOW(),0) on conflict do nothing", itemID)
-			} else if isUpdate {
-				// Existing item that was updated - enqueue jobs (best-effort)
-				_, _ = s.DB.Exec(ctx, "insert into job(kind,item_id) values ('metadata',$1) on conflict do nothing", itemID)
-				_, _ = s.DB.Exec(ctx, "insert into job(kind,item_id) values ('thumb',$1) on conflict do nothing", itemID)
+			// Create thumb job for video and photo types, metadata job for audio and video
+			if !isUpdate {
+				// New item - create jobs
+				if kind == "video" || kind == "photo" {
+					s.queueJob(ctx, "thumb", itemID)
+				}
+				if kind == "video" || kind == "audio" {
+					s.queueJob(ctx, "metadata", itemID)
+				}
+			} else if changed {
+				// Existing item whose content changed - enqueue jobs (best-effort)
+				// Audio thumbs are queued by the metadata worker once the album is known
+				s.queueJob(ctx, "metadata", itemID)
+				if kind != "audio" {
+					s.queueJob(ctx, "thumb", itemID)
+				}
 			}
 			return nil
 		}
 
 		_ = filepath.WalkDir(root, walkFn)
+		s.linkLyrics(ctx, audioByKey, lyricsByKey)
+		s.linkVideoSidecars(ctx, videoSC)
+		s.linkSubtitles(ctx, videoSC)
 	}
 
 	// Mark missing any item not seen in this run
@@ -138,3 +180,11 @@ func (s *Scanner) ScanLibrary(ctx context.Context, libraryID int64) error {
 	_, _ = s.DB.Exec(ctx, "update scan_run set finished_at=$2 where id=$1", runID, time.Now().UTC())
 	return nil
 }
+
+// queueJob adds a job for an item unless one of that kind is already queued
+func (s *Scanner) queueJob(ctx context.Context, kind string, itemID int64) {
+	_, _ = s.DB.Exec(ctx, `
+		insert into job(kind, item_id)
+		select $1::text, $2::bigint
+		where not exists (select 1 from job where kind = $1 and item_id = $2)`, kind, itemID)
+}
@@ -0,0 +1,78 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/example/mediahub/internal/media"
+)
+
+// maxSubtitleSuffixTokens bounds how many ".xx" tokens may follow the video
+// name: "Movie.en.forced.sdh.srt" has three
+const maxSubtitleSuffixTokens = 4
+
+type subtitleSidecar struct {
+	path   string
+	format string
+	suffix media.SubtitleSuffix
+}
+
+// matchSubtitles links each subtitle sidecar to the video whose base name is
+// the longest dot-prefix of the subtitle name ("Movie.en.srt" -> "Movie.mkv")
+func (vs *videoSidecars) matchSubtitles() map[int64][]subtitleSidecar {
+	byKey := map[string]int64{}
+	for path, itemID := range vs.videos {
+		byKey[strings.ToLower(sidecarKey(path))] = itemID
+	}
+
+	out := map[int64][]subtitleSidecar{}
+	for _, sub := range vs.subs {
+		dir := filepath.Dir(sub)
+		ext := filepath.Ext(sub)
+		tokens := strings.Split(strings.TrimSuffix(filepath.Base(sub), ext), ".")
+		for i := len(tokens); i >= 1 && len(tokens)-i <= maxSubtitleSuffixTokens; i-- {
+			key := strings.ToLower(filepath.Join(dir, strings.Join(tokens[:i], ".")))
+			if itemID, ok := byKey[key]; ok {
+				out[itemID] = append(out[itemID], subtitleSidecar{
+					path:   sub,
+					format: strings.ToLower(strings.TrimPrefix(ext, ".")),
+					suffix: media.ParseSubtitleSuffix(tokens[i:]),
+				})
+				break
+			}
+		}
+	}
+	return out
+}
+
+// linkSubtitles stores the subtitle sidecars of the videos seen in this walk,
+// updating rows whose file changed and dropping those whose file disappeared
+func (s *Scanner) linkSubtitles(ctx context.Context, vs *videoSidecars) {
+	matched := vs.matchSubtitles()
+	for _, itemID := range vs.videos {
+		subs := matched[itemID]
+		keep := make([]string, 0, len(subs))
+		for _, sub := range subs {
+			info, err := os.Stat(sub.path)
+			if err != nil {
+				continue
+			}
+			keep = append(keep, sub.path)
+			mtime := info.ModTime().UTC().Truncate(time.Microsecond)
+			_, _ = s.DB.Exec(ctx, `
+				insert into subtitle(item_id, source, path, format, lang, title, forced, sdh, mtime)
+				values ($1,'sidecar',$2,$3,nullif($4,''),nullif($5,''),$6,$7,$8)
+				on conflict (item_id, path) where source = 'sidecar' do update set
+					format=excluded.format, lang=excluded.lang, title=excluded.title,
+					forced=excluded.forced, sdh=excluded.sdh, mtime=excluded.mtime
+				where subtitle.mtime is distinct from excluded.mtime
+			`, itemID, sub.path, sub.format, sub.suffix.Lang, sub.suffix.Title, sub.suffix.Forced, sub.suffix.SDH, mtime)
+		}
+		_, _ = s.DB.Exec(ctx, `
+			delete from subtitle where item_id=$1 and source='sidecar' and not (path = any($2))
+		`, itemID, keep)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/mediahub/internal/media"
 )
 
 type Streamer struct {
@@ -19,9 +21,9 @@ func New(db *pgxpool.Pool) *Streamer {
 }
 
 func (s *Streamer) StreamByID(w http.ResponseWriter, r *http.Request, id int64) {
-	var path string
+	var path, kind, container string
 	var present bool
-	err := s.DB.QueryRow(r.Context(), "select path, present from media_item where id=$1", id).Scan(&path, &present)
+	err := s.DB.QueryRow(r.Context(), "select path, kind, coalesce(container,''), present from media_item where id=$1", id).Scan(&path, &kind, &container, &present)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -49,7 +51,9 @@ func (s *Streamer) StreamByID(w http.ResponseWriter, r *http.Request, id int64)
 		return
 	}
 
-	// Content-Type: rely on browser sniffing or add a small map if needed
+	// Explicit Content-Type: ServeContent would only guess from the extension,
+	// and gets mkv, m2ts, flac, opus and heic wrong on most systems
+	w.Header().Set("Content-Type", media.ContentType(kind, path, container))
 	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
 
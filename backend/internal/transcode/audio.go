@@ -0,0 +1,224 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/mediahub/internal/config"
+)
+
+// AudioFormat is a target of audio transcoding
+type AudioFormat struct {
+	Name        string
+	Codec       string // ffmpeg encoder
+	Muxer       string // ffmpeg output format
+	MimeType    string
+	Ext         string
+	DefaultKbps int
+}
+
+var AudioFormats = map[string]AudioFormat{
+	"opus": {Name: "opus", Codec: "libopus", Muxer: "ogg", MimeType: "audio/ogg; codecs=opus", Ext: "opus", DefaultKbps: 96},
+	"mp3":  {Name: "mp3", Codec: "libmp3lame", Muxer: "mp3", MimeType: "audio/mpeg", Ext: "mp3", DefaultKbps: 192},
+	"aac":  {Name: "aac", Codec: "aac", Muxer: "adts", MimeType: "audio/aac", Ext: "aac", DefaultKbps: 160},
+}
+
+const (
+	minAudioKbps = 32
+	maxAudioKbps = 320
+)
+
+// AudioProfile is a format plus a bitrate in kbps
+type AudioProfile struct {
+	Format AudioFormat
+	Kbps   int
+}
+
+func (p AudioProfile) String() string {
+	return fmt.Sprintf("%s-%d", p.Format.Name, p.Kbps)
+}
+
+// ParseAudioProfile validates ?format=opus|mp3|aac&bitrate=<kbps>; a missing
+// bitrate uses the format default, out-of-range bitrates are clamped
+func ParseAudioProfile(format string, kbps int) (AudioProfile, error) {
+	f, ok := AudioFormats[strings.ToLower(strings.TrimSpace(format))]
+	if !ok {
+		return AudioProfile{}, fmt.Errorf("unsupported audio format %q", format)
+	}
+	if kbps <= 0 {
+		kbps = f.DefaultKbps
+	}
+	kbps = max(minAudioKbps, min(maxAudioKbps, kbps))
+	return AudioProfile{Format: f, Kbps: kbps}, nil
+}
+
+// AudioTranscoder encodes audio items on request and keeps the results in
+// an LRU cache under TRANSCODE_DIR/audio, keyed by item, source mtime and
+// profile. File mtimes record the last use; the oldest are evicted first.
+// Concurrent requests for the same uncached result wait for one encode.
+type AudioTranscoder struct {
+	Dir      string
+	MaxBytes int64
+
+	sem      chan struct{} // bounds concurrent ffmpeg processes
+	mu       sync.Mutex
+	encoding map[string]chan struct{} // cache file -> closed when its encode ends
+	evictMu  sync.Mutex
+}
+
+func NewAudioTranscoder(cfg config.Config) *AudioTranscoder {
+	return &AudioTranscoder{
+		Dir:      filepath.Join(cfg.TranscodeDir, "audio"),
+		MaxBytes: int64(cfg.AudioCacheMB) << 20,
+		sem:      make(chan struct{}, max(2, runtime.NumCPU())),
+		encoding: map[string]chan struct{}{},
+	}
+}
+
+// Serve writes src transcoded to profile. Cached results are served as files
+// (with range support); otherwise ffmpeg output is streamed to the client and
+// kept in the cache once complete.
+func (t *AudioTranscoder) Serve(w http.ResponseWriter, r *http.Request, itemID int64, src string, profile AudioProfile) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", profile.Format.MimeType)
+
+	cached := filepath.Join(t.Dir, fmt.Sprintf("%d-%d-%s.%s", itemID, fi.ModTime().Unix(), profile, profile.Format.Ext))
+	if t.MaxBytes > 0 {
+		if serveCached(w, r, cached, fi.ModTime()) {
+			return
+		}
+		release, ok := t.claim(r.Context(), cached)
+		if !ok {
+			return
+		}
+		// Whoever encoded it before us may have cached it
+		if serveCached(w, r, cached, fi.ModTime()) {
+			release()
+			return
+		}
+		defer release()
+	}
+
+	var out io.Writer = w
+	var tmp *os.File
+	if t.MaxBytes > 0 && os.MkdirAll(t.Dir, 0755) == nil {
+		if tmp, err = os.CreateTemp(t.Dir, ".partial-*"); err == nil {
+			defer os.Remove(tmp.Name()) // no-op once renamed
+			out = io.MultiWriter(w, tmp)
+		}
+	}
+
+	select {
+	case t.sem <- struct{}{}:
+	case <-r.Context().Done():
+		return
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Cache-Control", "no-store")
+	err = EncodeAudio(r.Context(), out, src, profile)
+	<-t.sem
+	if tmp == nil {
+		return
+	}
+	tmp.Close()
+	if err != nil {
+		return
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		log.Printf("audio cache: %v", err)
+		return
+	}
+	go t.evict()
+}
+
+// claim makes the caller the one encoding a cache file, after waiting for
+// any encode of it in progress; release ends the claim. ok is false when the
+// request ended while waiting.
+func (t *AudioTranscoder) claim(ctx context.Context, cached string) (release func(), ok bool) {
+	for {
+		t.mu.Lock()
+		done, busy := t.encoding[cached]
+		if !busy {
+			done = make(chan struct{})
+			t.encoding[cached] = done
+			t.mu.Unlock()
+			return func() {
+				t.mu.Lock()
+				delete(t.encoding, cached)
+				t.mu.Unlock()
+				close(done)
+			}, true
+		}
+		t.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// serveCached serves a cache file with range support, if it exists
+func serveCached(w http.ResponseWriter, r *http.Request, cached string, modTime time.Time) bool {
+	f, err := os.Open(cached)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	touch(cached)
+	http.ServeContent(w, r, filepath.Base(cached), modTime, f)
+	return true
+}
+
+// EncodeAudio runs ffmpeg on the first audio stream of src, writing the
+// encoded stream to w. Cover art and other streams are dropped.
+func EncodeAudio(ctx context.Context, w io.Writer, src string, profile AudioProfile) error {
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-i", src,
+		"-map", "0:a:0", "-vn", "-sn",
+		"-map_metadata", "0",
+		"-c:a", profile.Format.Codec,
+		"-b:a", strconv.Itoa(profile.Kbps) + "k",
+	}
+	if profile.Format.Name == "opus" {
+		// libopus only takes 48 kHz and at most 2 channels in the mapping we use
+		args = append(args, "-ar", "48000", "-ac", "2")
+	}
+	args = append(args, "-f", profile.Format.Muxer, "pipe:1")
+
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("audio transcode %s (%s): %s", src, profile, strings.TrimSpace(stderr.String()))
+		return fmt.Errorf("ffmpeg audio transcode: %w", err)
+	}
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits MaxBytes
+func (t *AudioTranscoder) evict() {
+	t.evictMu.Lock()
+	defer t.evictMu.Unlock()
+	evictLRU(t.Dir, t.MaxBytes)
+}
@@ -0,0 +1,532 @@
+package transcode
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/mediahub/internal/config"
+)
+
+// HLS transcoding: one playback session per master playlist request, one
+// ffmpeg process for the variant being watched (switching variants stops the
+// others). Media playlists are generated up front from the duration
+// (fixed-length segments with forced keyframes), so a seek to a segment that
+// is not encoded yet restarts ffmpeg from there. ffmpeg is paused once it is
+// hlsMaxAhead segments ahead of the player, and resumed as the player catches up.
+
+const (
+	hlsSegmentSeconds  = 6
+	hlsIdleTimeout     = 2 * time.Minute
+	hlsMaxSessions     = 6                // in total; beyond this the oldest idle session is evicted
+	hlsMaxUserSessions = 2                // per user; beyond this their oldest session is replaced
+	hlsEvictIdle       = 30 * time.Second // a playing session fetches a segment every few seconds
+	hlsMaxAhead        = 4                // segments past the encoder position worth waiting for, and the encoder's lead on the player
+	hlsSegmentWait     = 60 * time.Second
+)
+
+// Variant is one rung of the bitrate ladder
+type Variant struct {
+	Name         string
+	Height       int
+	VideoBitrate int // bits/s
+	AudioBitrate int // bits/s
+}
+
+// Ladder offered to clients; rungs taller than the source are dropped
+var Ladder = []Variant{
+	{Name: "1080p", Height: 1080, VideoBitrate: 5_000_000, AudioBitrate: 192_000},
+	{Name: "720p", Height: 720, VideoBitrate: 2_800_000, AudioBitrate: 128_000},
+	{Name: "480p", Height: 480, VideoBitrate: 1_200_000, AudioBitrate: 128_000},
+}
+
+var (
+	ErrSessionNotFound = errors.New("hls session not found")
+	ErrTooManySessions = errors.New("too many hls sessions, try again later")
+)
+
+// Source describes the file being played
+type Source struct {
+	ItemID     int64
+	Path       string
+	DurationMs int
+	Width      int
+	Height     int
+	HasAudio   bool
+}
+
+// VariantsFor returns the ladder rungs that make sense for a source
+func VariantsFor(src Source) []Variant {
+	var out []Variant
+	for _, v := range Ladder {
+		if src.Height <= 0 || v.Height <= src.Height {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		out = append(out, Ladder[len(Ladder)-1])
+	}
+	return out
+}
+
+// OutputSize is the encoded frame size of a variant (never upscaled, even width)
+func (v Variant) OutputSize(src Source) (int, int) {
+	h := v.Height
+	if src.Height > 0 && src.Height < h {
+		h = src.Height
+	}
+	w := h * 16 / 9
+	if src.Width > 0 && src.Height > 0 {
+		w = int(math.Round(float64(src.Width)*float64(h)/float64(src.Height)/2)) * 2
+	}
+	return w, h
+}
+
+type hlsEncoder struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	start  int // first segment this run produces
+	proc   *os.Process
+	paused bool // guarded by the session's mu
+}
+
+type hlsSession struct {
+	id         string
+	userID     int64
+	src        Source
+	dir        string
+	lastAccess time.Time
+
+	mu        sync.Mutex
+	encoders  map[string]*hlsEncoder // variant name -> running ffmpeg
+	requested map[string]int         // variant name -> last segment requested
+	closed    bool                   // removed from the manager, starts no more encoders
+}
+
+// HLSManager owns the playback sessions and their ffmpeg processes
+type HLSManager struct {
+	Cfg config.Config
+
+	mu       sync.Mutex
+	sessions map[string]*hlsSession
+}
+
+func NewHLSManager(cfg config.Config) *HLSManager {
+	return &HLSManager{Cfg: cfg, sessions: map[string]*hlsSession{}}
+}
+
+// Run removes idle sessions until ctx is done, then stops all of them
+func (m *HLSManager) Run(ctx context.Context) {
+	log.Println("hls manager started")
+	// Segments of a previous run are useless without their session
+	_ = os.RemoveAll(filepath.Join(m.Cfg.TranscodeDir, "hls"))
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			var stopped []*hlsSession
+			m.mu.Lock()
+			for id := range m.sessions {
+				stopped = append(stopped, m.removeLocked(id))
+			}
+			m.mu.Unlock()
+			closeSessions(stopped)
+			log.Println("hls manager stopped")
+			return
+		case <-ticker.C:
+			var stopped []*hlsSession
+			m.mu.Lock()
+			for id, s := range m.sessions {
+				if time.Since(s.lastAccess) > hlsIdleTimeout {
+					log.Printf("hls session %s idle, stopping", id)
+					stopped = append(stopped, m.removeLocked(id))
+				}
+			}
+			m.mu.Unlock()
+			closeSessions(stopped)
+		}
+	}
+}
+
+// NewSession starts a playback session of a user; encoding starts with the
+// first segment request. A user past their limit loses their oldest session;
+// past the global limit, the oldest session idle for hlsEvictIdle goes, and
+// when all are playing the new one is refused with ErrTooManySessions.
+func (m *HLSManager) NewSession(src Source, userID int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	dir := filepath.Join(m.Cfg.TranscodeDir, "hls", id)
+
+	var stopped []*hlsSession
+	defer func() { closeSessions(stopped) }()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.countLocked(userID) >= hlsMaxUserSessions {
+		old := m.oldestLocked(func(s *hlsSession) bool { return s.userID == userID })
+		log.Printf("hls session limit of user %d reached, stopping %s", userID, old.id)
+		stopped = append(stopped, m.removeLocked(old.id))
+	}
+	if len(m.sessions) >= hlsMaxSessions {
+		old := m.oldestLocked(func(s *hlsSession) bool { return time.Since(s.lastAccess) > hlsEvictIdle })
+		if old == nil {
+			return "", ErrTooManySessions
+		}
+		log.Printf("hls session limit reached, stopping idle %s", old.id)
+		stopped = append(stopped, m.removeLocked(old.id))
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	m.sessions[id] = &hlsSession{id: id, userID: userID, src: src, dir: dir, lastAccess: time.Now(),
+		encoders: map[string]*hlsEncoder{}, requested: map[string]int{}}
+	return id, nil
+}
+
+// oldestLocked returns the least recently used session matching keep, if any
+func (m *HLSManager) oldestLocked(keep func(*hlsSession) bool) *hlsSession {
+	var oldest *hlsSession
+	for _, s := range m.sessions {
+		if keep(s) && (oldest == nil || s.lastAccess.Before(oldest.lastAccess)) {
+			oldest = s
+		}
+	}
+	return oldest
+}
+
+func (m *HLSManager) countLocked(userID int64) int {
+	n := 0
+	for _, s := range m.sessions {
+		if s.userID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// Stop ends a session of a user, e.g. when the player is closed
+func (m *HLSManager) Stop(sessionID string, itemID, userID int64) error {
+	m.mu.Lock()
+	s, ok := m.sessions[sessionID]
+	if !ok || s.src.ItemID != itemID || s.userID != userID {
+		m.mu.Unlock()
+		return ErrSessionNotFound
+	}
+	m.removeLocked(sessionID)
+	m.mu.Unlock()
+	s.close()
+	return nil
+}
+
+// removeLocked takes a session out of the manager; the caller closes it once
+// m.mu is released, since stopping ffmpeg takes a while
+func (m *HLSManager) removeLocked(id string) *hlsSession {
+	s := m.sessions[id]
+	delete(m.sessions, id)
+	return s
+}
+
+// close stops the encoders of a removed session and deletes its segments
+func (s *hlsSession) close() {
+	s.mu.Lock()
+	s.closed = true
+	for _, enc := range s.encoders {
+		enc.stop()
+	}
+	s.mu.Unlock()
+	_ = os.RemoveAll(s.dir)
+}
+
+func closeSessions(sessions []*hlsSession) {
+	for _, s := range sessions {
+		s.close()
+	}
+}
+
+func (m *HLSManager) session(id string, itemID int64) (*hlsSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.src.ItemID != itemID {
+		return nil, ErrSessionNotFound
+	}
+	s.lastAccess = time.Now()
+	return s, nil
+}
+
+// MasterPlaylist lists the variants; URIs are relative to the master URL and
+// end with query (the URL signature, if any)
+func MasterPlaylist(sessionID string, src Source, query string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range VariantsFor(src) {
+		w, h := v.OutputSize(src)
+		bandwidth, codecs := v.VideoBitrate, "avc1.640028"
+		if src.HasAudio {
+			bandwidth, codecs = bandwidth+v.AudioBitrate, codecs+",mp4a.40.2"
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n", bandwidth, w, h, codecs)
+		fmt.Fprintf(&b, "%s/%s/index.m3u8%s\n", sessionID, v.Name, query)
+	}
+	return b.String()
+}
+
+// MediaPlaylist returns the VOD playlist of a variant; segment URIs end with query
+func (m *HLSManager) MediaPlaylist(sessionID string, itemID int64, variant, query string) (string, error) {
+	s, err := m.session(sessionID, itemID)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := findVariant(variant); !ok {
+		return "", ErrSessionNotFound
+	}
+
+	total := float64(s.src.DurationMs) / 1000
+	count := segmentCount(s.src.DurationMs)
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", hlsSegmentSeconds)
+	for i := 0; i < count; i++ {
+		d := math.Min(hlsSegmentSeconds, total-float64(i*hlsSegmentSeconds))
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d.ts%s\n", d, i, query)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String(), nil
+}
+
+// Segment returns the path of an encoded segment, starting or restarting
+// ffmpeg when the segment is not being produced, and waiting for it otherwise
+func (m *HLSManager) Segment(ctx context.Context, sessionID string, itemID int64, variant string, n int) (string, error) {
+	s, err := m.session(sessionID, itemID)
+	if err != nil {
+		return "", err
+	}
+	v, ok := findVariant(variant)
+	if !ok || n < 0 || n >= segmentCount(s.src.DurationMs) {
+		return "", ErrSessionNotFound
+	}
+
+	dir := filepath.Join(s.dir, v.Name)
+	seg := filepath.Join(dir, strconv.Itoa(n)+".ts")
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return "", ErrSessionNotFound
+	}
+	s.requested[v.Name] = n
+	// The player switched to this variant: the others only burn CPU now
+	var stopped []*hlsEncoder
+	for name, other := range s.encoders {
+		if name != v.Name {
+			stopped = append(stopped, other)
+			delete(s.encoders, name)
+		}
+	}
+
+	enc := s.encoders[v.Name]
+	if fileExists(seg) {
+		s.paceLocked(v.Name, enc, dir)
+		s.mu.Unlock()
+		stopEncoders(stopped)
+		return seg, nil
+	}
+	if enc == nil || enc.finished() || n < enc.start || n > lastSegment(dir, enc.start)+hlsMaxAhead {
+		if enc != nil {
+			stopped = append(stopped, enc)
+		}
+		enc, err = startEncoder(s.src, v, dir, n)
+		if err != nil {
+			delete(s.encoders, v.Name)
+			s.mu.Unlock()
+			stopEncoders(stopped)
+			return "", err
+		}
+		s.encoders[v.Name] = enc
+		go s.pace(v.Name, enc, dir)
+	}
+	s.paceLocked(v.Name, enc, dir)
+	s.mu.Unlock()
+	stopEncoders(stopped)
+
+	deadline := time.NewTimer(hlsSegmentWait)
+	defer deadline.Stop()
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if fileExists(seg) {
+			return seg, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-deadline.C:
+			return "", fmt.Errorf("segment %d of %s not ready", n, v.Name)
+		case <-enc.done:
+			if fileExists(seg) {
+				return seg, nil
+			}
+			return "", fmt.Errorf("ffmpeg exited before segment %d of %s", n, v.Name)
+		case <-tick.C:
+		}
+	}
+}
+
+// pace keeps an encoder at most hlsMaxAhead segments ahead of the player
+// until it exits or is replaced
+func (s *hlsSession) pace(name string, enc *hlsEncoder, dir string) {
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-enc.done:
+			return
+		case <-tick.C:
+		}
+		s.mu.Lock()
+		if s.encoders[name] != enc {
+			s.mu.Unlock()
+			return
+		}
+		s.paceLocked(name, enc, dir)
+		s.mu.Unlock()
+	}
+}
+
+// paceLocked pauses or resumes an encoder by its lead on the last request.
+// Where processes can't be paused, it is stopped and restarted on demand.
+func (s *hlsSession) paceLocked(name string, enc *hlsEncoder, dir string) {
+	if enc == nil || enc.finished() {
+		return
+	}
+	ahead := lastSegment(dir, enc.start) - s.requested[name]
+	switch {
+	case ahead > hlsMaxAhead && !enc.paused:
+		if err := pauseProcess(enc.proc); err != nil {
+			enc.cancel()
+			return
+		}
+		enc.paused = true
+	case ahead <= hlsMaxAhead && enc.paused:
+		if err := resumeProcess(enc.proc); err == nil {
+			enc.paused = false
+		}
+	}
+}
+
+func stopEncoders(encs []*hlsEncoder) {
+	for _, enc := range encs {
+		enc.stop()
+	}
+}
+
+// startEncoder runs ffmpeg from segment n on. Keyframes are forced on segment
+// boundaries and timestamps offset to the segment start, so segments from
+// different runs line up in the same playlist.
+func startEncoder(src Source, v Variant, dir string, n int) (*hlsEncoder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	start := n * hlsSegmentSeconds
+	_, h := v.OutputSize(src)
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-ss", strconv.Itoa(start),
+		"-i", src.Path,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "high", "-level", "4.0",
+		"-vf", fmt.Sprintf("scale=-2:%d,format=yuv420p", h),
+		"-b:v", strconv.Itoa(v.VideoBitrate),
+		"-maxrate", strconv.Itoa(v.VideoBitrate * 107 / 100),
+		"-bufsize", strconv.Itoa(v.VideoBitrate * 3 / 2),
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds),
+		"-sc_threshold", "0",
+		"-c:a", "aac", "-ac", "2", "-b:a", strconv.Itoa(v.AudioBitrate),
+		"-output_ts_offset", strconv.Itoa(start),
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+		"-hls_list_size", "0",
+		"-hls_flags", "temp_file",
+		"-start_number", strconv.Itoa(n),
+		"-hls_segment_filename", filepath.Join(dir, "%d.ts"),
+		filepath.Join(dir, fmt.Sprintf("ffmpeg-%d.m3u8", n)),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("ffmpeg start: %w", err)
+	}
+
+	enc := &hlsEncoder{cancel: cancel, done: make(chan struct{}), start: n, proc: cmd.Process}
+	go func() {
+		err := cmd.Wait()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("hls ffmpeg %s (%s from segment %d) failed: %v: %s", src.Path, v.Name, n, err, strings.TrimSpace(stderr.String()))
+		}
+		close(enc.done)
+	}()
+	return enc, nil
+}
+
+func (e *hlsEncoder) stop() {
+	e.cancel()
+	<-e.done
+}
+
+func (e *hlsEncoder) finished() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// lastSegment is the highest contiguous segment produced since start
+func lastSegment(dir string, start int) int {
+	n := start - 1
+	for fileExists(filepath.Join(dir, strconv.Itoa(n+1)+".ts")) {
+		n++
+	}
+	return n
+}
+
+func segmentCount(durationMs int) int {
+	return int(math.Ceil(float64(durationMs) / 1000 / hlsSegmentSeconds))
+}
+
+func findVariant(name string) (Variant, bool) {
+	for _, v := range Ladder {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
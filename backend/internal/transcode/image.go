@@ -0,0 +1,245 @@
+package transcode
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/example/mediahub/internal/config"
+)
+
+// Image fit modes
+const (
+	FitInside = "inside" // scale down to fit in the box, keep the aspect ratio
+	FitCover  = "cover"  // fill the box, crop what overflows
+	FitFill   = "fill"   // stretch to exactly the box
+)
+
+// ImageFormat is an output format of the image pipeline
+type ImageFormat struct {
+	Name     string
+	MimeType string
+	Ext      string
+}
+
+var ImageFormats = map[string]ImageFormat{
+	"jpeg": {Name: "jpeg", MimeType: "image/jpeg", Ext: "jpg"},
+	"webp": {Name: "webp", MimeType: "image/webp", Ext: "webp"},
+	"png":  {Name: "png", MimeType: "image/png", Ext: "png"},
+}
+
+const (
+	maxImageDim         = 8192
+	defaultImageQuality = 85
+)
+
+// ImageOptions describe a rendition. A zero Width or Height leaves that side
+// free; both zero keep the original size (orientation and format still apply).
+type ImageOptions struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  ImageFormat
+	Quality int
+}
+
+// ThumbnailOptions is the rendition stored by the thumb worker
+var ThumbnailOptions = ImageOptions{Width: 320, Height: 320, Fit: FitInside, Format: ImageFormats["jpeg"], Quality: defaultImageQuality}
+
+func (o ImageOptions) String() string {
+	return fmt.Sprintf("%dx%d-%s-q%d.%s", o.Width, o.Height, o.Fit, o.Quality, o.Format.Name)
+}
+
+// ParseImageOptions validates ?w=&h=&fit=&format=&q=. Sizes are capped at
+// 8192, fit defaults to inside, quality to 85; cover and fill need both sides.
+func ParseImageOptions(width, height int, fit, format string, quality int) (ImageOptions, error) {
+	if width < 0 || height < 0 {
+		return ImageOptions{}, fmt.Errorf("negative size")
+	}
+	o := ImageOptions{Width: min(width, maxImageDim), Height: min(height, maxImageDim), Quality: quality}
+
+	switch fit = strings.ToLower(strings.TrimSpace(fit)); fit {
+	case "", FitInside, "contain":
+		o.Fit = FitInside
+	case FitCover, FitFill:
+		if o.Width == 0 || o.Height == 0 {
+			return ImageOptions{}, fmt.Errorf("fit=%s needs both w and h", fit)
+		}
+		o.Fit = fit
+	default:
+		return ImageOptions{}, fmt.Errorf("unsupported fit %q", fit)
+	}
+
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	f, ok := ImageFormats[format]
+	if !ok {
+		return ImageOptions{}, fmt.Errorf("unsupported image format %q", format)
+	}
+	o.Format = f
+
+	if o.Quality <= 0 || o.Quality > 100 {
+		o.Quality = defaultImageQuality
+	}
+	return o, nil
+}
+
+// ResizeImage renders the first frame of src to dst with ImageMagick. EXIF
+// orientation is applied and metadata stripped; dst is written in o.Format
+// whatever its extension.
+func ResizeImage(ctx context.Context, src, dst string, o ImageOptions) error {
+	args := []string{src + "[0]", "-auto-orient"}
+
+	geom := ""
+	if o.Width > 0 {
+		geom = strconv.Itoa(o.Width)
+	}
+	if o.Height > 0 {
+		geom += "x" + strconv.Itoa(o.Height)
+	}
+	switch {
+	case geom == "":
+	case o.Fit == FitCover:
+		args = append(args, "-thumbnail", geom+"^", "-gravity", "center", "-extent", geom)
+	case o.Fit == FitFill:
+		args = append(args, "-thumbnail", geom+"!")
+	default:
+		// never upscale
+		args = append(args, "-thumbnail", geom+">")
+	}
+
+	if o.Format.Name == "jpeg" {
+		// JPEG has no alpha: flatten transparent PNG/WebP onto white
+		args = append(args, "-background", "white", "-alpha", "remove")
+	}
+	args = append(args, "-strip", "-quality", strconv.Itoa(o.Quality), o.Format.Name+":"+dst)
+
+	cmd := exec.CommandContext(ctx, "convert", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("convert failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ImageRenderer serves resized renditions of photos from an LRU cache under
+// TRANSCODE_DIR/images. Renditions are keyed (and ETagged) by item, source
+// mtime and size, and options, so an edited original gets new renditions.
+type ImageRenderer struct {
+	Dir      string
+	MaxBytes int64
+
+	sem     chan struct{} // bounds concurrent convert processes
+	evictMu sync.Mutex
+}
+
+func NewImageRenderer(cfg config.Config) *ImageRenderer {
+	return &ImageRenderer{
+		Dir:      filepath.Join(cfg.TranscodeDir, "images"),
+		MaxBytes: int64(cfg.ImageCacheMB) << 20,
+		sem:      make(chan struct{}, max(2, runtime.NumCPU())),
+	}
+}
+
+// Serve writes the rendition of src described by o, answering
+// If-None-Match with 304 before anything is rendered
+func (ir *ImageRenderer) Serve(w http.ResponseWriter, r *http.Request, itemID int64, src string, o ImageOptions) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d-%d-%d-%s", itemID, fi.ModTime().UnixNano(), fi.Size(), o)))
+	key := hex.EncodeToString(sum[:12])
+	etag := `"` + key + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", o.Format.MimeType)
+
+	cached := filepath.Join(ir.Dir, key+"."+o.Format.Ext)
+	if ir.MaxBytes > 0 {
+		if f, err := os.Open(cached); err == nil {
+			defer f.Close()
+			touch(cached)
+			http.ServeContent(w, r, "", fi.ModTime(), f)
+			return
+		}
+	}
+
+	if err := os.MkdirAll(ir.Dir, 0755); err != nil {
+		http.Error(w, "image cache unavailable", 500)
+		return
+	}
+	tmp, err := os.CreateTemp(ir.Dir, ".partial-*")
+	if err != nil {
+		http.Error(w, "image cache unavailable", 500)
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	select {
+	case ir.sem <- struct{}{}:
+	case <-r.Context().Done():
+		return
+	}
+	err = ResizeImage(r.Context(), src, tmp.Name(), o)
+	<-ir.sem
+	if err != nil {
+		log.Printf("image item %d (%s): %v", itemID, o, err)
+		http.Error(w, "could not render image", 500)
+		return
+	}
+
+	out := tmp.Name()
+	if ir.MaxBytes > 0 {
+		if err := os.Rename(tmp.Name(), cached); err != nil {
+			log.Printf("image cache: %v", err)
+		} else {
+			out = cached
+			go ir.evict()
+		}
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		http.Error(w, "could not render image", 500)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// evict removes the least recently used renditions until the cache fits MaxBytes
+func (ir *ImageRenderer) evict() {
+	ir.evictMu.Lock()
+	defer ir.evictMu.Unlock()
+	evictLRU(ir.Dir, ir.MaxBytes)
+}
+
+// etagMatches implements the weak comparison of If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
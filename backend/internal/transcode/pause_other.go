@@ -0,0 +1,13 @@
+//go:build !unix
+
+package transcode
+
+import (
+	"errors"
+	"os"
+)
+
+// Processes can't be paused here: callers stop them instead
+func pauseProcess(p *os.Process) error { return errors.ErrUnsupported }
+
+func resumeProcess(p *os.Process) error { return errors.ErrUnsupported }
@@ -0,0 +1,13 @@
+//go:build unix
+
+package transcode
+
+import (
+	"os"
+	"syscall"
+)
+
+// pauseProcess stops a process in place; resumeProcess lets it go on
+func pauseProcess(p *os.Process) error { return p.Signal(syscall.SIGSTOP) }
+
+func resumeProcess(p *os.Process) error { return p.Signal(syscall.SIGCONT) }
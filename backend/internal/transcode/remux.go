@@ -0,0 +1,48 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// RemuxMP4 copies the first video and audio streams of src into fragmented
+// MP4 on w, starting at startSec. Nothing is re-encoded, so this costs about
+// as much CPU as reading the file; seeking is done by requesting a new start.
+func RemuxMP4(ctx context.Context, w io.Writer, src string, startSec float64, videoCodec string) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+	if startSec > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(startSec, 'f', 3, 64))
+	}
+	args = append(args,
+		"-i", src,
+		"-map", "0:v:0?", "-map", "0:a:0?",
+		"-c", "copy", "-sn", "-dn",
+	)
+	if videoCodec == "hevc" {
+		// Safari and Chrome only accept HEVC in MP4 tagged as hvc1
+		args = append(args, "-tag:v", "hvc1")
+	}
+	args = append(args,
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1",
+	)
+
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			// Client went away or seeked: not an error
+			return nil
+		}
+		log.Printf("remux %s: %s", src, strings.TrimSpace(stderr.String()))
+		return fmt.Errorf("ffmpeg remux: %w", err)
+	}
+	return nil
+}
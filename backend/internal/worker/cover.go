@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// errNoCoverArt means the audio file has no embedded picture and no cover
+// image next to it; not worth retrying until the file or folder changes.
+var errNoCoverArt = errors.New("no cover art found")
+
+// Folder images checked (case-insensitively) when a file has no embedded art
+var folderCoverNames = []string{"cover", "folder", "front", "album"}
+var folderCoverExts = []string{".jpg", ".jpeg", ".png"}
+
+// generateAudioThumb resolves the cover for an audio item. Art is stored once
+// per album (album-<id>.jpg) and shared by every track of that album.
+// Returns the thumbnail path to store on the item.
+func (w *ThumbWorker) generateAudioThumb(ctx context.Context, itemID int64, src string) (string, error) {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return "", fmt.Errorf("source file does not exist: %s", src)
+	}
+
+	var albumID int64
+	var coverPath string
+	err := w.DB.QueryRow(ctx, `
+		SELECT t.album_id, coalesce(al.cover_path, '')
+		FROM track t
+		JOIN album al ON al.id = t.album_id
+		WHERE t.item_id = $1`, itemID).Scan(&albumID, &coverPath)
+	if err != nil {
+		// No tags parsed for this item: keep the art per file
+		dst := filepath.Join(w.Cfg.ThumbDir, fmt.Sprintf("%d.jpg", itemID))
+		return dst, w.extractCoverArt(src, dst)
+	}
+
+	// Another track of the album already produced the cover, and neither this
+	// file nor the folder image changed since
+	if coverPath != "" {
+		if fi, err := os.Stat(coverPath); err == nil && !coverOutdated(fi.ModTime(), src) {
+			return coverPath, nil
+		}
+	}
+
+	dst := filepath.Join(w.Cfg.ThumbDir, fmt.Sprintf("album-%d.jpg", albumID))
+	if err := w.extractCoverArt(src, dst); err != nil {
+		return "", err
+	}
+
+	_, _ = w.DB.Exec(ctx, "UPDATE album SET cover_path = $2 WHERE id = $1", albumID, dst)
+	_, _ = w.DB.Exec(ctx, `
+		UPDATE media_item SET thumb_path = $2
+		WHERE id IN (SELECT item_id FROM track WHERE album_id = $1)`, albumID, dst)
+	return dst, nil
+}
+
+// coverOutdated reports whether the audio file (its embedded picture) or the
+// cover image of its folder changed after the album cover was made
+func coverOutdated(made time.Time, src string) bool {
+	if fi, err := os.Stat(src); err == nil && fi.ModTime().After(made) {
+		return true
+	}
+	if folderImage := findFolderCover(filepath.Dir(src)); folderImage != "" {
+		if fi, err := os.Stat(folderImage); err == nil && fi.ModTime().After(made) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractCoverArt writes the embedded picture (ID3 APIC, FLAC PICTURE, MP4 covr)
+// to dst, falling back to a cover.jpg/folder.jpg style image in the same directory
+func (w *ThumbWorker) extractCoverArt(src, dst string) error {
+	// ffmpeg exposes embedded pictures as an attached_pic video stream
+	cmd := exec.Command("ffmpeg",
+		"-y",
+		"-i", src,
+		"-an",
+		"-map", "0:v:0",
+		"-frames:v", "1",
+		"-vf", "scale='min(320,iw)':-2",
+		"-q:v", "5",
+		dst,
+	)
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+
+	folderImage := findFolderCover(filepath.Dir(src))
+	if folderImage == "" {
+		return errNoCoverArt
+	}
+	return w.generatePhotoThumb(folderImage, dst)
+}
+
+// isFolderCover reports whether a file is an image findFolderCover looks for
+func isFolderCover(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	ext := filepath.Ext(base)
+	return slices.Contains(folderCoverExts, ext) && slices.Contains(folderCoverNames, strings.TrimSuffix(base, ext))
+}
+
+// queueFolderAudioThumbs queues thumb jobs for the audio items of a directory
+func (w *ThumbWorker) queueFolderAudioThumbs(ctx context.Context, dir string) {
+	_, _ = w.DB.Exec(ctx, `
+		INSERT INTO job (kind, item_id)
+		SELECT 'thumb', mi.id
+		FROM media_item mi
+		WHERE mi.kind = 'audio' AND mi.present AND left(mi.path, length($1::text) + 1) = $1::text || '/'
+		  AND strpos(substr(mi.path, length($1::text) + 2), '/') = 0
+		  AND NOT EXISTS (SELECT 1 FROM job WHERE kind = 'thumb' AND item_id = mi.id)`, dir)
+}
+
+// findFolderCover returns the first cover-like image in dir, or ""
+func findFolderCover(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	byName := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			byName[strings.ToLower(e.Name())] = e.Name()
+		}
+	}
+	for _, name := range folderCoverNames {
+		for _, ext := range folderCoverExts {
+			if real, ok := byName[name+ext]; ok {
+				return filepath.Join(dir, real)
+			}
+		}
+	}
+	return ""
+}
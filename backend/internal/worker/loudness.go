@@ -0,0 +1,199 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/mediahub/internal/config"
+	"github.com/example/mediahub/internal/media"
+)
+
+const maxLoudnessAttempts = 3 // Maximum retry attempts before giving up
+
+// LoudnessWorker processes loudness jobs: reads ReplayGain/R128 tags or
+// measures EBU R128 loudness with ffmpeg, then derives the album gain once
+// every track of the album has been analyzed
+type LoudnessWorker struct {
+	DB  *pgxpool.Pool
+	Cfg config.Config
+}
+
+func NewLoudnessWorker(db *pgxpool.Pool, cfg config.Config) *LoudnessWorker {
+	return &LoudnessWorker{DB: db, Cfg: cfg}
+}
+
+// Run starts the worker loop
+func (w *LoudnessWorker) Run(ctx context.Context) {
+	log.Println("loudness worker started")
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("loudness worker stopped")
+			return
+		case <-ticker.C:
+			w.processJobs(ctx)
+		}
+	}
+}
+
+func (w *LoudnessWorker) processJobs(ctx context.Context) {
+	// Get pending loudness jobs; ebur128 decodes the whole file so keep batches small
+	rows, err := w.DB.Query(ctx, `
+		SELECT j.id, j.item_id, mi.path, j.attempts
+		FROM job j
+		JOIN media_item mi ON mi.id = j.item_id
+		WHERE j.kind = 'loudness' AND j.locked_at IS NULL
+		ORDER BY j.run_at ASC
+		LIMIT 5
+	`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	type loudnessJob struct {
+		jobID    int64
+		itemID   int64
+		path     string
+		attempts int
+	}
+
+	var jobs []loudnessJob
+	for rows.Next() {
+		var j loudnessJob
+		if err := rows.Scan(&j.jobID, &j.itemID, &j.path, &j.attempts); err != nil {
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		// Lock the job
+		_, err := w.DB.Exec(ctx, "UPDATE job SET locked_at = NOW() WHERE id = $1", j.jobID)
+		if err != nil {
+			continue
+		}
+
+		err = w.analyze(ctx, j.itemID, j.path)
+		if err != nil {
+			newAttempts := j.attempts + 1
+			if newAttempts >= maxLoudnessAttempts {
+				log.Printf("loudness job %d permanently failed after %d attempts: %v", j.jobID, newAttempts, err)
+				w.giveUp(ctx, j.itemID)
+				_, _ = w.DB.Exec(ctx, "DELETE FROM job WHERE id = $1", j.jobID)
+			} else {
+				log.Printf("loudness job %d failed (attempt %d/%d): %v", j.jobID, newAttempts, maxLoudnessAttempts, err)
+				_, _ = w.DB.Exec(ctx, "UPDATE job SET locked_at = NULL, attempts = attempts + 1, last_error = $2 WHERE id = $1", j.jobID, err.Error())
+			}
+			continue
+		}
+
+		_, _ = w.DB.Exec(ctx, "DELETE FROM job WHERE id = $1", j.jobID)
+	}
+}
+
+func (w *LoudnessWorker) analyze(ctx context.Context, itemID int64, path string) error {
+	var albumID int64
+	if err := w.DB.QueryRow(ctx, "SELECT album_id FROM track WHERE item_id = $1", itemID).Scan(&albumID); err != nil {
+		// Not a tagged track (yet); the metadata worker queues a new job once it is
+		return nil
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("source file does not exist: %s", path)
+	}
+
+	probe, err := media.Probe(ctx, path)
+	if err != nil {
+		return err
+	}
+	tags := media.ReadLoudnessTags(probe)
+
+	track, source := tags.Track, "tags"
+	if track == nil {
+		analyzeCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+		track, err = media.MeasureLoudness(analyzeCtx, path)
+		cancel()
+		if err != nil {
+			return err
+		}
+		source = "ebur128"
+	}
+
+	_, err = w.DB.Exec(ctx, `
+		UPDATE track SET loudness_lufs = $2, gain_db = $3, peak = $4, loudness_source = $5, loudness_at = NOW()
+		WHERE item_id = $1`, itemID, track.LUFS, track.GainDB, track.Peak, source)
+	if err != nil {
+		return err
+	}
+
+	// Album gain from tags is authoritative; keep the first one seen
+	if tags.Album != nil {
+		_, err = w.DB.Exec(ctx, `
+			UPDATE album SET gain_db = $2, peak = $3, loudness_source = 'tags'
+			WHERE id = $1 AND loudness_source IS DISTINCT FROM 'tags'`, albumID, tags.Album.GainDB, tags.Album.Peak)
+		return err
+	}
+	return w.updateAlbumGain(ctx, albumID)
+}
+
+// giveUp marks a track that can't be analyzed as attempted without a loudness,
+// so that its album gain is computed from the other tracks instead of waiting
+// for it forever
+func (w *LoudnessWorker) giveUp(ctx context.Context, itemID int64) {
+	var albumID int64
+	err := w.DB.QueryRow(ctx, `
+		UPDATE track SET loudness_lufs = NULL, loudness_at = NOW()
+		WHERE item_id = $1
+		RETURNING album_id`, itemID).Scan(&albumID)
+	if err != nil {
+		return
+	}
+	if err := w.updateAlbumGain(ctx, albumID); err != nil {
+		log.Printf("loudness album %d: %v", albumID, err)
+	}
+}
+
+// updateAlbumGain computes the album gain once all present tracks are analyzed
+// (tracks that failed have loudness_at but no loudness, and are left out).
+// Album loudness is the duration-weighted energy mean of track loudness, which
+// approximates measuring the concatenated album; the peak is the loudest track peak.
+func (w *LoudnessWorker) updateAlbumGain(ctx context.Context, albumID int64) error {
+	var pending int
+	var lufs, peak *float64
+	var source *string
+	err := w.DB.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE t.loudness_at IS NULL),
+			10 * log(SUM(GREATEST(coalesce(t.duration_ms, 1), 1) * power(10, t.loudness_lufs / 10.0))
+			         / SUM(GREATEST(coalesce(t.duration_ms, 1), 1)) FILTER (WHERE t.loudness_lufs IS NOT NULL)),
+			MAX(t.peak),
+			(SELECT loudness_source FROM album WHERE id = $1)
+		FROM track t
+		JOIN media_item mi ON mi.id = t.item_id
+		WHERE t.album_id = $1 AND mi.present = true`, albumID).Scan(&pending, &lufs, &peak, &source)
+	if err != nil {
+		return err
+	}
+	if pending > 0 || lufs == nil {
+		return nil
+	}
+	if source != nil && *source == "tags" {
+		return nil
+	}
+
+	_, err = w.DB.Exec(ctx, `
+		UPDATE album SET gain_db = $2, peak = $3, loudness_source = 'computed'
+		WHERE id = $1`, albumID, media.ReplayGainReference-*lufs, peak)
+	return err
+}
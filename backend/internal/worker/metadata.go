@@ -0,0 +1,267 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/mediahub/internal/config"
+	"github.com/example/mediahub/internal/media"
+)
+
+const maxMetadataAttempts = 3 // Maximum retry attempts before giving up
+
+// MetadataWorker processes metadata jobs: probes files with ffprobe, stores
+// technical info on media_item and builds the music library from audio tags
+type MetadataWorker struct {
+	DB  *pgxpool.Pool
+	Cfg config.Config
+}
+
+func NewMetadataWorker(db *pgxpool.Pool, cfg config.Config) *MetadataWorker {
+	return &MetadataWorker{DB: db, Cfg: cfg}
+}
+
+// Run starts the worker loop
+func (w *MetadataWorker) Run(ctx context.Context) {
+	log.Println("metadata worker started")
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("metadata worker stopped")
+			return
+		case <-ticker.C:
+			w.processJobs(ctx)
+		}
+	}
+}
+
+func (w *MetadataWorker) processJobs(ctx context.Context) {
+	// Get pending metadata jobs
+	rows, err := w.DB.Query(ctx, `
+		SELECT j.id, j.item_id, mi.path, mi.rel_path, mi.kind, j.attempts
+		FROM job j
+		JOIN media_item mi ON mi.id = j.item_id
+		WHERE j.kind = 'metadata' AND j.locked_at IS NULL
+		ORDER BY j.run_at ASC
+		LIMIT 20
+	`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	type metadataJob struct {
+		jobID    int64
+		itemID   int64
+		path     string
+		relPath  string
+		kind     string
+		attempts int
+	}
+
+	var jobs []metadataJob
+	for rows.Next() {
+		var j metadataJob
+		if err := rows.Scan(&j.jobID, &j.itemID, &j.path, &j.relPath, &j.kind, &j.attempts); err != nil {
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		// Lock the job
+		_, err := w.DB.Exec(ctx, "UPDATE job SET locked_at = NOW() WHERE id = $1", j.jobID)
+		if err != nil {
+			continue
+		}
+
+		err = w.extractMetadata(ctx, j.itemID, j.path, j.relPath, j.kind)
+		if err != nil {
+			newAttempts := j.attempts + 1
+			if newAttempts >= maxMetadataAttempts {
+				log.Printf("metadata job %d permanently failed after %d attempts: %v", j.jobID, newAttempts, err)
+				_, _ = w.DB.Exec(ctx, "DELETE FROM job WHERE id = $1", j.jobID)
+			} else {
+				log.Printf("metadata job %d failed (attempt %d/%d): %v", j.jobID, newAttempts, maxMetadataAttempts, err)
+				_, _ = w.DB.Exec(ctx, "UPDATE job SET locked_at = NULL, attempts = attempts + 1, last_error = $2 WHERE id = $1", j.jobID, err.Error())
+			}
+			continue
+		}
+
+		_, _ = w.DB.Exec(ctx, "DELETE FROM job WHERE id = $1", j.jobID)
+	}
+}
+
+func (w *MetadataWorker) extractMetadata(ctx context.Context, itemID int64, path, relPath, kind string) error {
+	if kind != "audio" && kind != "video" {
+		// Nothing to probe for photos/other yet
+		return nil
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("source file does not exist: %s", path)
+	}
+
+	probe, err := media.Probe(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	var width, height *int
+	var codec *string
+	if st := probe.FirstStream(kind); st != nil {
+		if st.Width > 0 && st.Height > 0 {
+			width, height = &st.Width, &st.Height
+		}
+		if st.CodecName != "" {
+			codec = &st.CodecName
+		}
+	}
+	var durationMs *int
+	if d := probe.DurationMs(); d > 0 {
+		durationMs = &d
+	}
+
+	si := probe.StreamInfo(kind, path)
+	_, err = w.DB.Exec(ctx, `
+		UPDATE media_item SET duration_ms = $2, width = $3, height = $4, codec = $5,
+		       container = NULLIF($6, ''), video_profile = NULLIF($7, ''), pix_fmt = NULLIF($8, ''),
+		       audio_codec = NULLIF($9, ''), audio_channels = NULLIF($10, 0), bit_rate = NULLIF($11, 0),
+		       updated_at = NOW()
+		WHERE id = $1`, itemID, durationMs, width, height, codec,
+		si.Container, si.VideoProfile, si.PixFmt, si.AudioCodec, si.AudioChannels, si.BitRate)
+	if err != nil {
+		return err
+	}
+
+	if kind == "audio" {
+		if err := w.upsertTrack(ctx, itemID, media.ParseAudioTags(probe, path)); err != nil {
+			return err
+		}
+		w.storeEmbeddedLyrics(ctx, itemID, media.ReadEmbeddedLyrics(probe, path))
+		// Cover art and album gain are resolved per album, so they need the track row first
+		for _, jobKind := range []string{"thumb", "loudness"} {
+			_, _ = w.DB.Exec(ctx, `
+				INSERT INTO job (kind, item_id)
+				SELECT $2, $1
+				WHERE NOT EXISTS (SELECT 1 FROM job WHERE kind = $2 AND item_id = $1)`, itemID, jobKind)
+		}
+	}
+	if kind == "video" {
+		w.storeEmbeddedSubtitles(ctx, itemID, probe)
+		return w.extractVideoInfo(ctx, itemID, relPath)
+	}
+	return nil
+}
+
+// upsertTrack stores an audio item in the artist/album/track tables
+func (w *MetadataWorker) upsertTrack(ctx context.Context, itemID int64, t media.AudioTags) error {
+	tx, err := w.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	artistID, err := upsertArtist(ctx, tx, t.Artist)
+	if err != nil {
+		return err
+	}
+	albumArtistID := artistID
+	if !strings.EqualFold(t.AlbumArtist, t.Artist) {
+		if albumArtistID, err = upsertArtist(ctx, tx, t.AlbumArtist); err != nil {
+			return err
+		}
+	}
+
+	var albumID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO album (artist_id, title, year, genre)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''))
+		ON CONFLICT (artist_id, title) DO UPDATE SET
+			year = COALESCE(album.year, EXCLUDED.year),
+			genre = COALESCE(album.genre, EXCLUDED.genre)
+		RETURNING id`, albumArtistID, t.Album, t.Year, t.Genre).Scan(&albumID)
+	if err != nil {
+		return fmt.Errorf("upsert album: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO track (item_id, album_id, artist_id, title, track_no, disc_no, year, genre, duration_ms, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, 0), NULLIF($7, 0), NULLIF($8, ''), NULLIF($9, 0), NOW())
+		ON CONFLICT (item_id) DO UPDATE SET
+			album_id = EXCLUDED.album_id,
+			artist_id = EXCLUDED.artist_id,
+			title = EXCLUDED.title,
+			track_no = EXCLUDED.track_no,
+			disc_no = EXCLUDED.disc_no,
+			year = EXCLUDED.year,
+			genre = EXCLUDED.genre,
+			duration_ms = EXCLUDED.duration_ms,
+			updated_at = NOW()`,
+		itemID, albumID, artistID, t.Title, t.TrackNo, t.DiscNo, t.Year, t.Genre, t.DurationMs)
+	if err != nil {
+		return fmt.Errorf("upsert track: %w", err)
+	}
+
+	// Drop albums/artists left empty by a retag
+	_, _ = tx.Exec(ctx, "DELETE FROM album a WHERE NOT EXISTS (SELECT 1 FROM track t WHERE t.album_id = a.id)")
+	_, _ = tx.Exec(ctx, `
+		DELETE FROM artist ar
+		WHERE NOT EXISTS (SELECT 1 FROM track t WHERE t.artist_id = ar.id)
+		  AND NOT EXISTS (SELECT 1 FROM album a WHERE a.artist_id = ar.id)`)
+
+	return tx.Commit(ctx)
+}
+
+// storeEmbeddedLyrics replaces the embedded lyrics of an item (best-effort)
+func (w *MetadataWorker) storeEmbeddedLyrics(ctx context.Context, itemID int64, l *media.EmbeddedLyrics) {
+	if l == nil {
+		_, _ = w.DB.Exec(ctx, "DELETE FROM lyrics WHERE item_id = $1 AND source = 'embedded'", itemID)
+		return
+	}
+	_, err := w.DB.Exec(ctx, `
+		INSERT INTO lyrics (item_id, source, synced, lang, content, updated_at)
+		VALUES ($1, 'embedded', $2, NULLIF($3, ''), $4, NOW())
+		ON CONFLICT (item_id, source) DO UPDATE SET
+			synced = EXCLUDED.synced, lang = EXCLUDED.lang, content = EXCLUDED.content, updated_at = NOW()`,
+		itemID, l.Synced, l.Lang, l.Content)
+	if err != nil {
+		log.Printf("failed to store lyrics for item %d: %v", itemID, err)
+	}
+}
+
+// upsertArtist returns the id of the artist with the given name, creating it if needed
+func upsertArtist(ctx context.Context, tx pgx.Tx, name string) (int64, error) {
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO artist (name, sort_name) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id`, name, sortName(name)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("upsert artist: %w", err)
+	}
+	return id, nil
+}
+
+// sortName drops a leading article so "The Beatles" sorts under B
+func sortName(name string) string {
+	lower := strings.ToLower(name)
+	for _, article := range []string{"the ", "a ", "an "} {
+		if strings.HasPrefix(lower, article) && len(name) > len(article) {
+			return strings.TrimSpace(lower[len(article):])
+		}
+	}
+	return lower
+}
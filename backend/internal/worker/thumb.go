@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/example/mediahub/internal/config"
+	"github.com/example/mediahub/internal/transcode"
 )
 
 const maxThumbAttempts = 5 // Maximum retry attempts before giving up
@@ -90,9 +92,23 @@ func (w *ThumbWorker) processJobs(ctx context.Context) {
 		}
 
 		// Generate thumbnail
-		thumbPath := filepath.Join(w.Cfg.ThumbDir, fmt.Sprintf("%d.jpg", j.itemID))
-		err = w.generateThumbnail(j.path, thumbPath, j.kind)
+		var thumbPath string
+		if j.kind == "audio" {
+			thumbPath, err = w.generateAudioThumb(ctx, j.itemID, j.path)
+		} else {
+			thumbPath = filepath.Join(w.Cfg.ThumbDir, fmt.Sprintf("%d.jpg", j.itemID))
+			if j.kind == "video" && w.localArtworkThumb(ctx, j.itemID, thumbPath) {
+				err = nil
+			} else {
+				err = w.generateThumbnail(j.path, thumbPath, j.kind)
+			}
+		}
 
+		if errors.Is(err, errNoCoverArt) {
+			// Nothing to retry: the item simply has no artwork
+			_, _ = w.DB.Exec(ctx, "DELETE FROM job WHERE id = $1", j.jobID)
+			continue
+		}
 		if err != nil {
 			newAttempts := j.attempts + 1
 			if newAttempts >= maxThumbAttempts {
@@ -113,6 +129,11 @@ func (w *ThumbWorker) processJobs(ctx context.Context) {
 			log.Printf("failed to update thumb_path for item %d: %v", j.itemID, err)
 		}
 
+		// A new or replaced cover.jpg: the tracks next to it check their album art
+		if j.kind == "photo" && isFolderCover(j.path) {
+			w.queueFolderAudioThumbs(ctx, filepath.Dir(j.path))
+		}
+
 		// Delete job
 		_, _ = w.DB.Exec(ctx, "DELETE FROM job WHERE id = $1", j.jobID)
 		log.Printf("generated thumbnail for item %d", j.itemID)
@@ -135,14 +156,8 @@ func (w *ThumbWorker) generateThumbnail(srcPath, dstPath, kind string) error {
 }
 
 func (w *ThumbWorker) generatePhotoThumb(src, dst string) error {
-	// Use ImageMagick convert
-	// Resize to 320px wide, preserve aspect ratio, strip metadata
-	cmd := exec.Command("convert", src, "-thumbnail", "320x320>", "-quality", "85", "-strip", dst)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("convert failed: %v, output: %s", err, strings.TrimSpace(string(output)))
-	}
-	return nil
+	// Same pipeline as /api/items/{id}/image: fit in 320x320, EXIF-oriented, stripped
+	return transcode.ResizeImage(context.Background(), src, dst, transcode.ThumbnailOptions)
 }
 
 func (w *ThumbWorker) generateVideoThumb(src, dst string) error {
@@ -201,3 +216,22 @@ func (w *ThumbWorker) getVideoDuration(src string) float64 {
 	}
 	return duration
 }
+
+// localArtworkThumb builds the thumbnail of a video from its poster (or fanart)
+// sidecar when there is one; local artwork is preferred over a grabbed frame
+func (w *ThumbWorker) localArtworkThumb(ctx context.Context, itemID int64, dst string) bool {
+	var artwork string
+	err := w.DB.QueryRow(ctx, `
+		SELECT path FROM item_sidecar
+		WHERE item_id = $1 AND kind IN ('poster', 'fanart')
+		ORDER BY kind = 'poster' DESC
+		LIMIT 1`, itemID).Scan(&artwork)
+	if err != nil {
+		return false
+	}
+	if err := w.generatePhotoThumb(artwork, dst); err != nil {
+		log.Printf("thumb: local artwork %s unusable, grabbing a frame: %v", artwork, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,252 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/example/mediahub/internal/media"
+)
+
+// upsertVideoInfo links a video item to its series/season or movie, derived
+// from the library-relative path. Unrecognized videos are unlinked.
+func (w *MetadataWorker) upsertVideoInfo(ctx context.Context, itemID int64, info media.VideoInfo) error {
+	tx, err := w.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, _ = tx.Exec(ctx, "DELETE FROM episode WHERE item_id = $1", itemID)
+	_, _ = tx.Exec(ctx, "DELETE FROM movie_file WHERE item_id = $1", itemID)
+
+	switch info.Kind {
+	case "episode":
+		var seriesID, seasonID int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO series (title, sort_title, year) VALUES ($1, $2, NULLIF($3, 0))
+			ON CONFLICT (lower(title)) DO UPDATE SET year = COALESCE(series.year, EXCLUDED.year)
+			RETURNING id`, info.Series, sortName(info.Series), info.SeriesYear).Scan(&seriesID)
+		if err != nil {
+			return fmt.Errorf("upsert series: %w", err)
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO season (series_id, number) VALUES ($1, $2)
+			ON CONFLICT (series_id, number) DO UPDATE SET number = EXCLUDED.number
+			RETURNING id`, seriesID, info.Season).Scan(&seasonID)
+		if err != nil {
+			return fmt.Errorf("upsert season: %w", err)
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO episode (item_id, series_id, season_id, episode_no, episode_end, title, updated_at)
+			VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, ''), NOW())`,
+			itemID, seriesID, seasonID, info.Episode, info.EpisodeEnd, info.EpisodeTitle)
+		if err != nil {
+			return fmt.Errorf("insert episode: %w", err)
+		}
+	case "movie":
+		var movieID int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO movie (title, sort_title, year) VALUES ($1, $2, NULLIF($3, 0))
+			ON CONFLICT (lower(title), coalesce(year, 0)) DO UPDATE SET title = movie.title
+			RETURNING id`, info.Title, sortName(info.Title), info.Year).Scan(&movieID)
+		if err != nil {
+			return fmt.Errorf("upsert movie: %w", err)
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO movie_file (item_id, movie_id, updated_at) VALUES ($1, $2, NOW())", itemID, movieID)
+		if err != nil {
+			return fmt.Errorf("insert movie file: %w", err)
+		}
+	}
+
+	// Drop entities left empty by a rename
+	_, _ = tx.Exec(ctx, "DELETE FROM season s WHERE NOT EXISTS (SELECT 1 FROM episode e WHERE e.season_id = s.id)")
+	_, _ = tx.Exec(ctx, "DELETE FROM series s WHERE NOT EXISTS (SELECT 1 FROM episode e WHERE e.series_id = s.id)")
+	_, _ = tx.Exec(ctx, "DELETE FROM movie m WHERE NOT EXISTS (SELECT 1 FROM movie_file f WHERE f.movie_id = m.id)")
+
+	return tx.Commit(ctx)
+}
+
+// extractVideoInfo recognizes the item from its path, lets Kodi NFO sidecars
+// override what the name gave, and stores the NFO metadata
+func (w *MetadataWorker) extractVideoInfo(ctx context.Context, itemID int64, relPath string) error {
+	info := media.ParseVideoPath(relPath)
+
+	sidecars := map[string]string{}
+	rows, err := w.DB.Query(ctx, "SELECT kind, path FROM item_sidecar WHERE item_id = $1", itemID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var kind, path string
+		if rows.Scan(&kind, &path) == nil {
+			sidecars[kind] = path
+		}
+	}
+	rows.Close()
+
+	var nfo, show *media.NFO
+	if p := sidecars["nfo"]; p != "" {
+		if nfo, err = media.ReadNFO(p); err != nil {
+			log.Printf("metadata: ignoring nfo %s: %v", p, err)
+		}
+	}
+	if p := sidecars["tvshow_nfo"]; p != "" {
+		if show, err = media.ReadNFO(p); err != nil || show.Kind != "tvshow" {
+			show = nil
+		}
+	}
+	applyNFO(&info, nfo, show)
+
+	if err := w.upsertVideoInfo(ctx, itemID, info); err != nil {
+		return err
+	}
+
+	if nfo == nil {
+		_, err = w.DB.Exec(ctx, "DELETE FROM video_meta WHERE item_id = $1", itemID)
+		return err
+	}
+	if err := w.storeVideoMeta(ctx, itemID, nfo); err != nil {
+		return err
+	}
+	if nfo.Kind == "movie" && nfo.SortTitle != "" {
+		_, _ = w.DB.Exec(ctx, `
+			UPDATE movie SET sort_title = lower($2)
+			WHERE id = (SELECT movie_id FROM movie_file WHERE item_id = $1)`, itemID, nfo.SortTitle)
+	}
+	if show != nil && info.Kind == "episode" {
+		w.storeSeriesMeta(ctx, itemID, show, sidecars["tvshow_nfo"])
+	}
+	return nil
+}
+
+// applyNFO overrides the name-derived info with NFO values
+func applyNFO(info *media.VideoInfo, nfo, show *media.NFO) {
+	if nfo != nil {
+		switch nfo.Kind {
+		case "movie":
+			*info = media.VideoInfo{Kind: "movie", Title: info.Title, Year: info.Year}
+			if nfo.Title != "" {
+				info.Title = nfo.Title
+			}
+			if nfo.Year > 0 {
+				info.Year = nfo.Year
+			}
+		case "episodedetails":
+			info.Kind = "episode"
+			if nfo.Season > 0 || info.Season == 0 {
+				info.Season = nfo.Season
+			}
+			if nfo.Episode > 0 {
+				info.Episode = nfo.Episode
+			}
+			if nfo.Title != "" {
+				info.EpisodeTitle = nfo.Title
+			}
+			if nfo.ShowTitle != "" {
+				info.Series = nfo.ShowTitle
+			}
+		}
+	}
+	if info.Kind == "episode" && show != nil && show.Title != "" {
+		info.Series = show.Title
+		if show.Year > 0 {
+			info.SeriesYear = show.Year
+		}
+	}
+	// An NFO without a usable title still leaves us nothing to group by
+	if info.Kind == "movie" && info.Title == "" || info.Kind == "episode" && info.Series == "" {
+		*info = media.VideoInfo{}
+	}
+}
+
+func (w *MetadataWorker) storeVideoMeta(ctx context.Context, itemID int64, n *media.NFO) error {
+	actors, _ := json.Marshal(nonNil(n.Actors))
+	ids, _ := json.Marshal(n.ExternalIDs)
+	_, err := w.DB.Exec(ctx, `
+		INSERT INTO video_meta (item_id, title, original_title, plot, tagline, year, premiered, runtime_min,
+		                        genres, studios, mpaa, rating, actors, external_ids, updated_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, 0), NULLIF($7, ''), NULLIF($8, 0),
+		        $9, $10, NULLIF($11, ''), $12, $13, $14, NOW())
+		ON CONFLICT (item_id) DO UPDATE SET
+			title = EXCLUDED.title, original_title = EXCLUDED.original_title, plot = EXCLUDED.plot,
+			tagline = EXCLUDED.tagline, year = EXCLUDED.year, premiered = EXCLUDED.premiered,
+			runtime_min = EXCLUDED.runtime_min, genres = EXCLUDED.genres, studios = EXCLUDED.studios,
+			mpaa = EXCLUDED.mpaa, rating = EXCLUDED.rating, actors = EXCLUDED.actors,
+			external_ids = EXCLUDED.external_ids, updated_at = NOW()`,
+		itemID, n.Title, n.OriginalTitle, n.Plot, n.Tagline, n.Year, n.Premiered, n.RuntimeMin,
+		nonNil(n.Genres), nonNil(n.Studios), n.MPAA, n.Rating, actors, ids)
+	return err
+}
+
+// storeSeriesMeta copies tvshow.nfo fields and the show folder artwork onto the series
+func (w *MetadataWorker) storeSeriesMeta(ctx context.Context, itemID int64, show *media.NFO, nfoPath string) {
+	ids, _ := json.Marshal(show.ExternalIDs)
+	dir := filepath.Dir(nfoPath)
+	_, err := w.DB.Exec(ctx, `
+		UPDATE series SET plot = NULLIF($2, ''), genres = $3, rating = $4, external_ids = $5,
+		                  poster_path = NULLIF($6, ''), fanart_path = NULLIF($7, '')
+		WHERE id = (SELECT series_id FROM episode WHERE item_id = $1)`,
+		itemID, show.Plot, nonNil(show.Genres), show.Rating, ids,
+		findImage(dir, "poster", "folder"), findImage(dir, "fanart", "backdrop"))
+	if err != nil {
+		log.Printf("metadata: series for item %d: %v", itemID, err)
+	}
+}
+
+// findImage returns the first existing <dir>/<name>.jpg|jpeg|png
+func findImage(dir string, names ...string) string {
+	for _, name := range names {
+		for _, ext := range []string{".jpg", ".jpeg", ".png"} {
+			p := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(p); err == nil {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// nonNil keeps empty lists as [] rather than NULL in the DB
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// storeEmbeddedSubtitles records the text subtitle tracks of a video;
+// bitmap tracks (PGS, VobSub) are skipped since they cannot become WebVTT
+func (w *MetadataWorker) storeEmbeddedSubtitles(ctx context.Context, itemID int64, probe *media.ProbeResult) {
+	keep := []int{}
+	for _, st := range probe.Streams {
+		if st.CodecType != "subtitle" || !media.TextSubtitleCodecs[st.CodecName] {
+			continue
+		}
+		keep = append(keep, st.Index)
+		_, err := w.DB.Exec(ctx, `
+			INSERT INTO subtitle (item_id, source, stream_index, format, lang, title, forced, sdh, is_default)
+			VALUES ($1, 'embedded', $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8)
+			ON CONFLICT (item_id, stream_index) WHERE source = 'embedded' DO UPDATE SET
+				format = EXCLUDED.format, lang = EXCLUDED.lang, title = EXCLUDED.title,
+				forced = EXCLUDED.forced, sdh = EXCLUDED.sdh, is_default = EXCLUDED.is_default`,
+			itemID, st.Index, st.CodecName, subtitleLang(st.Tags["language"]), st.Tags["title"],
+			st.Disposition["forced"] == 1, st.Disposition["hearing_impaired"] == 1, st.Disposition["default"] == 1)
+		if err != nil {
+			log.Printf("metadata: subtitle stream %d of item %d: %v", st.Index, itemID, err)
+		}
+	}
+	_, _ = w.DB.Exec(ctx, `
+		DELETE FROM subtitle WHERE item_id = $1 AND source = 'embedded' AND NOT (stream_index = ANY($2))`, itemID, keep)
+}
+
+// subtitleLang drops the "und" placeholder Matroska uses for untagged tracks
+func subtitleLang(lang string) string {
+	if lang == "und" {
+		return ""
+	}
+	return lang
+}
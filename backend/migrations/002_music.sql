@@ -0,0 +1,34 @@
+-- music library: normalized artist/album/track built from audio media_item rows
+create table if not exists artist (
+  id bigserial primary key,
+  name text not null unique,
+  sort_name text not null,
+  created_at timestamptz not null default now()
+);
+
+create table if not exists album (
+  id bigserial primary key,
+  artist_id bigint not null references artist(id) on delete cascade,
+  title text not null,
+  year integer,
+  genre text,
+  created_at timestamptz not null default now(),
+  unique(artist_id, title)
+);
+
+create table if not exists track (
+  item_id bigint primary key references media_item(id) on delete cascade,
+  album_id bigint not null references album(id) on delete cascade,
+  artist_id bigint not null references artist(id) on delete cascade,
+  title text not null,
+  track_no integer,
+  disc_no integer,
+  year integer,
+  genre text,
+  duration_ms integer,
+  updated_at timestamptz not null default now()
+);
+
+create index if not exists idx_track_album on track(album_id, disc_no, track_no);
+create index if not exists idx_track_artist on track(artist_id);
+create index if not exists idx_album_artist on album(artist_id);
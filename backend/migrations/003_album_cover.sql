@@ -0,0 +1,2 @@
+-- album-level cover art, shared by all tracks of the album
+alter table album add column if not exists cover_path text;
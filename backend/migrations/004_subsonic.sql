@@ -0,0 +1,2 @@
+-- Subsonic token auth needs a recoverable copy of the password (AES-GCM encrypted)
+alter table app_user add column if not exists subsonic_password text;
@@ -0,0 +1,12 @@
+-- lyrics per track: from .lrc/.txt sidecars or embedded USLT/SYLT tags
+create table if not exists lyrics (
+  item_id bigint not null references media_item(id) on delete cascade,
+  source text not null, -- 'sidecar' | 'embedded'
+  synced boolean not null default false,
+  lang text,
+  content text not null,
+  source_path text,
+  source_mtime timestamptz,
+  updated_at timestamptz not null default now(),
+  primary key(item_id, source)
+);
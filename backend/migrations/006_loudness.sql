@@ -0,0 +1,10 @@
+-- ReplayGain / EBU R128 loudness per track and album
+alter table track add column if not exists loudness_lufs real;
+alter table track add column if not exists gain_db real;
+alter table track add column if not exists peak real;
+alter table track add column if not exists loudness_source text; -- 'tags' | 'ebur128'
+alter table track add column if not exists loudness_at timestamptz;
+
+alter table album add column if not exists gain_db real;
+alter table album add column if not exists peak real;
+alter table album add column if not exists loudness_source text;
@@ -0,0 +1,45 @@
+-- movies and TV shows recognized from video file names and folders
+create table if not exists series (
+  id bigserial primary key,
+  title text not null,
+  sort_title text not null,
+  year integer,
+  created_at timestamptz not null default now()
+);
+create unique index if not exists idx_series_title on series(lower(title));
+
+create table if not exists season (
+  id bigserial primary key,
+  series_id bigint not null references series(id) on delete cascade,
+  number integer not null,
+  unique(series_id, number)
+);
+
+create table if not exists episode (
+  item_id bigint primary key references media_item(id) on delete cascade,
+  series_id bigint not null references series(id) on delete cascade,
+  season_id bigint not null references season(id) on delete cascade,
+  episode_no integer not null,
+  episode_end integer,
+  title text,
+  updated_at timestamptz not null default now()
+);
+create index if not exists idx_episode_season on episode(season_id, episode_no);
+create index if not exists idx_episode_series on episode(series_id);
+
+create table if not exists movie (
+  id bigserial primary key,
+  title text not null,
+  sort_title text not null,
+  year integer,
+  created_at timestamptz not null default now()
+);
+create unique index if not exists idx_movie_title_year on movie(lower(title), coalesce(year, 0));
+
+-- a movie may have several files (versions, parts)
+create table if not exists movie_file (
+  item_id bigint primary key references media_item(id) on delete cascade,
+  movie_id bigint not null references movie(id) on delete cascade,
+  updated_at timestamptz not null default now()
+);
+create index if not exists idx_movie_file_movie on movie_file(movie_id);
@@ -0,0 +1,36 @@
+-- Kodi-style sidecars found next to video files: NFO metadata and local artwork.
+-- kind: nfo | tvshow_nfo | poster | fanart
+create table if not exists item_sidecar (
+  item_id bigint not null references media_item(id) on delete cascade,
+  kind text not null,
+  path text not null,
+  mtime timestamptz,
+  primary key(item_id, kind)
+);
+
+-- metadata parsed from the item's NFO
+create table if not exists video_meta (
+  item_id bigint primary key references media_item(id) on delete cascade,
+  title text,
+  original_title text,
+  plot text,
+  tagline text,
+  year integer,
+  premiered text,
+  runtime_min integer,
+  genres text[] not null default '{}',
+  studios text[] not null default '{}',
+  mpaa text,
+  rating double precision,
+  actors jsonb not null default '[]',
+  external_ids jsonb not null default '{}',
+  updated_at timestamptz not null default now()
+);
+
+-- show-level metadata from tvshow.nfo and the show folder artwork
+alter table series add column if not exists plot text;
+alter table series add column if not exists genres text[] not null default '{}';
+alter table series add column if not exists rating double precision;
+alter table series add column if not exists external_ids jsonb not null default '{}';
+alter table series add column if not exists poster_path text;
+alter table series add column if not exists fanart_path text;
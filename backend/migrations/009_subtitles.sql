@@ -0,0 +1,18 @@
+-- text subtitles of video items: sidecar files (Movie.en.srt) and embedded tracks
+create table if not exists subtitle (
+  id bigserial primary key,
+  item_id bigint not null references media_item(id) on delete cascade,
+  source text not null,        -- sidecar | embedded
+  path text,                   -- sidecar file
+  stream_index integer,        -- embedded ffprobe stream index
+  format text not null,        -- srt | ass | ssa | vtt | subrip | mov_text ...
+  lang text,
+  title text,
+  forced boolean not null default false,
+  sdh boolean not null default false,
+  is_default boolean not null default false,
+  mtime timestamptz
+);
+create unique index if not exists idx_subtitle_sidecar on subtitle(item_id, path) where source = 'sidecar';
+create unique index if not exists idx_subtitle_embedded on subtitle(item_id, stream_index) where source = 'embedded';
+create index if not exists idx_subtitle_item on subtitle(item_id);
@@ -0,0 +1,8 @@
+-- stream details used to pick the playback method (direct play, remux, transcode).
+-- codec stays the main stream codec (video codec for videos, audio codec for audio).
+alter table media_item add column if not exists container text;
+alter table media_item add column if not exists video_profile text;
+alter table media_item add column if not exists pix_fmt text;
+alter table media_item add column if not exists audio_codec text;
+alter table media_item add column if not exists audio_channels integer;
+alter table media_item add column if not exists bit_rate bigint;
@@ -0,0 +1,8 @@
+-- per-user default audio transcoding (e.g. opus 96 kbps on a phone);
+-- applied to Subsonic streams and to the play_url of playback-info
+create table if not exists user_transcode_profile (
+  user_id bigint primary key references app_user(id) on delete cascade,
+  audio_format text not null,
+  audio_bitrate integer not null,
+  updated_at timestamptz not null default now()
+);
@@ -0,0 +1,16 @@
+-- browser sessions: the mh_session cookie holds a random token, only its
+-- sha256 is stored; csrf_token must come back in X-CSRF-Token on writes
+create table if not exists user_session (
+  id bigserial primary key,
+  user_id bigint not null references app_user(id) on delete cascade,
+  token_hash text not null unique,
+  csrf_token text not null,
+  user_agent text,
+  ip text,
+  created_at timestamptz not null default now(),
+  last_seen_at timestamptz not null default now(),
+  expires_at timestamptz not null
+);
+
+create index if not exists user_session_user_idx on user_session(user_id);
+create index if not exists user_session_expires_idx on user_session(expires_at);
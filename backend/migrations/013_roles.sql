@@ -0,0 +1,9 @@
+-- roles: admin manages libraries, users, scans and imports; viewer browses and plays
+alter table app_user add column if not exists role text not null default 'viewer'
+  check (role in ('admin', 'viewer'));
+
+-- existing installs: the oldest account (the bootstrap admin) becomes admin
+-- when nobody is, so there is always someone able to manage the server
+update app_user set role = 'admin'
+where id = (select min(id) from app_user)
+  and not exists (select 1 from app_user where role = 'admin');
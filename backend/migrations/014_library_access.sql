@@ -0,0 +1,12 @@
+-- per-user library access: a restricted library is only visible to admins
+-- and to the users granted access; unrestricted libraries stay visible to all
+alter table library add column if not exists restricted boolean not null default false;
+
+create table if not exists user_library (
+  user_id bigint not null references app_user(id) on delete cascade,
+  library_id bigint not null references library(id) on delete cascade,
+  created_at timestamptz not null default now(),
+  primary key(user_id, library_id)
+);
+
+create index if not exists user_library_library_idx on user_library(library_id);
@@ -0,0 +1,13 @@
+-- every login is a session: API clients hold a rotating refresh token,
+-- browsers the mh_session cookie (or both). Access JWTs name their session
+-- (sid) and die with it.
+alter table user_session alter column token_hash drop not null;
+alter table user_session alter column csrf_token drop not null;
+alter table user_session add column if not exists refresh_hash text;
+-- the token replaced by the last rotation: seeing it again means it leaked
+alter table user_session add column if not exists prev_refresh_hash text;
+alter table user_session add column if not exists rotated_at timestamptz;
+alter table user_session add column if not exists device text;
+
+create unique index if not exists user_session_refresh_idx on user_session(refresh_hash);
+create index if not exists user_session_prev_refresh_idx on user_session(prev_refresh_hash);
@@ -0,0 +1,15 @@
+-- personal API keys: "mh_<random>", only the sha256 is stored; prefix is the
+-- start of the key, shown in lists so users can tell their keys apart
+create table if not exists api_key (
+  id bigserial primary key,
+  user_id bigint not null references app_user(id) on delete cascade,
+  name text not null,
+  prefix text not null,
+  key_hash text not null unique,
+  scopes text[] not null default '{}',
+  created_at timestamptz not null default now(),
+  last_used_at timestamptz,
+  expires_at timestamptz
+);
+
+create index if not exists api_key_user_idx on api_key(user_id);
@@ -0,0 +1,22 @@
+-- single sign-on: an OIDC subject (per issuer) belongs to one local user
+create table if not exists user_identity (
+  id bigserial primary key,
+  user_id bigint not null references app_user(id) on delete cascade,
+  issuer text not null,
+  subject text not null,
+  email text,
+  created_at timestamptz not null default now(),
+  unique (issuer, subject)
+);
+
+create index if not exists user_identity_user_idx on user_identity(user_id);
+
+-- authorization requests in flight; link_user_id is set when a logged in
+-- user links their account rather than logging in
+create table if not exists oidc_login (
+  state_hash text primary key,
+  nonce text not null,
+  verifier text not null,
+  link_user_id bigint references app_user(id) on delete cascade,
+  created_at timestamptz not null default now()
+);
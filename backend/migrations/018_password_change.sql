@@ -0,0 +1,3 @@
+-- set on the bootstrap admin created with the default password (and on users
+-- an admin creates with it): the API only allows changing the password
+alter table app_user add column if not exists must_change_password boolean not null default false;
@@ -0,0 +1,21 @@
+-- TOTP two-factor authentication. totp_secret is AES-GCM encrypted like
+-- subsonic_password; it is set at enrollment and only used once verified
+-- (totp_enabled). totp_last_step stops a code from being used twice.
+alter table app_user add column if not exists totp_secret text;
+alter table app_user add column if not exists totp_enabled boolean not null default false;
+alter table app_user add column if not exists totp_last_step bigint not null default 0;
+
+create table if not exists user_recovery_code (
+  id bigserial primary key,
+  user_id bigint not null references app_user(id) on delete cascade,
+  code_hash text not null,
+  used_at timestamptz
+);
+
+create index if not exists user_recovery_code_user_idx on user_recovery_code(user_id);
+
+-- server-wide settings changed from the admin UI
+create table if not exists app_setting (
+  key text primary key,
+  value text not null
+);
@@ -0,0 +1,28 @@
+-- append-only log of administrative and destructive actions. actor_id has no
+-- foreign key and the name is copied, so entries outlive deleted users; rows
+-- are only ever deleted by retention (AUDIT_RETENTION_DAYS).
+create table if not exists audit_log (
+  id bigserial primary key,
+  created_at timestamptz not null default now(),
+  actor_id bigint,
+  actor_name text,
+  action text not null,
+  target_type text,
+  target_id text,
+  request_id text,
+  ip text,
+  details jsonb
+);
+
+create index if not exists audit_log_created_idx on audit_log(created_at);
+create index if not exists audit_log_action_idx on audit_log(action, created_at);
+
+create or replace function audit_log_no_update() returns trigger as $$
+begin
+  raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+
+drop trigger if exists audit_log_no_update on audit_log;
+create trigger audit_log_no_update before update on audit_log
+  for each row execute function audit_log_no_update();
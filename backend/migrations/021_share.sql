@@ -0,0 +1,29 @@
+-- public share links (/s/<token>) to one item, a folder of a library or a
+-- tag. Only the sha256 of the token is stored, like API keys; prefix is the
+-- start of the token, shown in lists. folder_path is relative to the library
+-- root ('' for all of it).
+create table if not exists share_link (
+  id bigserial primary key,
+  user_id bigint not null references app_user(id) on delete cascade,
+  kind text not null check (kind in ('item', 'folder', 'tag')),
+  item_id bigint references media_item(id) on delete cascade,
+  library_id bigint references library(id) on delete cascade,
+  folder_path text,
+  tag_id bigint references tag(id) on delete cascade,
+  name text not null,
+  prefix text not null,
+  token_hash text not null unique,
+  password_hash text,
+  allow_download boolean not null default false,
+  views integer not null default 0,
+  created_at timestamptz not null default now(),
+  last_viewed_at timestamptz,
+  expires_at timestamptz,
+  check (
+    (kind = 'item' and item_id is not null) or
+    (kind = 'folder' and library_id is not null and folder_path is not null) or
+    (kind = 'tag' and tag_id is not null)
+  )
+);
+
+create index if not exists share_link_user_idx on share_link(user_id);
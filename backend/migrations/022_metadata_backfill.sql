@@ -0,0 +1,19 @@
+-- one-time backfill: audio/video items indexed before the metadata worker
+-- existed never got a metadata job, so their music, series/movie, stream and
+-- subtitle details stay empty until the file changes. The app_setting marker
+-- makes later boots skip it.
+with first_run as (
+  insert into app_setting(key, value) values ('backfill_metadata_022', 'done')
+  on conflict (key) do nothing
+  returning key
+)
+insert into job(kind, item_id)
+select 'metadata', mi.id
+from media_item mi
+where exists (select 1 from first_run)
+  and mi.present and mi.kind in ('audio', 'video')
+  and (mi.container is null
+       or (mi.kind = 'audio' and not exists (select 1 from track t where t.item_id = mi.id))
+       or (mi.kind = 'video' and not exists (select 1 from episode e where e.item_id = mi.id)
+                             and not exists (select 1 from movie_file mf where mf.item_id = mi.id)))
+  and not exists (select 1 from job j where j.kind = 'metadata' and j.item_id = mi.id);
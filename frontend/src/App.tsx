@@ -1,5 +1,5 @@
 import { useEffect, useState } from 'react';
-import { getFavorites, getItems, getLibraries, getCurrentUser, login, logout, recordView, setFavorite, unsetFavorite, type MediaItem } from './api';
+import { changePassword, consumeOidcRedirect, enrollTwoFactor, getFavorites, getItems, getLibraries, getCurrentUser, getOidcEnabled, login, loginSecondFactor, logout, oidcLoginUrl, recordView, setFavorite, unsetFavorite, verifyTwoFactor, type MediaItem } from './api';
 
 // Components
 import { Card } from './components/common';
@@ -7,10 +7,39 @@ import { PlayerModal, LibraryModal } from './components/modals';
 import { HomeView, FavoritesView, TagsView, FolderBrowser, SearchView } from './components/views';
 import { SettingsView } from './components/views/SettingsView';
 
+// Runs once, before the first render reads the token
+const oidcError = consumeOidcRedirect();
+
 function Login({ onDone }: { onDone: () => void }) {
   const [u, setU] = useState('admin');
-  const [p, setP] = useState('admin');
-  const [err, setErr] = useState<string | null>(null);
+  const [p, setP] = useState('');
+  const [err, setErr] = useState<string | null>(oidcError);
+  const [sso, setSso] = useState(false);
+  const [challenge, setChallenge] = useState<string | null>(null);
+  const [code, setCode] = useState('');
+
+  useEffect(() => { getOidcEnabled().then(setSso); }, []);
+
+  if (challenge) {
+    return (
+      <div className="container">
+        <div className="glass login-panel">
+          <h2 className="mt-0">Two-factor authentication</h2>
+          <div className="row mb-md">
+            <input className="input flex-1" value={code} onChange={e => setCode(e.target.value)} placeholder="authenticator or recovery code" autoComplete="one-time-code" />
+          </div>
+          <div className="row">
+            <button className="btn" onClick={async () => {
+              setErr(null);
+              try { await loginSecondFactor(challenge, code); onDone(); } catch (e: any) { setErr(e.message); }
+            }}>Verify</button>
+            <button className="btn" onClick={() => { setChallenge(null); setCode(''); setErr(null); }}>Back</button>
+            {err && <span className="muted">{err}</span>}
+          </div>
+        </div>
+      </div>
+    );
+  }
 
   return (
     <div className="container">
@@ -23,8 +52,39 @@ function Login({ onDone }: { onDone: () => void }) {
         <div className="row">
           <button className="btn" onClick={async () => {
             setErr(null);
-            try { await login(u, p); onDone(); } catch (e: any) { setErr(e.message); }
+            try {
+              const c = await login(u, p);
+              if (c) setChallenge(c); else onDone();
+            } catch (e: any) { setErr(e.message); }
           }}>Login</button>
+          {sso && <a className="btn" href={oidcLoginUrl()}>Single sign-on</a>}
+          {err && <span className="muted">{err}</span>}
+        </div>
+      </div>
+    </div>
+  );
+}
+
+// The bootstrap admin (and users created so by an admin) must pick a new
+// password before anything else
+function ForcedPasswordChange({ onDone }: { onDone: () => void }) {
+  const [oldPass, setOldPass] = useState('');
+  const [newPass, setNewPass] = useState('');
+  const [err, setErr] = useState<string | null>(null);
+
+  return (
+    <div className="container">
+      <div className="glass login-panel">
+        <h2 className="mt-0">Choose a new password</h2>
+        <div className="row mb-md">
+          <input className="input flex-1" type="password" value={oldPass} onChange={e => setOldPass(e.target.value)} placeholder="current password" />
+          <input className="input flex-1" type="password" value={newPass} onChange={e => setNewPass(e.target.value)} placeholder="new password" />
+        </div>
+        <div className="row">
+          <button className="btn" onClick={async () => {
+            setErr(null);
+            try { await changePassword(oldPass, newPass); onDone(); } catch (e: any) { setErr(e.message); }
+          }}>Change password</button>
           {err && <span className="muted">{err}</span>}
         </div>
       </div>
@@ -32,6 +92,49 @@ function Login({ onDone }: { onDone: () => void }) {
   );
 }
 
+// Admins must enroll in 2FA first when an admin made it required
+function ForcedTwoFactorEnrollment({ onDone }: { onDone: () => void }) {
+  const [enrollment, setEnrollment] = useState<{ secret: string; otpauth_uri: string } | null>(null);
+  const [code, setCode] = useState('');
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+  const [err, setErr] = useState<string | null>(null);
+
+  useEffect(() => { enrollTwoFactor().then(setEnrollment).catch(e => setErr(e.message)); }, []);
+
+  return (
+    <div className="container">
+      <div className="glass login-panel">
+        <h2 className="mt-0">Set up two-factor authentication</h2>
+        {recoveryCodes ? (
+          <>
+            <p className="muted">Keep these recovery codes somewhere safe. Each works once if you lose your authenticator.</p>
+            <pre>{recoveryCodes.join('\n')}</pre>
+            <button className="btn" onClick={onDone}>Done</button>
+          </>
+        ) : (
+          <>
+            {enrollment && (
+              <p className="muted">
+                Add <a href={enrollment.otpauth_uri}>this account</a> to your authenticator app, or enter the key <code>{enrollment.secret}</code>, then type the code it shows.
+              </p>
+            )}
+            <div className="row mb-md">
+              <input className="input flex-1" value={code} onChange={e => setCode(e.target.value)} placeholder="6-digit code" autoComplete="one-time-code" />
+            </div>
+            <div className="row">
+              <button className="btn" onClick={async () => {
+                setErr(null);
+                try { setRecoveryCodes(await verifyTwoFactor(code)); } catch (e: any) { setErr(e.message); }
+              }}>Enable</button>
+              {err && <span className="muted">{err}</span>}
+            </div>
+          </>
+        )}
+      </div>
+    </div>
+  );
+}
+
 export default function App() {
   const [authed, setAuthed] = useState<boolean>(() => !!localStorage.getItem("mh_token"));
   const [libs, setLibs] = useState<Array<{ id: number; name: string; roots: string[] }>>([]);
@@ -50,21 +153,26 @@ export default function App() {
   const [selectedTagId, setSelectedTagId] = useState<number | null>(null);
 
   const [currentUsername, setCurrentUsername] = useState<string>('');
+  const [mustChangePassword, setMustChangePassword] = useState(false);
+  const [mustEnrollTwoFactor, setMustEnrollTwoFactor] = useState(false);
   const [homeRefreshKey, setHomeRefreshKey] = useState(0);
   const pageSize = 48;
 
   useEffect(() => {
     if (!authed) return;
     (async () => {
+      const me = await getCurrentUser();
+      setCurrentUsername(me.username);
+      setMustChangePassword(me.must_change_password);
+      setMustEnrollTwoFactor(me.two_factor_required);
+      if (me.must_change_password || me.two_factor_required) return;
       const l = await getLibraries();
       setLibs(l);
       if (!libId && l.length) setLibId(l[0].id);
       const fav = await getFavorites();
       setFavorites(new Set(fav.map(x => x.id)));
-      const me = await getCurrentUser();
-      setCurrentUsername(me.username);
     })().catch(() => { logout(); setAuthed(false); });
-  }, [authed]);
+  }, [authed, mustChangePassword, mustEnrollTwoFactor]);
 
   const reloadLibraries = async () => {
     const l = await getLibraries();
@@ -82,6 +190,8 @@ export default function App() {
   }, [authed, libId, kind, q, page, tab]);
 
   if (!authed) return <Login onDone={() => setAuthed(true)} />;
+  if (mustChangePassword) return <ForcedPasswordChange onDone={() => setMustChangePassword(false)} />;
+  if (mustEnrollTwoFactor) return <ForcedTwoFactorEnrollment onDone={() => setMustEnrollTwoFactor(false)} />;
 
   const maxPage = Math.max(1, Math.ceil(total / pageSize));
   const [mobileMenuOpen, setMobileMenuOpen] = useState(false);
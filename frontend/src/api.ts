@@ -17,14 +17,52 @@ const getApiBase = () => {
 };
 const API = getApiBase();
 
-export type LoginResponse = { token: string };
+export type LoginResponse = {
+  token: string;
+  refresh_token: string;
+  expires_in: number;
+  must_change_password?: boolean;
+  // With two-factor authentication the password step only returns a challenge
+  two_factor_required?: boolean;
+  challenge_token?: string;
+};
+
+// Access tokens are short-lived; on a 401 the refresh token is traded for a
+// new pair once (shared by concurrent requests) and the request retried
+let refreshing: Promise<boolean> | null = null;
+
+function refreshTokens(): Promise<boolean> {
+  const refresh = localStorage.getItem("mh_refresh");
+  if (!refresh) return Promise.resolve(false);
+  refreshing ??= fetch(`${API}/api/auth/refresh`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refresh_token: refresh }),
+  })
+    .then(async (res) => {
+      if (!res.ok) return false;
+      storeTokens((await res.json()) as LoginResponse);
+      return true;
+    })
+    .catch(() => false)
+    .finally(() => { refreshing = null; });
+  return refreshing;
+}
 
-export async function apiFetch(path: string, init: RequestInit = {}) {
+function storeTokens(data: LoginResponse) {
+  localStorage.setItem("mh_token", data.token);
+  localStorage.setItem("mh_refresh", data.refresh_token);
+}
+
+export async function apiFetch(path: string, init: RequestInit = {}, retry = true): Promise<Response> {
   const token = localStorage.getItem("mh_token");
   const headers = new Headers(init.headers || {});
   headers.set("Content-Type", headers.get("Content-Type") || "application/json");
   if (token) headers.set("Authorization", `Bearer ${token}`);
   const res = await fetch(`${API}${path}`, { ...init, headers });
+  if (res.status === 401 && retry && (await refreshTokens())) {
+    return apiFetch(path, init, false);
+  }
   if (!res.ok) {
     const t = await res.text().catch(() => "");
     throw new Error(t || `HTTP ${res.status}`);
@@ -32,24 +70,87 @@ export async function apiFetch(path: string, init: RequestInit = {}) {
   return res;
 }
 
-export async function login(username: string, password: string): Promise<string> {
+// Returns the challenge token when a second factor is needed, or null once logged in
+export async function login(username: string, password: string): Promise<string | null> {
   const res = await apiFetch("/api/auth/login", {
     method: "POST",
-    body: JSON.stringify({ username, password }),
+    body: JSON.stringify({ username, password, device: "Web" }),
     headers: { "Content-Type": "application/json" },
-  });
+  }, false);
   const data = (await res.json()) as LoginResponse;
-  localStorage.setItem("mh_token", data.token);
-  return data.token;
+  if (data.two_factor_required) return data.challenge_token ?? null;
+  storeTokens(data);
+  return null;
+}
+
+// Completes a login with an authenticator or recovery code
+export async function loginSecondFactor(challengeToken: string, code: string) {
+  const res = await apiFetch("/api/auth/login/2fa", {
+    method: "POST",
+    body: JSON.stringify({ challenge_token: challengeToken, code }),
+  }, false);
+  storeTokens((await res.json()) as LoginResponse);
 }
 
 export function logout() {
+  const token = localStorage.getItem("mh_token");
+  if (token) {
+    fetch(`${API}/api/auth/logout`, { method: "POST", headers: { Authorization: `Bearer ${token}` } }).catch(() => {});
+  }
   localStorage.removeItem("mh_token");
+  localStorage.removeItem("mh_refresh");
+}
+
+// Single sign-on (OpenID Connect)
+export async function getOidcEnabled() {
+  const res = await fetch(`${API}/api/auth/oidc/config`).catch(() => null);
+  if (!res?.ok) return false;
+  return ((await res.json()) as { enabled: boolean }).enabled;
+}
+
+export function oidcLoginUrl() {
+  return `${API}/api/auth/oidc/login`;
+}
+
+// The SSO callback comes back with #token=..&refresh_token=.. or #error=..;
+// store the tokens and clear the fragment. Returns the error, if any.
+export function consumeOidcRedirect(): string | null {
+  if (typeof window === "undefined" || !window.location.hash) return null;
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const token = params.get("token");
+  const error = params.get("error");
+  if (!token && !error && !params.has("oidc_linked")) return null;
+  history.replaceState(null, "", window.location.pathname + window.location.search);
+  if (token) {
+    storeTokens({ token, refresh_token: params.get("refresh_token") ?? "", expires_in: Number(params.get("expires_in")) });
+  }
+  return error;
+}
+
+// Opens the issuer to link it to the current account; the response sets the
+// cookie that ties the link to this browser, hence the credentials
+export async function linkOidc() {
+  const res = await apiFetch("/api/auth/oidc/link", { method: "POST", credentials: "include" });
+  const { url } = (await res.json()) as { url: string };
+  window.location.href = `${API}${url}`;
+}
+
+export type Identity = { id: number; issuer: string; subject: string; email: string; created_at: string };
+
+export async function getIdentities() {
+  const res = await apiFetch("/api/users/me/identities");
+  return res.json() as Promise<Identity[]>;
+}
+
+export async function unlinkIdentity(id: number) {
+  await apiFetch(`/api/users/me/identities/${id}`, { method: "DELETE" });
 }
 
+export type Library = { id: number; name: string; roots: string[]; restricted?: boolean };
+
 export async function getLibraries() {
   const res = await apiFetch("/api/libraries");
-  return res.json() as Promise<Array<{ id: number; name: string; roots: string[] }>>;
+  return res.json() as Promise<Library[]>;
 }
 
 export async function scanLibrary(libraryId: number) {
@@ -68,6 +169,19 @@ export async function deleteLibrary(id: number) {
   await apiFetch(`/api/libraries/${id}`, { method: "DELETE" });
 }
 
+// Restricted libraries are only visible to admins and the listed users
+export type LibraryAccess = { restricted: boolean; user_ids: number[] };
+
+export async function getLibraryAccess(id: number) {
+  const res = await apiFetch(`/api/libraries/${id}/access`);
+  return res.json() as Promise<LibraryAccess>;
+}
+
+export async function setLibraryAccess(id: number, access: LibraryAccess) {
+  const res = await apiFetch(`/api/libraries/${id}/access`, { method: "PUT", body: JSON.stringify(access) });
+  return res.json() as Promise<LibraryAccess>;
+}
+
 export type MediaItem = {
   id: number;
   library_id: number;
@@ -78,6 +192,7 @@ export type MediaItem = {
   size_bytes: number;
   last_seen_at: string;
   thumb_url?: string;
+  stream_url?: string;
 };
 
 export type PagedItems = { page: number; page_size: number; total: number; items: MediaItem[] };
@@ -101,8 +216,9 @@ export async function getItems(params: {
   return res.json() as Promise<PagedItems>;
 }
 
-export function streamUrl(itemId: number) { return `${API}/api/items/${itemId}/stream`; }
-export function thumbUrl(itemId: number) { return `${API}/api/items/${itemId}/thumb`; }
+// Media URLs come signed from the API; <img>/<video> can't send the bearer token
+export function streamUrl(item: MediaItem) { return item.stream_url ? `${API}${item.stream_url}` : ""; }
+export function thumbUrl(item: MediaItem) { return item.thumb_url ? `${API}${item.thumb_url}` : ""; }
 
 export async function getFavorites() {
   const res = await apiFetch("/api/favorites");
@@ -161,19 +277,24 @@ export async function getItemsByTag(tagId: number) {
 }
 
 // User management
-export type User = { id: number; username: string; created_at: string };
+export type Role = "admin" | "viewer";
+export type User = { id: number; username: string; role: Role; created_at: string };
 
 export async function getUsers() {
   const res = await apiFetch("/api/users");
   return res.json() as Promise<User[]>;
 }
 
-export async function createUser(username: string, password: string) {
+export async function createUser(username: string, password: string, role: Role = "viewer", mustChangePassword = false) {
   const res = await apiFetch("/api/users", {
     method: "POST",
-    body: JSON.stringify({ username, password }),
+    body: JSON.stringify({ username, password, role, must_change_password: mustChangePassword }),
   });
-  return res.json() as Promise<{ id: number; username: string }>;
+  return res.json() as Promise<{ id: number; username: string; role: Role }>;
+}
+
+export async function setUserRole(id: number, role: Role) {
+  await apiFetch(`/api/users/${id}/role`, { method: "PUT", body: JSON.stringify({ role }) });
 }
 
 export async function deleteUser(id: number) {
@@ -188,9 +309,237 @@ export async function changePassword(oldPassword: string, newPassword: string) {
   });
 }
 
+// Sessions (logins) of the current user
+export type Session = {
+  id: number;
+  device: string;
+  user_agent: string;
+  ip: string;
+  cookie: boolean;
+  current: boolean;
+  created_at: string;
+  last_seen_at: string;
+  expires_at: string;
+};
+
+export async function getSessions() {
+  const res = await apiFetch("/api/users/me/sessions");
+  return res.json() as Promise<Session[]>;
+}
+
+export async function revokeSession(id: number) {
+  await apiFetch(`/api/users/me/sessions/${id}`, { method: "DELETE" });
+}
+
+export async function revokeAllSessions(keepCurrent = true) {
+  await apiFetch(`/api/users/me/sessions${keepCurrent ? "?keep_current=true" : ""}`, { method: "DELETE" });
+}
+
+// Personal API keys for scripts: send as "Authorization: Bearer mh_..." or X-Api-Key
+export type ApiKeyScope = "read" | "scan" | "admin";
+export type ApiKey = {
+  id: number;
+  name: string;
+  prefix: string;
+  scopes: ApiKeyScope[];
+  created_at: string;
+  last_used_at: string | null;
+  expires_at: string | null;
+};
+
+export async function getApiKeys() {
+  const res = await apiFetch("/api/users/me/api-keys");
+  return res.json() as Promise<ApiKey[]>;
+}
+
+// The returned key is only shown once
+export async function createApiKey(name: string, scopes: ApiKeyScope[] = [], expiresAt?: string) {
+  const res = await apiFetch("/api/users/me/api-keys", {
+    method: "POST",
+    body: JSON.stringify({ name, scopes, expires_at: expiresAt ?? null }),
+  });
+  return res.json() as Promise<ApiKey & { key: string }>;
+}
+
+export async function deleteApiKey(id: number) {
+  await apiFetch(`/api/users/me/api-keys/${id}`, { method: "DELETE" });
+}
+
+// Two-factor authentication (TOTP) of the current user
+export type TwoFactorStatus = { enabled: boolean; recovery_codes_left: number; required: boolean };
+
+export async function getTwoFactor() {
+  const res = await apiFetch("/api/users/me/2fa");
+  return res.json() as Promise<TwoFactorStatus>;
+}
+
+// Show otpauth_uri as a QR code, or the secret for manual entry
+export async function enrollTwoFactor() {
+  const res = await apiFetch("/api/users/me/2fa/enroll", { method: "POST" });
+  return res.json() as Promise<{ secret: string; otpauth_uri: string }>;
+}
+
+// Enables 2FA; the recovery codes are only shown once
+export async function verifyTwoFactor(code: string) {
+  const res = await apiFetch("/api/users/me/2fa/verify", { method: "POST", body: JSON.stringify({ code }) });
+  return (await res.json() as { recovery_codes: string[] }).recovery_codes;
+}
+
+export async function regenerateRecoveryCodes(code: string) {
+  const res = await apiFetch("/api/users/me/2fa/recovery-codes", { method: "POST", body: JSON.stringify({ code }) });
+  return (await res.json() as { recovery_codes: string[] }).recovery_codes;
+}
+
+export async function disableTwoFactor(code: string) {
+  await apiFetch("/api/users/me/2fa", { method: "DELETE", body: JSON.stringify({ code }) });
+}
+
+// Admin: turn off 2FA of a user who lost their device and recovery codes
+export async function resetUserTwoFactor(id: number) {
+  await apiFetch(`/api/users/${id}/2fa`, { method: "DELETE" });
+}
+
+export async function getTwoFactorPolicy() {
+  const res = await apiFetch("/api/settings/2fa");
+  return res.json() as Promise<{ require_for_admins: boolean }>;
+}
+
+export async function setTwoFactorPolicy(requireForAdmins: boolean) {
+  await apiFetch("/api/settings/2fa", { method: "PUT", body: JSON.stringify({ require_for_admins: requireForAdmins }) });
+}
+
+// Share links: one item, a folder of a library or a tag, for people without an account
+export type Share = {
+  id: number;
+  kind: "item" | "folder" | "tag";
+  item_id?: number;
+  library_id?: number;
+  folder_path?: string;
+  tag_id?: number;
+  name: string;
+  prefix: string;
+  has_password: boolean;
+  allow_download: boolean;
+  views: number;
+  created_at: string;
+  last_viewed_at: string | null;
+  expires_at: string | null;
+};
+
+export type ShareTarget = { item_id: number } | { library_id: number; path: string } | { tag_id: number };
+
+// The link is only shown once
+export async function createShare(target: ShareTarget, opts: { name?: string; password?: string; allowDownload?: boolean; expiresAt?: string } = {}) {
+  const res = await apiFetch("/api/shares", {
+    method: "POST",
+    body: JSON.stringify({
+      ...target,
+      name: opts.name ?? "",
+      password: opts.password ?? "",
+      allow_download: opts.allowDownload ?? false,
+      expires_at: opts.expiresAt ?? null,
+    }),
+  });
+  const share = (await res.json()) as Share & { token: string; path: string };
+  return { ...share, url: shareUrl(share.token) };
+}
+
+export async function getShares() {
+  const res = await apiFetch("/api/shares");
+  return res.json() as Promise<Share[]>;
+}
+
+export async function deleteShare(id: number) {
+  await apiFetch(`/api/shares/${id}`, { method: "DELETE" });
+}
+
+// The page of a share in this frontend (see SharedView)
+export function shareUrl(token: string) {
+  return `${window.location.origin}/share/${token}`;
+}
+
+// Public side of a share: no login, only the token (and ?access= once unlocked)
+export type SharedItem = {
+  id: number;
+  name: string;
+  path: string;
+  kind: string;
+  size_bytes: number;
+  mtime?: string;
+  thumb_url?: string;
+  stream_url?: string;
+  download_url?: string;
+};
+
+export type SharedListing = {
+  name: string;
+  kind: "item" | "folder" | "tag";
+  allow_download: boolean;
+  expires_at: string | null;
+  password_required: boolean;
+  path: string;
+  folders: string[];
+  items: SharedItem[];
+};
+
+// Resolves with locked=true when the share needs its password first
+export async function getSharedListing(token: string, path = "", access = "") {
+  const q = new URLSearchParams();
+  if (path) q.set("path", path);
+  if (access) q.set("access", access);
+  const res = await fetch(`${API}/s/${encodeURIComponent(token)}?${q.toString()}`);
+  if (res.status === 401) return { locked: true, listing: (await res.json()) as SharedListing };
+  if (!res.ok) throw new Error((await res.text().catch(() => "")) || `HTTP ${res.status}`);
+  return { locked: false, listing: (await res.json()) as SharedListing };
+}
+
+export async function unlockShare(token: string, password: string) {
+  const res = await fetch(`${API}/s/${encodeURIComponent(token)}/unlock`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ password }),
+  });
+  if (!res.ok) throw new Error((await res.text().catch(() => "")) || `HTTP ${res.status}`);
+  return ((await res.json()) as { access: string }).access;
+}
+
+export function sharedMediaUrl(url?: string) { return url ? `${API}${url}` : ""; }
+
+// Admin: audit log of administrative and destructive actions, newest first
+export type AuditEntry = {
+  id: number;
+  created_at: string;
+  actor_id: number | null;
+  actor_name: string;
+  action: string; // e.g. library.delete, user.create
+  target_type: string;
+  target_id: string;
+  request_id: string;
+  ip: string;
+  details?: Record<string, unknown>;
+};
+
+// action is exact, or a prefix ending in "." (e.g. "user.")
+export async function getAuditLog(params: { page?: number; pageSize?: number; action?: string; actorId?: number } = {}) {
+  const sp = new URLSearchParams();
+  if (params.page) sp.set("page", String(params.page));
+  if (params.pageSize) sp.set("pageSize", String(params.pageSize));
+  if (params.action) sp.set("action", params.action);
+  if (params.actorId) sp.set("actor_id", String(params.actorId));
+  const res = await apiFetch(`/api/audit?${sp.toString()}`);
+  return res.json() as Promise<{ page: number; page_size: number; total: number; entries: AuditEntry[] }>;
+}
+
 export async function getCurrentUser() {
   const res = await apiFetch("/api/users/me");
-  return res.json() as Promise<{ id: number; username: string }>;
+  return res.json() as Promise<{
+    id: number;
+    username: string;
+    role: Role;
+    must_change_password: boolean;
+    two_factor_enabled: boolean;
+    two_factor_required: boolean; // admin without 2FA while it is required: enroll first
+  }>;
 }
 
 // Home dashboard
@@ -274,16 +623,21 @@ export async function importJellyfin(
   file: File,
   options: JellyfinImportOptions
 ): Promise<JellyfinImportResult> {
-  const token = localStorage.getItem("mh_token");
   const formData = new FormData();
   formData.append("database", file);
   formData.append("options", JSON.stringify(options));
 
-  const res = await fetch(`${API}/api/libraries/${libraryId}/import/jellyfin`, {
-    method: "POST",
-    headers: token ? { Authorization: `Bearer ${token}` } : {},
-    body: formData,
-  });
+  // Not apiFetch: the browser must set the multipart Content-Type
+  const send = () => {
+    const token = localStorage.getItem("mh_token");
+    return fetch(`${API}/api/libraries/${libraryId}/import/jellyfin`, {
+      method: "POST",
+      headers: token ? { Authorization: `Bearer ${token}` } : {},
+      body: formData,
+    });
+  };
+  let res = await send();
+  if (res.status === 401 && (await refreshTokens())) res = await send();
 
   if (!res.ok) {
     const t = await res.text().catch(() => "");
@@ -13,7 +13,7 @@ export function Card({ item, fav, onFav, onOpen }: CardProps) {
         <div className="glass card" style={{ display: 'flex', flexDirection: 'column' }}>
             <div className="thumb cursor-pointer" onClick={onOpen}>
                 {item.thumb_url ? (
-                    <img src={thumbUrl(item.id)} alt={item.rel_path} style={{ width: '100%', height: '100%', objectFit: 'cover', borderRadius: 14 }} />
+                    <img src={thumbUrl(item)} alt={item.rel_path} style={{ width: '100%', height: '100%', objectFit: 'cover', borderRadius: 14 }} />
                 ) : (
                     <span className="muted">{item.kind.toUpperCase()}</span>
                 )}
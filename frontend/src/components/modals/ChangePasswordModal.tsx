@@ -18,10 +18,6 @@ export function ChangePasswordModal({ onClose }: ChangePasswordModalProps) {
             setError('Las contraseñas no coinciden');
             return;
         }
-        if (newPass.length < 4) {
-            setError('La contraseña debe tener al menos 4 caracteres');
-            return;
-        }
         try {
             await changePassword(oldPass, newPass);
             setSuccess(true);
@@ -133,9 +133,9 @@ export function PlayerModal({ item, onClose, onPrev, onNext }: PlayerModalProps)
                         </div>
                     )}
                     {isPhoto ? (
-                        <img src={streamUrl(item.id)} className="media-display" style={{ width: '100%' }} />
+                        <img src={streamUrl(item)} className="media-display" style={{ width: '100%' }} />
                     ) : (
-                        <video ref={videoRef} src={streamUrl(item.id)} controls className="media-display" style={{ width: '100%' }} />
+                        <video ref={videoRef} src={streamUrl(item)} controls className="media-display" style={{ width: '100%' }} />
                     )}
                     {/* Right navigation zone */}
                     {onNext && (
@@ -26,7 +26,7 @@ export function FavoritesView({ favorites, setFavorites, onOpen }: FavoritesView
                     <div key={it.id} className="glass card">
                         <div className="thumb cursor-pointer" onClick={() => onOpen(it)}>
                             {it.thumb_url ? (
-                                <img src={thumbUrl(it.id)} alt={it.rel_path} style={{ width: '100%', height: '100%', objectFit: 'cover', borderRadius: 14 }} />
+                                <img src={thumbUrl(it)} alt={it.rel_path} style={{ width: '100%', height: '100%', objectFit: 'cover', borderRadius: 14 }} />
                             ) : (
                                 <span className="muted">{it.kind.toUpperCase()}</span>
                             )}
@@ -47,7 +47,7 @@ export function SearchView({ query, libraryId, onOpen, favorites, setFavorites }
         <div key={item.id} className="glass card" style={{ display: 'flex', flexDirection: 'column' }}>
             <div className="thumb cursor-pointer" onClick={() => onOpen(item)}>
                 {item.thumb_url ? (
-                    <img src={thumbUrl(item.id)} alt={item.rel_path} style={{ width: '100%', height: '100%', objectFit: 'cover', borderRadius: 14 }} />
+                    <img src={thumbUrl(item)} alt={item.rel_path} style={{ width: '100%', height: '100%', objectFit: 'cover', borderRadius: 14 }} />
                 ) : (
                     <span className="muted">{item.kind.toUpperCase()}</span>
                 )}
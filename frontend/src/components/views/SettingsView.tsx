@@ -203,10 +203,6 @@ export function SettingsView({ libraryId, onClose }: SettingsViewProps) {
             setPassError('Las contraseñas no coinciden');
             return;
         }
-        if (newPass.length < 4) {
-            setPassError('La contraseña debe tener al menos 4 caracteres');
-            return;
-        }
         setActionLoading('password');
         try {
             await changePassword(oldPass, newPass);
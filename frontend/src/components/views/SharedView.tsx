@@ -0,0 +1,103 @@
+import { useEffect, useState } from 'react';
+import { getSharedListing, sharedMediaUrl, unlockShare, type SharedItem, type SharedListing } from '../../api';
+import { bytes } from '../../utils/format';
+
+export interface SharedViewProps {
+    token: string;
+}
+
+// Public page of a share link (/share/<token>), shown without logging in
+export function SharedView({ token }: SharedViewProps) {
+    const [access, setAccess] = useState<string>(() => sessionStorage.getItem(`mh_share_${token}`) || '');
+    const [path, setPath] = useState('');
+    const [listing, setListing] = useState<SharedListing | null>(null);
+    const [locked, setLocked] = useState(false);
+    const [password, setPassword] = useState('');
+    const [open, setOpen] = useState<SharedItem | null>(null);
+    const [err, setErr] = useState<string | null>(null);
+
+    useEffect(() => {
+        getSharedListing(token, path, access)
+            .then(res => { setListing(res.listing); setLocked(res.locked); setErr(null); })
+            .catch(e => setErr(e.message));
+    }, [token, path, access]);
+
+    if (err && !listing) {
+        return <div className="container"><div className="glass login-panel"><h2 className="mt-0">MediaHub</h2><p className="muted">{err}</p></div></div>;
+    }
+    if (!listing) return null;
+
+    if (locked) {
+        return (
+            <div className="container">
+                <div className="glass login-panel">
+                    <h2 className="mt-0">{listing.name}</h2>
+                    <div className="row mb-md">
+                        <input className="input flex-1" type="password" value={password} onChange={e => setPassword(e.target.value)} placeholder="contraseña" />
+                    </div>
+                    <div className="row">
+                        <button className="btn" onClick={async () => {
+                            setErr(null);
+                            try {
+                                const a = await unlockShare(token, password);
+                                sessionStorage.setItem(`mh_share_${token}`, a);
+                                setAccess(a);
+                            } catch (e: any) { setErr(e.message); }
+                        }}>Abrir</button>
+                        {err && <span className="muted">{err}</span>}
+                    </div>
+                </div>
+            </div>
+        );
+    }
+
+    const parent = path.includes('/') ? path.slice(0, path.lastIndexOf('/')) : '';
+
+    return (
+        <div className="container">
+            <h2>{listing.name}{path && <span className="muted"> / {path}</span>}</h2>
+            {listing.expires_at && <div className="muted mb-sm">Disponible hasta {new Date(listing.expires_at).toLocaleString()}</div>}
+            {path && <button className="btn mb-sm" onClick={() => setPath(parent)}>← Volver</button>}
+            <div className="grid">
+                {listing.folders.map(f => (
+                    <div key={`f-${f}`} className="glass card cursor-pointer" onClick={() => setPath(path ? `${path}/${f}` : f)}>
+                        <div className="thumb"><span className="muted">📁</span></div>
+                        <p className="title card-title">{f}</p>
+                    </div>
+                ))}
+                {listing.items.map(it => (
+                    <div key={it.id} className="glass card">
+                        <div className="thumb cursor-pointer" onClick={() => setOpen(it)}>
+                            {it.thumb_url ? (
+                                <img src={sharedMediaUrl(it.thumb_url)} alt={it.name} style={{ width: '100%', height: '100%', objectFit: 'cover', borderRadius: 14 }} />
+                            ) : (
+                                <span className="muted">{it.kind.toUpperCase()}</span>
+                            )}
+                        </div>
+                        <div className="row justify-between">
+                            <p className="title card-title">{it.name}</p>
+                            {it.download_url && <a className="btn" href={sharedMediaUrl(it.download_url)}>⬇</a>}
+                        </div>
+                        <div className="muted">{bytes(it.size_bytes)}</div>
+                    </div>
+                ))}
+            </div>
+            {open && (
+                <div className="modal-overlay" onClick={() => setOpen(null)}>
+                    <div className="glass modal-content" onClick={e => e.stopPropagation()}>
+                        <div className="row justify-between mb-sm">
+                            <strong>{open.name}</strong>
+                            <button className="btn" onClick={() => setOpen(null)}>✕</button>
+                        </div>
+                        {open.kind === 'video' && <video src={sharedMediaUrl(open.stream_url)} controls autoPlay style={{ width: '100%' }} />}
+                        {open.kind === 'audio' && <audio src={sharedMediaUrl(open.stream_url)} controls autoPlay style={{ width: '100%' }} />}
+                        {open.kind === 'photo' && <img src={sharedMediaUrl(open.stream_url)} alt={open.name} style={{ width: '100%' }} />}
+                        {!['video', 'audio', 'photo'].includes(open.kind) && open.stream_url && (
+                            <a className="btn" href={sharedMediaUrl(open.stream_url)} target="_blank" rel="noreferrer">Abrir</a>
+                        )}
+                    </div>
+                </div>
+            )}
+        </div>
+    );
+}
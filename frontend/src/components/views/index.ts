@@ -4,3 +4,4 @@ export { TagsView, type TagsViewProps } from './TagsView';
 export { FolderBrowser, type FolderBrowserProps } from './FolderBrowser';
 export { UsersView } from './UsersView';
 export { SearchView, type SearchViewProps } from './SearchView';
+export { SharedView, type SharedViewProps } from './SharedView';
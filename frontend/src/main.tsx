@@ -1,10 +1,14 @@
 import React from 'react'
 import ReactDOM from 'react-dom/client'
 import App from './App'
+import { SharedView } from './components/views'
 import './styles.css'
 
+// Share links (/share/<token>) open without logging in
+const shareToken = window.location.pathname.match(/^\/share\/([^/]+)/)?.[1];
+
 ReactDOM.createRoot(document.getElementById('root')!).render(
   <React.StrictMode>
-    <App />
+    {shareToken ? <SharedView token={decodeURIComponent(shareToken)} /> : <App />}
   </React.StrictMode>,
 )